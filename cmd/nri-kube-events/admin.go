@@ -0,0 +1,222 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/newrelic/nri-kube-events/pkg/sinks"
+)
+
+const redacted = "<redacted>"
+
+// secretKey matches the keys whose values are not shown by the admin API.
+var secretKey = regexp.MustCompile(`(?i)(token|secret|password|passwd|license|key|auth|credential)`)
+
+// adminAPI serves runtime information about the integration, authenticated with a bearer token
+// read from tokenFile on every request, so it can be rotated.
+type adminAPI struct {
+	integration *integration
+	monitor     *monitor
+	tokenFile   string
+}
+
+// sinkInfo describes a running sink.
+type sinkInfo struct {
+	ID     string    `json:"id"`
+	Type   string    `json:"type"`
+	Paused bool      `json:"paused"`
+	Health string    `json:"health"`
+	Stats  sinkStats `json:"stats"`
+}
+
+// configStatus tells whether the configuration file is fully applied.
+type configStatus struct {
+	RestartPending  bool     `json:"restartPending"`
+	PendingSettings []string `json:"pendingSettings"`
+}
+
+func (a *adminAPI) register(mux *http.ServeMux) {
+	mux.Handle("GET /admin/config", a.authenticated(a.config))
+	mux.Handle("GET /admin/config/status", a.authenticated(a.configStatus))
+	mux.Handle("GET /admin/sinks", a.authenticated(a.sinks))
+	mux.Handle("GET /admin/sinks/{id}/recent", a.authenticated(a.recent))
+	mux.Handle("POST /admin/sinks/{id}/pause", a.authenticated(a.pause(true)))
+	mux.Handle("POST /admin/sinks/{id}/resume", a.authenticated(a.pause(false)))
+	mux.Handle("GET /admin/processors", a.authenticated(a.processors))
+}
+
+func (a *adminAPI) authenticated(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := os.ReadFile(a.tokenFile)
+		if err != nil {
+			logrus.Warnf("could not read admin token file: %v", err)
+			http.Error(w, "admin API unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		expected := strings.TrimSpace(string(token))
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || expected == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="nri-kube-events"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	})
+}
+
+// config responds with the configuration currently applied, as written in the configuration file,
+// so values read from environment variables and secret files are shown as their references.
+// Values of keys that look like credentials, and passwords in URLs, are redacted too.
+func (a *adminAPI) config(w http.ResponseWriter, _ *http.Request) {
+	cfg := a.integration.currentConfig()
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(cfg.source, &doc); err != nil {
+		http.Error(w, fmt.Sprintf("could not encode configuration: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var out []byte
+	if doc.Kind != 0 {
+		var err error
+		if out, err = yaml.Marshal(redact(&doc)); err != nil {
+			http.Error(w, fmt.Sprintf("could not encode configuration: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(out)
+}
+
+// redact replaces the values of secret-looking keys, and passwords of URLs, in the given node.
+func redact(node *yaml.Node) *yaml.Node {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if secretKey.MatchString(node.Content[i].Value) {
+				node.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Value: redacted}
+				continue
+			}
+			redact(node.Content[i+1])
+		}
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			redact(child)
+		}
+	case yaml.ScalarNode:
+		if u, err := url.Parse(node.Value); err == nil && u.User != nil {
+			if _, hasPassword := u.User.Password(); hasPassword {
+				u.User = url.UserPassword(u.User.Username(), redacted)
+				node.Value = u.String()
+			}
+		}
+	}
+
+	return node
+}
+
+// configStatus responds with the settings changed by reloads which only take effect after a restart.
+func (a *adminAPI) configStatus(w http.ResponseWriter, _ *http.Request) {
+	pending := a.integration.currentConfig().restartPending
+	writeJSON(w, configStatus{
+		RestartPending:  len(pending) > 0,
+		PendingSettings: append([]string{}, pending...),
+	})
+}
+
+// sinks responds with the active sinks, their health and their statistics.
+func (a *adminAPI) sinks(w http.ResponseWriter, _ *http.Request) {
+	cfg := a.integration.currentConfig()
+	activeSinks := a.integration.Sinks()
+	unhealthy := sinks.Health(activeSinks)
+
+	infos := make([]sinkInfo, 0, len(cfg.Sinks))
+	for _, sinkConf := range cfg.Sinks {
+		id := sinkConf.SinkID()
+		if _, ok := activeSinks[id]; !ok {
+			continue
+		}
+
+		state := a.monitor.sink(id)
+		info := sinkInfo{
+			ID:     id,
+			Type:   sinkConf.SinkType(),
+			Paused: state.paused.Load(),
+			Health: "ok",
+			Stats:  state.stats(),
+		}
+		if err := unhealthy[id]; err != nil {
+			info.Health = err.Error()
+		}
+
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+
+	writeJSON(w, infos)
+}
+
+// recent responds with the last items handled by the sink, the newest first.
+func (a *adminAPI) recent(w http.ResponseWriter, r *http.Request) {
+	state, ok := a.activeSink(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, state.recentItems())
+}
+
+// pause pauses or resumes the sink. Items received by paused sinks are skipped, not delivered later.
+func (a *adminAPI) pause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, ok := a.activeSink(w, r)
+		if !ok {
+			return
+		}
+
+		state.paused.Store(paused)
+		logrus.Infof("Sink %s paused=%t through the admin API", r.PathValue("id"), paused)
+		writeJSON(w, map[string]bool{"paused": paused})
+	}
+}
+
+// processors responds with the items seen and dropped by each processor.
+func (a *adminAPI) processors(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, a.monitor.processorStats())
+}
+
+// activeSink returns the state of the sink with the ID in the path, responding with 404 Not Found
+// if there is no active sink with that ID.
+func (a *adminAPI) activeSink(w http.ResponseWriter, r *http.Request) (*sinkState, bool) {
+	id := r.PathValue("id")
+	if _, ok := a.integration.Sinks()[id]; !ok {
+		http.Error(w, fmt.Sprintf("sink %s not found", id), http.StatusNotFound)
+		return nil, false
+	}
+
+	return a.monitor.sink(id), true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		logrus.Debugf("could not write admin API response: %v", err)
+	}
+}
@@ -3,59 +3,462 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 
+	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/expr"
+	"github.com/newrelic/nri-kube-events/pkg/pipeline"
+	"github.com/newrelic/nri-kube-events/pkg/ratelimit"
+	"github.com/newrelic/nri-kube-events/pkg/sample"
 	"github.com/newrelic/nri-kube-events/pkg/sinks"
+	"github.com/newrelic/nri-kube-events/pkg/transform"
+	"github.com/newrelic/nri-kube-events/pkg/transitions"
 )
 
-const DefaultDescribeRefresh = 24 * time.Hour
+const DefaultDescribeRefresh = pipeline.DefaultDescribeRefresh
+
+// Supported values for the eventsAPI configuration.
+const (
+	EventsAPICoreV1   = pipeline.EventsAPICoreV1
+	EventsAPIEventsV1 = pipeline.EventsAPIEventsV1
+)
 
 type config struct {
 	WorkQueueLength *int `yaml:"workQueueLength"`
 	Sinks           []sinks.SinkConfig
 
+	// EventsAPI selects the API Events are read from, either `v1` (default) or `events.k8s.io/v1`.
+	EventsAPI       string         `yaml:"eventsAPI"`
 	CaptureEvents   *bool          `yaml:"captureEvents"`
 	CaptureDescribe *bool          `yaml:"captureDescribe"`
 	DescribeRefresh *time.Duration `yaml:"describeRefresh"`
+
+	// SynthesizeEvents enables emitting events for object condition and phase transitions.
+	SynthesizeEvents *bool `yaml:"synthesizeEvents"`
+
+	// StuckThresholds reports objects staying in a phase or condition for too long.
+	StuckThresholds []transitions.Threshold `yaml:"stuckThresholds,omitempty"`
+
+	// RateLimit drops or samples the events exceeding per object and global rates, before they are queued.
+	RateLimit ratelimit.Config `yaml:"rateLimit,omitempty"`
+
+	// Sampling keeps a fraction of the events matching each rule, before they are enriched.
+	Sampling []sample.Rule `yaml:"sampling,omitempty"`
+
+	Enrichment enrichmentConfig `yaml:"enrichment"`
+
+	// Filters forward only the events and objects matching all of them, after they are enriched.
+	Filters []filterConfig `yaml:"filters,omitempty"`
+
+	// Routing restricts the sinks events and objects are sent to, after they are filtered.
+	Routing routingConfig `yaml:"routing,omitempty"`
+
+	// Transforms are applied in order to the attributes of events and descriptions before sinks send them.
+	Transforms []transform.Rule `yaml:"transforms,omitempty"`
+
+	// source is the configuration file as written, before references are expanded,
+	// so it can be shown without the secrets they point to.
+	source []byte
+
+	// restartPending lists the settings changed by reloads which only take effect after a restart.
+	// They keep the values applied at startup meanwhile.
+	restartPending []string
 }
 
-func loadConfig(file io.Reader) (config, error) {
-	var cfg config
+// enrichmentConfig defines which metadata is attached to events before they reach the sinks.
+type enrichmentConfig struct {
+	InvolvedObject involvedObjectEnrichment `yaml:"involvedObject"`
+	Namespace      namespaceEnrichment      `yaml:"namespace"`
+}
+
+// involvedObjectEnrichment attaches labels, owners, node name and the listed annotations
+// of the object an event refers to.
+type involvedObjectEnrichment struct {
+	Enabled     bool     `yaml:"enabled"`
+	Annotations []string `yaml:"annotations"`
+}
+
+// namespaceEnrichment attaches the listed labels and annotations of the namespace
+// to both events and descriptions.
+type namespaceEnrichment struct {
+	Labels      []string `yaml:"labels"`
+	Annotations []string `yaml:"annotations"`
+}
+
+func (n namespaceEnrichment) enabled() bool {
+	return len(n.Labels) > 0 || len(n.Annotations) > 0
+}
+
+// filterConfig forwards only the events and objects matching its CEL expressions.
+// Items are forwarded if there is no expression for their type.
+type filterConfig struct {
+	// Name identifies the filter in logs and in the admin API, defaults to its position.
+	Name    string `yaml:"name,omitempty"`
+	Events  string `yaml:"events,omitempty"`
+	Objects string `yaml:"objects,omitempty"`
+}
+
+// routingConfig sends events and objects to the sinks of the routes they match, either the first
+// one or all of them depending on the mode, or to the default sinks if they match none.
+// Items are sent to every sink if no route nor default is set.
+type routingConfig struct {
+	Mode    string       `yaml:"mode,omitempty"`
+	Routes  []expr.Route `yaml:"routes,omitempty"`
+	Default []string     `yaml:"default,omitempty"`
+}
+
+func (r routingConfig) enabled() bool {
+	return len(r.Routes) > 0 || len(r.Default) > 0
+}
+
+// filterName returns the name of the filter at the given position.
+func (f filterConfig) filterName(i int) string {
+	if f.Name != "" {
+		return f.Name
+	}
 
+	return fmt.Sprintf("filters[%d]", i)
+}
+
+// loadConfig parses the configuration strictly, rejecting unknown keys, and validates it.
+// References to environment variables and secret files are expanded.
+// Every problem found is reported at once, prefixed by the line it was found at.
+func loadConfig(file io.Reader) (config, error) {
 	contents, err := io.ReadAll(file)
 	if err != nil {
-		return cfg, fmt.Errorf("could not read configuration file: %w", err)
+		return config{}, fmt.Errorf("could not read configuration file: %w", err)
 	}
 
-	err = yaml.Unmarshal(contents, &cfg)
-	if err != nil {
-		return cfg, fmt.Errorf("could not parse configuration file: %w", err)
+	cfg, _, err := parseConfig(contents, true)
+	return cfg, err
+}
+
+// parseConfig parses and validates the configuration, expanding references if expand is set.
+// The contents of the secret files referenced are returned, so changes to them can be detected.
+func parseConfig(contents []byte, expand bool) (config, map[string][]byte, error) {
+	cfg := config{source: contents}
+
+	// The document node is used to expand references and to locate the errors found by validate.
+	var root yaml.Node
+	if err := yaml.Unmarshal(contents, &root); err != nil {
+		return cfg, nil, fmt.Errorf("could not parse configuration file: %w", err)
 	}
 
-	return cfg, nil
+	var errs []error
+	var secretFiles map[string][]byte
+	// lines maps the lines of the expanded document to the original ones, if they differ.
+	var lines map[int]int
+
+	if expand {
+		exp := newExpander()
+		exp.expand(&root)
+		errs = append(errs, exp.errs...)
+		secretFiles = exp.files
+
+		if exp.changed {
+			expanded, err := yaml.Marshal(&root)
+			if err != nil {
+				return cfg, secretFiles, fmt.Errorf("could not expand configuration file: %w", err)
+			}
+
+			var reencoded yaml.Node
+			if err := yaml.Unmarshal(expanded, &reencoded); err != nil {
+				return cfg, secretFiles, fmt.Errorf("could not expand configuration file: %w", err)
+			}
+
+			lines = make(map[int]int)
+			mapLines(&root, &reencoded, lines)
+			contents = expanded
+		}
+	}
+
+	// typeErrLines holds the lines with type errors, whose zero values are not validated again.
+	typeErrLines := make(map[int]bool)
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	err := decoder.Decode(&cfg)
+
+	var typeErr *yaml.TypeError
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+	case errors.As(err, &typeErr):
+		// The decoder keeps going after type errors, so the rest of the fields are still validated.
+		for _, e := range typeErr.Errors {
+			e = remapLine(e, lines)
+			errs = append(errs, errors.New(e))
+
+			if line, _ := splitLine(e); line > 0 {
+				typeErrLines[line] = true
+			}
+		}
+	default:
+		return cfg, secretFiles, fmt.Errorf("could not parse configuration file: %w", err)
+	}
+
+	errs = append(errs, cfg.validate(&root, typeErrLines, lines)...)
+	if len(errs) > 0 {
+		return cfg, secretFiles, fmt.Errorf("invalid configuration file:\n%w", errors.Join(errs...))
+	}
+
+	return cfg, secretFiles, nil
 }
 
-func mustLoadConfigFile(configFile string) config {
-	f, err := os.Open(configFile)
-	if err != nil {
-		logrus.Fatalf("could not open configuration file: %v", err)
+// clusterName returns the cluster name of the first newRelicInfra sink, if any.
+func (c config) clusterName() string {
+	for _, sinkConfig := range c.Sinks {
+		if sinkConfig.SinkType() == "newRelicInfra" {
+			return sinkConfig.Config["clusterName"]
+		}
 	}
 
-	cfg, err := loadConfig(f)
+	return ""
+}
+
+// validate checks the values of the configuration, locating the errors in the given document.
+// Values at the skipped lines are ignored. Lines of the errors found decoding sink configs are
+// translated with the given mapping, since sinks are decoded from the expanded document.
+func (c config) validate(root *yaml.Node, skipLines map[int]bool, lines map[int]int) []error {
+	var errs []error
+	addErr := func(node *yaml.Node, format string, args ...interface{}) {
+		if node != nil && skipLines[node.Line] {
+			return
+		}
+
+		msg := fmt.Sprintf(format, args...)
+		if node != nil {
+			msg = fmt.Sprintf("line %d: %s", node.Line, msg)
+		}
+		errs = append(errs, errors.New(msg))
+	}
+
+	if c.WorkQueueLength != nil && *c.WorkQueueLength <= 0 {
+		addErr(lookupNode(root, "workQueueLength"), "workQueueLength must be positive, got %d", *c.WorkQueueLength)
+	}
 
-	if errClose := f.Close(); errClose != nil {
-		logrus.Warningf("error closing config file: %v", errClose)
+	if c.DescribeRefresh != nil && *c.DescribeRefresh <= 0 {
+		addErr(lookupNode(root, "describeRefresh"), "describeRefresh must be positive, got %s", *c.DescribeRefresh)
 	}
 
+	switch c.EventsAPI {
+	case "", EventsAPICoreV1, EventsAPIEventsV1:
+	default:
+		addErr(lookupNode(root, "eventsAPI"), "unsupported eventsAPI %q, expected %q or %q", c.EventsAPI, EventsAPICoreV1, EventsAPIEventsV1)
+	}
+
+	if err := c.RateLimit.Validate(); err != nil {
+		for _, e := range common.UnwrapJoined(err) {
+			addErr(lookupNode(root, "rateLimit"), "rateLimit: %s", e)
+		}
+	}
+
+	stuckNode := lookupNode(root, "stuckThresholds")
+	if len(c.StuckThresholds) > 0 && (c.SynthesizeEvents == nil || !*c.SynthesizeEvents) {
+		addErr(stuckNode, "stuckThresholds requires synthesizeEvents")
+	}
+
+	for i, threshold := range c.StuckThresholds {
+		var thresholdNode *yaml.Node
+		if stuckNode != nil && stuckNode.Kind == yaml.SequenceNode && i < len(stuckNode.Content) {
+			thresholdNode = stuckNode.Content[i]
+		}
+
+		if err := threshold.Validate(); err != nil {
+			for _, e := range common.UnwrapJoined(err) {
+				addErr(thresholdNode, "stuckThresholds[%d]: %s", i, e)
+			}
+		}
+	}
+
+	samplingNode := lookupNode(root, "sampling")
+	for i, rule := range c.Sampling {
+		var ruleNode *yaml.Node
+		if samplingNode != nil && samplingNode.Kind == yaml.SequenceNode && i < len(samplingNode.Content) {
+			ruleNode = samplingNode.Content[i]
+		}
+
+		if err := rule.Validate(); err != nil {
+			for _, e := range common.UnwrapJoined(err) {
+				addErr(ruleNode, "sampling[%d]: %s", i, e)
+			}
+		}
+	}
+
+	filtersNode := lookupNode(root, "filters")
+	filterNames := make(map[string]bool)
+	for i, filter := range c.Filters {
+		var filterNode *yaml.Node
+		if filtersNode != nil && filtersNode.Kind == yaml.SequenceNode && i < len(filtersNode.Content) {
+			filterNode = filtersNode.Content[i]
+		}
+
+		name := filter.filterName(i)
+		if filterNames[name] {
+			addErr(filterNode, "filters[%d]: filter name %s is used more than once", i, name)
+		}
+		filterNames[name] = true
+
+		if filter.Events == "" && filter.Objects == "" {
+			addErr(filterNode, "filters[%d] (%s): events or objects expression is required", i, name)
+			continue
+		}
+
+		if _, err := expr.NewFilter(name, filter.Events, filter.Objects); err != nil {
+			for _, e := range common.UnwrapJoined(err) {
+				addErr(filterNode, "filters[%d] (%s): %s", i, name, e)
+			}
+		}
+	}
+
+	sinkIDs := make(map[string]bool, len(c.Sinks))
+	for _, sinkConf := range c.Sinks {
+		sinkIDs[sinkConf.SinkID()] = true
+	}
+
+	// routingNode is empty if routing is not a mapping, so lookups of its keys return nil.
+	routingNode := lookupNode(root, "routing")
+	if routingNode == nil {
+		routingNode = &yaml.Node{}
+	}
+
+	switch c.Routing.Mode {
+	case "", expr.RoutingFirstMatch, expr.RoutingAllMatches:
+	default:
+		addErr(lookupNode(routingNode, "mode"), "routing.mode: unsupported mode %q, expected %q or %q", c.Routing.Mode, expr.RoutingFirstMatch, expr.RoutingAllMatches)
+	}
+
+	for _, id := range c.Routing.Default {
+		if !sinkIDs[id] {
+			addErr(lookupNode(routingNode, "default"), "routing.default: unknown sink id %s", id)
+		}
+	}
+
+	routesNode := lookupNode(routingNode, "routes")
+	for i, route := range c.Routing.Routes {
+		var routeNode *yaml.Node
+		if routesNode != nil && routesNode.Kind == yaml.SequenceNode && i < len(routesNode.Content) {
+			routeNode = routesNode.Content[i]
+		}
+
+		name := route.RouteName(i)
+		for _, id := range route.Sinks {
+			if !sinkIDs[id] {
+				addErr(routeNode, "routing.routes[%d] (%s): unknown sink id %s", i, name, id)
+			}
+		}
+
+		if err := route.Validate(); err != nil {
+			for _, e := range common.UnwrapJoined(err) {
+				addErr(routeNode, "routing.routes[%d] (%s): %s", i, name, e)
+			}
+		}
+	}
+
+	transformsNode := lookupNode(root, "transforms")
+	if len(c.Transforms) > 0 {
+		// Other sinks write events and objects as they are, so they would send the attributes meant to be dropped.
+		for i, sinkConf := range c.Sinks {
+			if sinkType := sinkConf.SinkType(); sinkType != "newRelicInfra" {
+				addErr(transformsNode, "transforms are only supported by newRelicInfra sinks, sinks[%d] (%s) is %s", i, sinkConf.SinkID(), sinkType)
+			}
+		}
+	}
+
+	for i, rule := range c.Transforms {
+		var ruleNode *yaml.Node
+		if transformsNode != nil && transformsNode.Kind == yaml.SequenceNode && i < len(transformsNode.Content) {
+			ruleNode = transformsNode.Content[i]
+		}
+
+		if err := rule.Validate(); err != nil {
+			for _, e := range common.UnwrapJoined(err) {
+				addErr(ruleNode, "transforms[%d]: %s", i, e)
+			}
+		}
+	}
+
+	sinksNode := lookupNode(root, "sinks")
+	seen := make(map[string]bool)
+	for i, sinkConf := range c.Sinks {
+		var sinkNode *yaml.Node
+		if sinksNode != nil && sinksNode.Kind == yaml.SequenceNode && i < len(sinksNode.Content) {
+			sinkNode = sinksNode.Content[i]
+		}
+
+		id := sinkConf.SinkID()
+		if seen[id] {
+			addErr(sinkNode, "sinks[%d]: sink id %s is used more than once, set a unique id for each sink of the same type", i, id)
+		}
+		seen[id] = true
+
+		if id == pipeline.TransitionsHandlerName {
+			addErr(sinkNode, "sinks[%d]: sink id %s is reserved", i, id)
+		}
+
+		if err := sinks.Validate(sinkConf); err != nil {
+			for _, e := range common.UnwrapJoined(err) {
+				// Errors decoding the sink config point to the offending line already.
+				line, msg := splitLine(remapLine(e.Error(), lines))
+				if line > 0 {
+					errs = append(errs, fmt.Errorf("line %d: sinks[%d] (%s): %s", line, i, id, msg))
+					continue
+				}
+
+				addErr(sinkNode, "sinks[%d] (%s): %s", i, id, msg)
+			}
+		}
+	}
+
+	return errs
+}
+
+// lookupNode returns the value of the given key of the top level mapping, or nil if not found.
+func lookupNode(root *yaml.Node, key string) *yaml.Node {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// splitLine splits the line prefix of a yaml error message, returning 0 if not present.
+func splitLine(msg string) (int, string) {
+	var line int
+	if _, err := fmt.Sscanf(msg, "line %d:", &line); err != nil {
+		return 0, msg
+	}
+
+	return line, strings.TrimSpace(strings.SplitN(msg, ":", 2)[1])
+}
+
+// readConfigFile loads the configuration file, returning it together with a hash of its contents
+// and of the secret files it references. The hash is returned even if the configuration is invalid,
+// and is empty only if the file can't be read.
+func readConfigFile(configFile string) (config, string, error) {
+	contents, err := os.ReadFile(configFile)
 	if err != nil {
-		logrus.Fatalf("could not parse configuration file: %v", err)
+		return config{}, "", fmt.Errorf("could not open configuration file: %w", err)
 	}
 
-	return cfg
+	cfg, secretFiles, err := parseConfig(contents, true)
+	return cfg, hashConfig(contents, secretFiles), err
 }
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/newrelic/nri-kube-events/pkg/sinks"
 )
@@ -17,6 +18,7 @@ captureEvents: false
 captureDescribe: true
 describeRefresh: 3h
 workQueueLength: 1337
+synthesizeEvents: true
 sinks:
 - name: stdout
   config:
@@ -32,6 +34,7 @@ func TestConfigParse(t *testing.T) {
 	captureDescribe := true
 	describeRefresh := 3 * time.Hour
 	workQueueLength := 1337
+	synthesizeEvents := true
 
 	tests := []struct {
 		serialized string
@@ -40,10 +43,11 @@ func TestConfigParse(t *testing.T) {
 		{
 			serialized: testConf,
 			parsed: config{
-				CaptureEvents:   &captureEvents,
-				CaptureDescribe: &captureDescribe,
-				DescribeRefresh: &describeRefresh,
-				WorkQueueLength: &workQueueLength,
+				CaptureEvents:    &captureEvents,
+				CaptureDescribe:  &captureDescribe,
+				DescribeRefresh:  &describeRefresh,
+				WorkQueueLength:  &workQueueLength,
+				SynthesizeEvents: &synthesizeEvents,
 				Sinks: []sinks.SinkConfig{
 					{
 						Name: "stdout",
@@ -74,6 +78,210 @@ func TestConfigParse(t *testing.T) {
 		conf := strings.NewReader(test.serialized)
 		got, err := loadConfig(conf)
 		assert.NoError(t, err)
+
+		// Sinks also hold the YAML nodes of their config, compare the parsed values only.
+		require.Len(t, got.Sinks, len(test.parsed.Sinks))
+		for i, sink := range got.Sinks {
+			assert.Equal(t, test.parsed.Sinks[i].Name, sink.Name)
+			assert.Equal(t, test.parsed.Sinks[i].Config, sink.Config)
+		}
+		got.Sinks, test.parsed.Sinks = nil, nil
+		assert.Equal(t, test.serialized, string(got.source), "the file is kept as written")
+		got.source = nil
 		assert.Equal(t, test.parsed, got)
 	}
 }
+
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		serialized string
+		errors     []string
+	}{
+		{
+			name: "unknown keys",
+			serialized: `
+captureEvent: false
+sinks:
+- name: stdout
+  configs: {}
+enrichment:
+  involvedObject:
+    enable: true
+`,
+			errors: []string{
+				"line 2: field captureEvent not found in type main.config",
+				"line 5: field configs not found in type sinks.SinkConfig",
+				"line 8: field enable not found in type main.involvedObjectEnrichment",
+			},
+		},
+		{
+			name: "invalid values",
+			serialized: `
+workQueueLength: 0
+eventsAPI: v2
+sinks:
+- name: stdout
+- name: stdout
+- name: kafka
+- name: newRelicInfra
+  config:
+    agentEndpoint: localhost:8001
+    agentHTTPTimeout: 10
+    timeout: 10s
+`,
+			errors: []string{
+				"line 2: workQueueLength must be positive, got 0",
+				`line 3: unsupported eventsAPI "v2", expected "v1" or "events.k8s.io/v1"`,
+				"line 6: sinks[1]: sink id stdout is used more than once, set a unique id for each sink of the same type",
+				"line 7: sinks[2] (kafka): sink not found: kafka, expected any of exec, newRelicInfra, stdout",
+				"line 11: sinks[3] (newRelicInfra): cannot unmarshal !!int `10` into time.Duration",
+				"line 12: sinks[3] (newRelicInfra): field timeout not found in type sinks.newRelicInfraConfig",
+				"line 8: sinks[3] (newRelicInfra): clusterName is required",
+				`line 8: sinks[3] (newRelicInfra): agentEndpoint "localhost:8001" is not a valid http(s) URL`,
+			},
+		},
+		{
+			name: "invalid transforms",
+			serialized: `
+transforms:
+- drop: [event.metadata.*]
+  set: {team: platform}
+- rename: {"event.*": reason}
+- truncate: {keys: [event.message]}
+`,
+			errors: []string{
+				"line 3: transforms[0]: exactly one of drop, rename, set, copy or truncate must be set",
+				`line 5: transforms[1]: rename: invalid mapping "event.*" to "reason", both keys must end with * to map a prefix`,
+				"line 6: transforms[2]: truncate: maxLength must be positive, got 0",
+			},
+		},
+		{
+			name: "transforms with other sinks",
+			serialized: `
+sinks:
+- name: newRelicInfra
+  config:
+    clusterName: test
+    agentEndpoint: http://localhost:8001/v1/data
+- name: stdout
+- type: exec
+  id: webhook
+  config:
+    command: [/bin/webhook]
+transforms:
+- drop: [event.message]
+`,
+			errors: []string{
+				"line 13: transforms are only supported by newRelicInfra sinks, sinks[1] (stdout) is stdout",
+				"line 13: transforms are only supported by newRelicInfra sinks, sinks[2] (webhook) is exec",
+			},
+		},
+		{
+			name: "invalid filters",
+			serialized: `
+filters:
+- name: warnings
+  events: event.type == "Warning" && event.cont > 5
+- name: warnings
+  objects: kind == "Pod"
+- name: empty
+`,
+			errors: []string{
+				"line 3: filters[0] (warnings): events: column 33: undefined field 'cont'",
+				"line 5: filters[1]: filter name warnings is used more than once",
+				"line 7: filters[2] (empty): events or objects expression is required",
+			},
+		},
+		{
+			name: "invalid routing",
+			serialized: `
+sinks:
+- name: stdout
+routing:
+  mode: everything
+  default: [stdout, newRelicInfra]
+  routes:
+  - name: warnings
+    events: event.type == "Warning"
+    sinks: [webhook]
+  - objects: size(object)
+`,
+			errors: []string{
+				`line 5: routing.mode: unsupported mode "everything", expected "firstMatch" or "allMatches"`,
+				"line 6: routing.default: unknown sink id newRelicInfra",
+				"line 8: routing.routes[0] (warnings): unknown sink id webhook",
+				"line 11: routing.routes[1] (routes[1]): sinks are required",
+				"line 11: routing.routes[1] (routes[1]): objects: expression must return a bool, got int",
+			},
+		},
+		{
+			name: "invalid rate limit",
+			serialized: `
+rateLimit:
+  perObject: {rate: 0, burst: 10}
+  action: shed
+  sampleEvery: 10
+  summaryInterval: 1m
+`,
+			errors: []string{
+				"line 3: rateLimit: perObject.rate must be positive, got 0",
+				`line 3: rateLimit: sampleEvery requires action "sample"`,
+			},
+		},
+		{
+			name: "invalid sampling",
+			serialized: `
+sampling:
+- type: Warning
+  rate: 1
+- type: Normal
+  reason: Pulled
+  rate: 1.5
+  method: reservoir
+- reason: Scheduled
+`,
+			errors: []string{
+				"line 5: sampling[1]: rate must be between 0 and 1, got 1.5",
+				`line 5: sampling[1]: unsupported method "reservoir", expected "hash" or "random"`,
+				"line 9: sampling[2]: rate is required",
+			},
+		},
+		{
+			name: "stuck thresholds",
+			serialized: `
+stuckThresholds:
+- kind: PersistentVolumeClaim
+  phase: Pending
+  after: 10m
+- kind: Node
+  condition: Ready
+  status: Maybe
+`,
+			errors: []string{
+				"line 3: stuckThresholds requires synthesizeEvents",
+				`line 6: stuckThresholds[1]: unsupported status "Maybe", expected "True", "False" or "Unknown"`,
+				"line 6: stuckThresholds[1]: after must be positive, got 0s",
+			},
+		},
+		{
+			name:       "wrong types",
+			serialized: "workQueueLength: many\ndescribeRefresh: 0s\n",
+			errors: []string{
+				"line 1: cannot unmarshal !!str `many` into int",
+				"line 2: describeRefresh must be positive, got 0s",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadConfig(strings.NewReader(test.serialized))
+			require.Error(t, err)
+			for _, expected := range test.errors {
+				assert.Contains(t, err.Error(), expected)
+			}
+			assert.Len(t, strings.Split(err.Error(), "\n"), len(test.errors)+1, err.Error())
+		})
+	}
+}
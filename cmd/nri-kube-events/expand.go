@@ -0,0 +1,129 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// secretFilePrefix marks values that are replaced with the contents of the referenced file.
+const secretFilePrefix = "file://"
+
+// envReference matches ${VAR} and ${VAR:-default} references. A leading $ escapes them.
+var envReference = regexp.MustCompile(`\$(\$?)\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expander replaces references to environment variables and secret files in the values of a
+// configuration document. Only scalar values are expanded, so the contents of a variable or
+// file can't change the structure of the document.
+type expander struct {
+	// files holds the contents of the secret files read, nil for the ones that could not be read.
+	files   map[string][]byte
+	errs    []error
+	changed bool
+}
+
+func newExpander() *expander {
+	return &expander{files: make(map[string][]byte)}
+}
+
+func (e *expander) expand(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			e.expand(child)
+		}
+	case yaml.MappingNode:
+		// Keys are left as is.
+		for i := 1; i < len(node.Content); i += 2 {
+			e.expand(node.Content[i])
+		}
+	case yaml.ScalarNode:
+		e.expandScalar(node)
+	}
+}
+
+func (e *expander) expandScalar(node *yaml.Node) {
+	if strings.HasPrefix(node.Value, secretFilePrefix) {
+		path := strings.TrimPrefix(node.Value, secretFilePrefix)
+		contents, err := os.ReadFile(path)
+		e.files[path] = contents
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("line %d: could not read secret file: %w", node.Line, err))
+			return
+		}
+
+		// Secrets are always strings, and files usually end with a newline that is not part of them.
+		e.set(node, strings.TrimRight(string(contents), "\r\n"), "!!str")
+		return
+	}
+
+	if !strings.Contains(node.Value, "${") {
+		return
+	}
+
+	expanded := envReference.ReplaceAllStringFunc(node.Value, func(ref string) string {
+		match := envReference.FindStringSubmatch(ref)
+		if match[1] != "" {
+			return ref[1:]
+		}
+
+		if value, ok := os.LookupEnv(match[2]); ok {
+			return value
+		}
+
+		if match[3] != "" {
+			return match[4]
+		}
+
+		e.errs = append(e.errs, fmt.Errorf("line %d: environment variable %s is not set", node.Line, match[2]))
+		return ref
+	})
+
+	tag := node.Tag
+	if node.Style == 0 {
+		// Unquoted values are resolved again, so variables can be used for numbers or booleans too.
+		tag = ""
+	}
+	e.set(node, expanded, tag)
+}
+
+func (e *expander) set(node *yaml.Node, value, tag string) {
+	if node.Value == value && node.Tag == tag {
+		return
+	}
+
+	node.Value = value
+	node.Tag = tag
+	// Let the encoder pick a style suitable for the new value.
+	node.Style = 0
+	e.changed = true
+}
+
+// mapLines records, for each line of the reencoded document, the line of the same node in the
+// original document. The expanded document is reencoded to be decoded, which can move nodes around.
+func mapLines(original, reencoded *yaml.Node, lines map[int]int) {
+	if _, ok := lines[reencoded.Line]; !ok {
+		lines[reencoded.Line] = original.Line
+	}
+
+	for i, child := range reencoded.Content {
+		if i < len(original.Content) {
+			mapLines(original.Content[i], child, lines)
+		}
+	}
+}
+
+// remapLine translates the line prefix of the error message using the given mapping, if any.
+func remapLine(msg string, lines map[int]int) string {
+	line, rest := splitLine(msg)
+	if original, ok := lines[line]; ok && line > 0 {
+		return fmt.Sprintf("line %d: %s", original, rest)
+	}
+
+	return msg
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigExpansion(t *testing.T) {
+	t.Setenv("CLUSTER_NAME", "production")
+	t.Setenv("QUEUE_LENGTH", "42")
+
+	dir := t.TempDir()
+	endpointFile := filepath.Join(dir, "endpoint")
+	require.NoError(t, os.WriteFile(endpointFile, []byte("http://infra-agent:8001/v1/data\n"), 0o600))
+
+	serialized := `
+workQueueLength: ${QUEUE_LENGTH}
+sinks:
+- name: newRelicInfra
+  config:
+    clusterName: ${CLUSTER_NAME}-${REGION:-us}
+    agentEndpoint: file://` + endpointFile + `
+`
+
+	cfg, secretFiles, err := parseConfig([]byte(serialized), true)
+	require.NoError(t, err)
+
+	assert.Equal(t, 42, *cfg.WorkQueueLength)
+	require.Len(t, cfg.Sinks, 1)
+	assert.Equal(t, map[string]string{
+		"clusterName":   "production-us",
+		"agentEndpoint": "http://infra-agent:8001/v1/data",
+	}, cfg.Sinks[0].Config)
+	assert.Equal(t, map[string][]byte{endpointFile: []byte("http://infra-agent:8001/v1/data\n")}, secretFiles)
+}
+
+func TestConfigExpansion_Escaped(t *testing.T) {
+	t.Setenv("CLUSTER_NAME", "production")
+
+	cfg, _, err := parseConfig([]byte(`
+sinks:
+- name: newRelicInfra
+  config:
+    clusterName: $${CLUSTER_NAME}
+    agentEndpoint: "http://localhost:8001/$path"
+`), true)
+	require.NoError(t, err)
+	assert.Equal(t, "${CLUSTER_NAME}", cfg.Sinks[0].Config["clusterName"])
+	assert.Equal(t, "http://localhost:8001/$path", cfg.Sinks[0].Config["agentEndpoint"])
+}
+
+func TestConfigExpansion_Errors(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert")
+	require.NoError(t, os.WriteFile(certFile, []byte("line 1\nline 2\nline 3\n"), 0o600))
+
+	serialized := `
+sinks:
+- name: newRelicInfra
+  config:
+    clusterName: file://` + certFile + `
+
+    agentEndpoint: file://` + filepath.Join(dir, "missing") + `
+    agentHTTPTimeout: ${TIMEOUT}
+    timeout: 10s
+`
+
+	_, _, err := parseConfig([]byte(serialized), true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 7: could not read secret file")
+	assert.Contains(t, err.Error(), "line 8: environment variable TIMEOUT is not set")
+	// The multi-line secret and the blank line must not shift the lines of the errors found afterwards.
+	assert.Contains(t, err.Error(), "line 9: sinks[0] (newRelicInfra): field timeout not found in type sinks.newRelicInfraConfig")
+
+	_, _, err = parseConfig([]byte(serialized), false)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret file")
+	assert.NotContains(t, err.Error(), "environment variable")
+}
+
+func TestConfigExpansion_Hash(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	configFile := filepath.Join(dir, "config.yaml")
+	conf := strings.ReplaceAll(`
+sinks:
+- name: newRelicInfra
+  config:
+    clusterName: test
+    agentEndpoint: file://SECRET
+`, "SECRET", secretFile)
+	require.NoError(t, os.WriteFile(configFile, []byte(conf), 0o600))
+
+	_, missingHash, err := readConfigFile(configFile)
+	assert.Error(t, err)
+	assert.NotEmpty(t, missingHash)
+
+	require.NoError(t, os.WriteFile(secretFile, []byte("http://localhost:8001"), 0o600))
+	_, hash, err := readConfigFile(configFile)
+	require.NoError(t, err)
+	assert.NotEqual(t, missingHash, hash)
+
+	require.NoError(t, os.WriteFile(secretFile, []byte("http://localhost:8002"), 0o600))
+	_, rotatedHash, err := readConfigFile(configFile)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, rotatedHash)
+}
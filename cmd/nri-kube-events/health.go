@@ -0,0 +1,125 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/newrelic/nri-kube-events/pkg/router"
+	"github.com/newrelic/nri-kube-events/pkg/sinks"
+)
+
+// maxQueueSaturation is the fraction of a work queue in use above which the integration is not ready,
+// since informers block once the queue is full.
+const maxQueueSaturation = 0.9
+
+// healthCheck is a named check reported by the health endpoints.
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// healthHandler runs all the checks, reporting each of them in a line like the Kubernetes API server does,
+// and responds with 503 Service Unavailable if any failed.
+func healthHandler(checks []healthCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		var report strings.Builder
+		failed := false
+		for _, c := range checks {
+			if err := c.check(); err != nil {
+				failed = true
+				fmt.Fprintf(&report, "[-]%s failed: %s\n", c.name, strings.ReplaceAll(err.Error(), "\n", "; "))
+				continue
+			}
+			fmt.Fprintf(&report, "[+]%s ok\n", c.name)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			report.WriteString("check failed\n")
+		} else {
+			report.WriteString("check passed\n")
+		}
+
+		_, _ = w.Write([]byte(report.String()))
+	})
+}
+
+// pipelineStatus is the state of the pipeline inspected by the health checks.
+type pipelineStatus interface {
+	RouterStatuses() map[string]router.Status
+	InformersSynced() error
+	Sinks() map[string]sinks.Sink
+}
+
+// livenessChecks fail if the integration needs to be restarted: a router has items queued
+// but has not processed any of them for longer than stallTimeout.
+func livenessChecks(p pipelineStatus, stallTimeout time.Duration) []healthCheck {
+	return []healthCheck{
+		{name: "routers", check: func() error {
+			var errs []error
+			statuses := p.RouterStatuses()
+			for _, name := range sortedNames(statuses) {
+				if status := statuses[name]; status.Stalled(time.Now(), stallTimeout) {
+					errs = append(errs, fmt.Errorf("%s has %d items queued and processed none since %s",
+						name, status.QueueLength, status.LastProcessed.Format(time.RFC3339)))
+				}
+			}
+
+			return errors.Join(errs...)
+		}},
+	}
+}
+
+// readinessChecks fail while the integration can't deliver data: informers are not synced yet,
+// a sink is unhealthy or a work queue is close to full.
+func readinessChecks(p pipelineStatus) []healthCheck {
+	return []healthCheck{
+		{name: "informers", check: p.InformersSynced},
+		{name: "sinks", check: func() error {
+			unhealthy := sinks.Health(p.Sinks())
+			ids := make([]string, 0, len(unhealthy))
+			for id := range unhealthy {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+
+			errs := make([]error, 0, len(ids))
+			for _, id := range ids {
+				errs = append(errs, fmt.Errorf("sink %s: %w", id, unhealthy[id]))
+			}
+
+			return errors.Join(errs...)
+		}},
+		{name: "workqueue", check: func() error {
+			var errs []error
+			statuses := p.RouterStatuses()
+			for _, name := range sortedNames(statuses) {
+				if status := statuses[name]; status.Saturation() > maxQueueSaturation {
+					errs = append(errs, fmt.Errorf("%s work queue is %.0f%% full (%d/%d)",
+						name, 100*status.Saturation(), status.QueueLength, status.QueueCapacity))
+				}
+			}
+
+			return errors.Join(errs...)
+		}},
+	}
+}
+
+// sortedNames returns the names of the routers sorted, so reports are stable.
+func sortedNames(statuses map[string]router.Status) []string {
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/router"
+	"github.com/newrelic/nri-kube-events/pkg/sinks"
+)
+
+type healthSink struct {
+	health error
+}
+
+func (s *healthSink) HandleEvent(_ common.KubeEvent) error   { return nil }
+func (s *healthSink) HandleObject(_ common.KubeObject) error { return nil }
+func (s *healthSink) Health() error                          { return s.health }
+
+func get(t *testing.T, handler http.Handler, path string) (int, string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code, rec.Body.String()
+}
+
+// fakeStatus reports a pipeline in the state set by the test.
+type fakeStatus struct {
+	statuses    map[string]router.Status
+	informerErr error
+	sinks       map[string]sinks.Sink
+}
+
+func (f *fakeStatus) RouterStatuses() map[string]router.Status { return f.statuses }
+func (f *fakeStatus) InformersSynced() error                   { return f.informerErr }
+func (f *fakeStatus) Sinks() map[string]sinks.Sink             { return f.sinks }
+
+func TestHealthEndpoints(t *testing.T) {
+	sink := &healthSink{}
+	status := &fakeStatus{
+		statuses: map[string]router.Status{"eventRouter": {LastProcessed: time.Now(), QueueCapacity: 4}},
+		sinks:    map[string]sinks.Sink{"newRelicInfra": sink},
+	}
+	mux := newServeMux(status, time.Minute)
+
+	t.Run("healthy", func(t *testing.T) {
+		code, body := get(t, mux, "/healthz")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "[+]routers ok\ncheck passed\n", body)
+
+		code, body = get(t, mux, "/readyz")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "[+]informers ok\n[+]sinks ok\n[+]workqueue ok\ncheck passed\n", body)
+	})
+
+	t.Run("not ready", func(t *testing.T) {
+		status.informerErr = errors.New("informers not synced: events")
+		sink.health = errors.New("agent unreachable")
+		status.statuses["eventRouter"] = router.Status{LastProcessed: time.Now(), QueueLength: 4, QueueCapacity: 4}
+
+		code, body := get(t, mux, "/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Contains(t, body, "[-]informers failed: informers not synced: events\n")
+		assert.Contains(t, body, "[-]sinks failed: sink newRelicInfra: agent unreachable\n")
+		assert.Contains(t, body, "[-]workqueue failed: eventRouter work queue is 100% full (4/4)\n")
+		assert.Contains(t, body, "check failed\n")
+	})
+
+	t.Run("stalled router", func(t *testing.T) {
+		status.statuses["eventRouter"] = router.Status{LastProcessed: time.Now().Add(-time.Hour), QueueLength: 4, QueueCapacity: 4}
+		code, body := get(t, mux, "/healthz")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Contains(t, body, "[-]routers failed: eventRouter has 4 items queued and processed none since")
+
+		status.statuses["eventRouter"] = router.Status{LastProcessed: time.Now().Add(-time.Hour), QueueCapacity: 4}
+		code, _ = get(t, mux, "/healthz")
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		code, body := get(t, mux, "/metrics")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Contains(t, body, "go_goroutines")
+	})
+}
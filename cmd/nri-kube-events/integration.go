@@ -0,0 +1,192 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/newrelic/nri-kube-events/pkg/enrich"
+	"github.com/newrelic/nri-kube-events/pkg/expr"
+	"github.com/newrelic/nri-kube-events/pkg/pipeline"
+	"github.com/newrelic/nri-kube-events/pkg/router"
+	"github.com/newrelic/nri-kube-events/pkg/sample"
+	"github.com/newrelic/nri-kube-events/pkg/sinks"
+	"github.com/newrelic/nri-kube-events/pkg/transform"
+)
+
+// integration runs the pipeline defined by the configuration file, so a new configuration
+// can be applied to it.
+type integration struct {
+	*pipeline.Pipeline
+
+	// objectInformers is shared by the descriptions router and the enrichers,
+	// so objects are only watched and cached once.
+	objectInformers informers.SharedInformerFactory
+
+	// cfgMtx guards the configuration applied last, which is replaced on reloads
+	// and read by the admin endpoints.
+	cfgMtx sync.RWMutex
+	cfg    config
+
+	// monitor records the work of sinks and processors for the admin API, nil if it is disabled.
+	monitor *monitor
+
+	// registerer is where the metrics of the routers and of the sinks created on reloads are registered.
+	registerer prometheus.Registerer
+}
+
+// newIntegration creates the pipeline defined by the configuration, sending data to the given sinks.
+// The monitor can be nil. The cluster name defaults to the one of the newRelicInfra sink, if any.
+func newIntegration(clientset kubernetes.Interface, cfg config, activeSinks map[string]sinks.Sink,
+	m *monitor, gracePeriod time.Duration, clusterName string, reg prometheus.Registerer,
+) (*integration, error) {
+	if clusterName == "" {
+		clusterName = cfg.clusterName()
+	}
+
+	resync := DefaultDescribeRefresh
+	if cfg.DescribeRefresh != nil {
+		resync = *cfg.DescribeRefresh
+	}
+
+	i := &integration{
+		objectInformers: informers.NewSharedInformerFactory(clientset, resync),
+		cfg:             cfg,
+		monitor:         m,
+		registerer:      reg,
+	}
+	eventProcessors, objectProcessors, err := i.processors(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	i.Pipeline, err = pipeline.New(clientset,
+		pipeline.WithSinks(activeSinks),
+		pipeline.WithSinkWrapper(m.wrap),
+		pipeline.WithEventProcessors(eventProcessors...),
+		pipeline.WithObjectProcessors(objectProcessors...),
+		pipeline.WithInformerFactory(i.objectInformers),
+		pipeline.WithWorkQueueLength(cfg.WorkQueueLength), // will ignore null values
+		pipeline.WithEventsAPI(cfg.EventsAPI),
+		pipeline.WithCaptureEvents(cfg.CaptureEvents == nil || *cfg.CaptureEvents),
+		pipeline.WithCaptureDescribe(cfg.CaptureDescribe == nil || *cfg.CaptureDescribe),
+		pipeline.WithSynthesizeEvents(cfg.SynthesizeEvents != nil && *cfg.SynthesizeEvents),
+		pipeline.WithStuckThresholds(cfg.StuckThresholds...),
+		pipeline.WithRateLimit(cfg.RateLimit),
+		pipeline.WithClusterName(clusterName),
+		pipeline.WithGracePeriod(gracePeriod),
+		pipeline.WithRegisterer(reg),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// processors builds the event and object processors defined by the sampling, enrichment, filters, routing and transforms
+// configuration. Sampling runs first, so dropped events are not enriched. Filters and routes run after the enrichers,
+// so they can match the metadata attached by them.
+// Informers needed by the processors are requested from the shared factory, which must be
+// started afterwards.
+func (i *integration) processors(cfg config) ([]router.EventProcessor, []router.ObjectProcessor, error) {
+	var eventProcessors []router.EventProcessor
+	var objectProcessors []router.ObjectProcessor
+
+	if len(cfg.Sampling) > 0 {
+		sampler, err := sample.New(cfg.Sampling)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not create sampler: %w", err)
+		}
+
+		eventProcessors = append(eventProcessors, i.monitor.countEvents("sampling", sampler))
+	}
+
+	if nsCfg := cfg.Enrichment.Namespace; nsCfg.enabled() {
+		nsEnricher := enrich.NewNamespace(enrich.NamespaceStore(i.objectInformers), nsCfg.Labels, nsCfg.Annotations)
+		eventProcessors = append(eventProcessors, i.monitor.countEvents("enrichment.namespace/events", nsEnricher))
+		objectProcessors = append(objectProcessors, i.monitor.countObjects("enrichment.namespace/objects", nsEnricher))
+	}
+
+	if ioCfg := cfg.Enrichment.InvolvedObject; ioCfg.Enabled {
+		stores := enrich.InvolvedObjectStores(i.objectInformers)
+		ioEnricher := enrich.NewInvolvedObject(stores, ioCfg.Annotations)
+		eventProcessors = append(eventProcessors, i.monitor.countEvents("enrichment.involvedObject", ioEnricher))
+	}
+
+	for n, filterCfg := range cfg.Filters {
+		name := filterCfg.filterName(n)
+		filter, err := expr.NewFilter(name, filterCfg.Events, filterCfg.Objects)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not create filter %s: %w", name, err)
+		}
+
+		if filterCfg.Events != "" {
+			eventProcessors = append(eventProcessors, i.monitor.countEvents("filters/"+name+"/events", filter))
+		}
+		if filterCfg.Objects != "" {
+			objectProcessors = append(objectProcessors, i.monitor.countObjects("filters/"+name+"/objects", filter))
+		}
+	}
+
+	if cfg.Routing.enabled() {
+		table, err := expr.NewRoutingTable(cfg.Routing.Routes, cfg.Routing.Default, cfg.Routing.Mode)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not create routing table: %w", err)
+		}
+
+		eventProcessors = append(eventProcessors, i.monitor.countEvents("routing/events", table))
+		objectProcessors = append(objectProcessors, i.monitor.countObjects("routing/objects", table))
+	}
+
+	if len(cfg.Transforms) > 0 {
+		transformer, err := transform.New(cfg.Transforms)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not create transforms: %w", err)
+		}
+
+		eventProcessors = append(eventProcessors, i.monitor.countEvents("transforms/events", transformer))
+		objectProcessors = append(objectProcessors, i.monitor.countObjects("transforms/objects", transformer))
+	}
+
+	return eventProcessors, objectProcessors, nil
+}
+
+// apply creates the sinks and processors of the given configuration and swaps them into the
+// running pipeline. Nothing is replaced if any of them can't be created.
+// Changes to other settings, like the work queue length, require a restart.
+func (i *integration) apply(cfg config) error {
+	eventProcessors, objectProcessors, err := i.processors(cfg)
+	if err != nil {
+		return err
+	}
+
+	activeSinks, err := sinks.Create(cfg.Sinks, integrationVersion, sinks.WithRegisterer(i.registerer))
+	if err != nil {
+		return fmt.Errorf("could not create sinks: %w", err)
+	}
+
+	if err := i.Replace(activeSinks, eventProcessors, objectProcessors); err != nil {
+		return err
+	}
+
+	i.cfgMtx.Lock()
+	i.cfg = cfg
+	i.cfgMtx.Unlock()
+
+	return nil
+}
+
+// currentConfig returns the configuration applied last.
+func (i *integration) currentConfig() config {
+	i.cfgMtx.RLock()
+	defer i.cfgMtx.RUnlock()
+
+	return i.cfg
+}
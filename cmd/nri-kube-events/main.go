@@ -14,17 +14,14 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
-	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 
-	"github.com/newrelic/nri-kube-events/pkg/descriptions"
-	"github.com/newrelic/nri-kube-events/pkg/events"
-	"github.com/newrelic/nri-kube-events/pkg/router"
+	"github.com/newrelic/nri-kube-events/pkg/metrics"
 	"github.com/newrelic/nri-kube-events/pkg/sinks"
 )
 
@@ -38,10 +35,26 @@ var (
 	configFile = flag.String("config", "config.yaml", "location of the configuration file")
 	kubeConfig = flag.String("kubeconfig", "", "location of the k8s configuration file. Usually in ~/.kube/config")
 	logLevel   = flag.String("loglevel", "info", "Log level: [warning, info, debug]")
-	promAddr   = flag.String("promaddr", "0.0.0.0:8080", "Address to serve prometheus metrics on")
+	promAddr   = flag.String("promaddr", "0.0.0.0:8080", "Address to serve prometheus metrics and the /healthz and /readyz endpoints on")
+
+	reloadInterval = flag.Duration("reloadinterval", 30*time.Second,
+		"Interval to check the configuration file for changes, 0 disables it. SIGHUP always reloads it")
+	shutdownGracePeriod = flag.Duration("shutdowngraceperiod", 25*time.Second,
+		"Time to send queued items to the sinks when stopping, before dropping them")
+	adminTokenFile = flag.String("admintokenfile", "",
+		"File holding the bearer token of the /admin API, which is disabled if not set")
+	routerStallTimeout = flag.Duration("routerstalltimeout", 2*time.Minute,
+		"Time a router can have items queued without processing any before /healthz fails")
+	clusterName = flag.String("clustername", "",
+		"Cluster name added as the cluster label of the nri-kube-events metrics, not added if empty. "+
+			"Global rate limit summaries are reported for it, or for the clusterName of the newRelicInfra sink if empty")
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == validateCommand {
+		os.Exit(runValidate(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
 	flag.Parse()
 	setLogLevel(*logLevel, logrus.InfoLevel)
 
@@ -52,169 +65,122 @@ func main() {
 		runtime.Version(),
 		gitCommit,
 		buildDate)
-	cfg := mustLoadConfigFile(*configFile)
+	cfg, cfgHash, err := readConfigFile(*configFile)
+	if err != nil {
+		logrus.Fatalf("could not load configuration file: %v", err)
+	}
 
-	activeSinks, err := sinks.Create(cfg.Sinks, integrationVersion)
+	reg := metrics.WithClusterName(prometheus.DefaultRegisterer, *clusterName)
+	activeSinks, err := sinks.Create(cfg.Sinks, integrationVersion, sinks.WithRegisterer(reg))
 	if err != nil {
 		logrus.Fatalf("could not create sinks: %v", err)
 	}
 
-	wg := &sync.WaitGroup{}
-	stopChan := listenForStopSignal()
-
-	opts := []router.ConfigOption{
-		router.WithWorkQueueLength(cfg.WorkQueueLength), // will ignore null values
+	clientset, err := getClientset(*kubeConfig)
+	if err != nil {
+		logrus.Fatalf("could not create kubernetes client: %v", err)
 	}
 
-	if cfg.CaptureEvents == nil || *cfg.CaptureEvents {
-		eventsInformer := createEventsInformer(stopChan)
-		activeEventHandlers := make(map[string]events.EventHandler)
-
-		for name, sink := range activeSinks {
-			activeEventHandlers[name] = sink
-		}
-
-		eventRouter := events.NewRouter(eventsInformer, activeEventHandlers, opts...)
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			eventRouter.Run(stopChan)
-		}()
+	var m *monitor
+	if *adminTokenFile != "" {
+		m = newMonitor()
 	}
 
-	if cfg.CaptureDescribe == nil || *cfg.CaptureDescribe {
-		resync := DefaultDescribeRefresh
-		if cfg.DescribeRefresh != nil {
-			resync = *cfg.DescribeRefresh
-		}
-		resourceInformers := createInformers(stopChan, resync)
-		activeObjectHandlers := make(map[string]descriptions.ObjectHandler)
-
-		for name, sink := range activeSinks {
-			activeObjectHandlers[name] = sink
-		}
+	app, err := newIntegration(clientset, cfg, activeSinks, m, *shutdownGracePeriod, *clusterName, reg)
+	if err != nil {
+		logrus.Fatalf("could not create pipeline: %v", err)
+	}
 
-		descRouter := descriptions.NewRouter(resourceInformers, activeObjectHandlers, opts...)
+	ctx := listenForStopSignal()
+	stopChan := ctx.Done()
+	if err := app.Start(ctx); err != nil {
+		logrus.Fatalf("could not start pipeline: %v", err)
+	}
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			descRouter.Run(stopChan)
-		}()
+	wg := &sync.WaitGroup{}
+	reloader, err := newConfigReloader(*configFile, cfg, cfgHash, app.apply, reg)
+	if err != nil {
+		logrus.Fatalf("could not create config reloader: %v", err)
 	}
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		servePrometheus(*promAddr, stopChan)
+		reloader.Run(*reloadInterval, stopChan)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		mux := newServeMux(app, *routerStallTimeout)
+		if app.monitor != nil {
+			admin := &adminAPI{integration: app, monitor: app.monitor, tokenFile: *adminTokenFile}
+			admin.register(mux)
+			logrus.Infof("Serving admin API on %s/admin", *promAddr)
+		}
+		if err := serveHTTP(*promAddr, mux, stopChan); err != nil {
+			logrus.Fatalf("Could not serve Prometheus on %s: %v", *promAddr, err)
+		}
 	}()
 
 	wg.Wait()
+	app.Shutdown()
 	logrus.Infoln("Shutdown complete")
 }
 
-func servePrometheus(addr string, stopChan <-chan struct{}) {
-	logrus.Infof("Serving Prometheus metrics on %s", addr)
+// newServeMux returns the handler of the HTTP server, serving Prometheus metrics on any path
+// but the health endpoints.
+func newServeMux(p pipelineStatus, stallTimeout time.Duration) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/", promhttp.Handler())
+	mux.Handle("/healthz", healthHandler(livenessChecks(p, stallTimeout)))
+	mux.Handle("/readyz", healthHandler(readinessChecks(p)))
+
+	return mux
+}
+
+// serveHTTP serves the handler until stopChan is closed. An error is returned if the server fails.
+func serveHTTP(addr string, handler http.Handler, stopChan <-chan struct{}) error {
+	logrus.Infof("Serving Prometheus metrics and health checks on %s", addr)
 
 	server := &http.Server{
 		Addr:              addr,
 		ReadHeaderTimeout: 3 * time.Second,
-		Handler:           promhttp.Handler(),
+		Handler:           handler,
 	}
 
+	serveErr := make(chan error, 1)
 	go func() {
-		err := server.ListenAndServe()
-		logrus.Fatalf("Could not serve Prometheus on %s: %v", addr, err)
+		serveErr <- server.ListenAndServe()
 	}()
 
-	<-stopChan
-	err := server.Shutdown(context.Background())
-	logrus.WithError(err).Warn("Failed to gracefully shutdown prometheus server")
+	select {
+	case err := <-serveErr:
+		return err
+	case <-stopChan:
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		logrus.WithError(err).Warn("Failed to gracefully shutdown prometheus server")
+	}
+
+	return nil
 }
 
-// listenForStopSignal returns a channel that will be closed
+// listenForStopSignal returns a context that will be cancelled
 // when a SIGINT or SIGTERM signal is received
-func listenForStopSignal() <-chan struct{} {
-	stopChan := make(chan struct{})
+func listenForStopSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 		sig := <-c
 
 		logrus.Infof("%s signal detected, stopping server.", sig)
-		close(stopChan)
+		cancel()
 	}()
 
-	return stopChan
-}
-
-// createEventsInformer creates a SharedIndexInformer that will listen for Events.
-// Only events happening after creation will be returned, existing events are discarded.
-func createEventsInformer(stopChan <-chan struct{}) cache.SharedIndexInformer {
-	clientset, err := getClientset(*kubeConfig)
-	if err != nil {
-		logrus.Fatalf("could not create kubernetes client: %v", err)
-	}
-
-	// Setting resync to 0 means the SharedInformer will never refresh its internal cache against the API Server.
-	// This is important, because later on we clear the initial cache.
-	resync := time.Duration(0)
-	sharedInformers := informers.NewSharedInformerFactory(clientset, resync)
-	eventsInformer := sharedInformers.Core().V1().Events().Informer()
-
-	sharedInformers.Start(stopChan)
-
-	// wait for the internal cache to sync. This is the only time the cache will be filled,
-	// since we've set resync to 0. This behavior is very important,
-	// because we will delete the cache to prevent duplicate events from being sent.
-	// If we remove this cache-deletion and you restart nri-kube-events, we will sent lots of duplicated events
-	sharedInformers.WaitForCacheSync(stopChan)
-
-	// There doesn't seem to be a way to start a SharedInformer without local cache,
-	// So we manually delete the cached events. We are only interested in new events.
-	for _, obj := range eventsInformer.GetStore().List() {
-		if err := eventsInformer.GetStore().Delete(obj); err != nil {
-			logrus.Warningln("Unable to delete cached event, duplicated event is possible")
-		}
-	}
-
-	return eventsInformer
-}
-
-// createInformers creates a SharedIndexInformer that will listen for resources we care aobut.
-func createInformers(stopChan <-chan struct{}, resync time.Duration) []cache.SharedIndexInformer {
-	clientset, err := getClientset(*kubeConfig)
-	if err != nil {
-		logrus.Fatalf("could not create kubernetes client: %v", err)
-	}
-
-	sharedInformers := informers.NewSharedInformerFactory(clientset, resync)
-
-	cronjobsInformer := sharedInformers.Batch().V1().CronJobs().Informer()
-	daemonsetsInformer := sharedInformers.Apps().V1().DaemonSets().Informer()
-	deploymentInformer := sharedInformers.Apps().V1().Deployments().Informer()
-	namespacesInformer := sharedInformers.Core().V1().Namespaces().Informer()
-	nodesInformer := sharedInformers.Core().V1().Nodes().Informer()
-	jobsInformer := sharedInformers.Batch().V1().Jobs().Informer()
-	pvInformer := sharedInformers.Core().V1().PersistentVolumes().Informer()
-	pvcInformer := sharedInformers.Core().V1().PersistentVolumeClaims().Informer()
-	podsInformer := sharedInformers.Core().V1().Pods().Informer()
-	servicesInformer := sharedInformers.Core().V1().Services().Informer()
-
-	sharedInformers.Start(stopChan)
-
-	return []cache.SharedIndexInformer{
-		cronjobsInformer,
-		daemonsetsInformer,
-		deploymentInformer,
-		namespacesInformer,
-		nodesInformer,
-		jobsInformer,
-		pvInformer,
-		pvcInformer,
-		podsInformer,
-		servicesInformer,
-	}
+	return ctx
 }
 
 // getClientset returns a kubernetes clientset.
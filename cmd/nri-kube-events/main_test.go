@@ -0,0 +1,38 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeHTTP(t *testing.T) {
+	t.Run("address in use", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+
+		err = serveHTTP(listener.Addr().String(), http.NotFoundHandler(), make(chan struct{}))
+		assert.ErrorContains(t, err, "address already in use")
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		stopChan := make(chan struct{})
+		served := make(chan error)
+		go func() { served <- serveHTTP("127.0.0.1:0", http.NotFoundHandler(), stopChan) }()
+
+		close(stopChan)
+		select {
+		case err := <-served:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			assert.Fail(t, "serveHTTP did not return")
+		}
+	})
+}
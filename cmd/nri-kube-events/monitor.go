@@ -0,0 +1,308 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/router"
+	"github.com/newrelic/nri-kube-events/pkg/sinks"
+)
+
+// recentItemsPerSink is the amount of items handled by each sink kept for the admin API.
+const recentItemsPerSink = 50
+
+// monitor records what the sinks and processors do, for the admin API. Its state is kept by
+// sink ID and processor name, so it survives configuration reloads.
+type monitor struct {
+	mtx        sync.Mutex
+	sinks      map[string]*sinkState
+	processors map[string]*processorState
+}
+
+func newMonitor() *monitor {
+	return &monitor{
+		sinks:      make(map[string]*sinkState),
+		processors: make(map[string]*processorState),
+	}
+}
+
+// sinkState holds the statistics and the most recent items of a sink, and whether it is paused.
+type sinkState struct {
+	paused atomic.Bool
+
+	mtx           sync.Mutex
+	events        uint64
+	objects       uint64
+	failures      uint64
+	skipped       uint64
+	lastHandled   time.Time
+	lastError     string
+	lastErrorTime time.Time
+	// recent is a ring buffer, next being the position the next item is written at.
+	recent []recentItem
+	next   int
+}
+
+// recentItem summarizes an item handled by a sink.
+type recentItem struct {
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"kind"`
+	Verb       string    `json:"verb"`
+	ObjectKind string    `json:"objectKind,omitempty"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// sinkStats is a snapshot of the statistics of a sink.
+type sinkStats struct {
+	Events        uint64     `json:"events"`
+	Objects       uint64     `json:"objects"`
+	Failures      uint64     `json:"failures"`
+	Skipped       uint64     `json:"skipped"`
+	LastHandled   *time.Time `json:"lastHandled,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+}
+
+func (m *monitor) sink(id string) *sinkState {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	state, ok := m.sinks[id]
+	if !ok {
+		state = &sinkState{recent: make([]recentItem, 0, recentItemsPerSink)}
+		m.sinks[id] = state
+	}
+
+	return state
+}
+
+// wrap returns a sink that records the items handled by the given one, and skips them while paused.
+func (m *monitor) wrap(id string, sink sinks.Sink) sinks.Sink {
+	if m == nil {
+		return sink
+	}
+
+	return &monitoredSink{Sink: sink, state: m.sink(id)}
+}
+
+type monitoredSink struct {
+	sinks.Sink
+	state *sinkState
+}
+
+func (s *monitoredSink) HandleEvent(kubeEvent common.KubeEvent) error {
+	if s.state.paused.Load() {
+		s.state.skip()
+		return nil
+	}
+
+	err := s.Sink.HandleEvent(kubeEvent)
+	s.state.record(eventItem(kubeEvent), err)
+	return err
+}
+
+func (s *monitoredSink) HandleObject(kubeObject common.KubeObject) error {
+	if s.state.paused.Load() {
+		s.state.skip()
+		return nil
+	}
+
+	err := s.Sink.HandleObject(kubeObject)
+	s.state.record(objectItem(kubeObject), err)
+	return err
+}
+
+func eventItem(kubeEvent common.KubeEvent) recentItem {
+	item := recentItem{Kind: "event", Verb: kubeEvent.Verb}
+	if e := kubeEvent.Event; e != nil {
+		item.ObjectKind = e.InvolvedObject.Kind
+		item.Namespace = e.InvolvedObject.Namespace
+		item.Name = e.InvolvedObject.Name
+		item.Reason = e.Reason
+		item.Message = e.Message
+	}
+
+	return item
+}
+
+func objectItem(kubeObject common.KubeObject) recentItem {
+	item := recentItem{Kind: "object", Verb: kubeObject.Verb}
+	if kubeObject.Obj != nil {
+		item.ObjectKind = common.K8SObjGetGVK(kubeObject.Obj).Kind
+		if accessor, err := meta.Accessor(kubeObject.Obj); err == nil {
+			item.Namespace = accessor.GetNamespace()
+			item.Name = accessor.GetName()
+		}
+	}
+
+	return item
+}
+
+func (s *sinkState) skip() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.skipped++
+}
+
+func (s *sinkState) record(item recentItem, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	item.Time = time.Now()
+	if item.Kind == "event" {
+		s.events++
+	} else {
+		s.objects++
+	}
+	s.lastHandled = item.Time
+
+	if err != nil {
+		s.failures++
+		s.lastError = err.Error()
+		s.lastErrorTime = item.Time
+		item.Error = err.Error()
+	}
+
+	if len(s.recent) < cap(s.recent) {
+		s.recent = append(s.recent, item)
+	} else {
+		s.recent[s.next] = item
+	}
+	s.next = (s.next + 1) % cap(s.recent)
+}
+
+func (s *sinkState) stats() sinkStats {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	stats := sinkStats{
+		Events:    s.events,
+		Objects:   s.objects,
+		Failures:  s.failures,
+		Skipped:   s.skipped,
+		LastError: s.lastError,
+	}
+	if !s.lastHandled.IsZero() {
+		lastHandled := s.lastHandled
+		stats.LastHandled = &lastHandled
+	}
+	if !s.lastErrorTime.IsZero() {
+		lastErrorTime := s.lastErrorTime
+		stats.LastErrorTime = &lastErrorTime
+	}
+
+	return stats
+}
+
+// recentItems returns the most recent items handled by the sink, the newest first.
+func (s *sinkState) recentItems() []recentItem {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	items := make([]recentItem, 0, len(s.recent))
+	for i := 1; i <= len(s.recent); i++ {
+		items = append(items, s.recent[(s.next-i+len(s.recent))%len(s.recent)])
+	}
+
+	return items
+}
+
+// processorState counts the items seen and dropped by a processor.
+type processorState struct {
+	seen    atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// processorStats is a snapshot of the counts of a processor.
+type processorStats struct {
+	Name    string `json:"name"`
+	Seen    uint64 `json:"seen"`
+	Dropped uint64 `json:"dropped"`
+}
+
+func (m *monitor) processor(name string) *processorState {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	state, ok := m.processors[name]
+	if !ok {
+		state = &processorState{}
+		m.processors[name] = state
+	}
+
+	return state
+}
+
+// processorStats returns the counts of all the processors that ran, sorted by name.
+func (m *monitor) processorStats() []processorStats {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	stats := make([]processorStats, 0, len(m.processors))
+	for name, state := range m.processors {
+		stats = append(stats, processorStats{Name: name, Seen: state.seen.Load(), Dropped: state.dropped.Load()})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+
+	return stats
+}
+
+// countEvents returns a processor counting the events seen and dropped by the given one.
+func (m *monitor) countEvents(name string, processor router.EventProcessor) router.EventProcessor {
+	if m == nil {
+		return processor
+	}
+
+	return &countingEventProcessor{EventProcessor: processor, state: m.processor(name)}
+}
+
+// countObjects returns a processor counting the objects seen and dropped by the given one.
+func (m *monitor) countObjects(name string, processor router.ObjectProcessor) router.ObjectProcessor {
+	if m == nil {
+		return processor
+	}
+
+	return &countingObjectProcessor{ObjectProcessor: processor, state: m.processor(name)}
+}
+
+type countingEventProcessor struct {
+	router.EventProcessor
+	state *processorState
+}
+
+func (p *countingEventProcessor) ProcessEvent(kubeEvent *common.KubeEvent) bool {
+	p.state.seen.Add(1)
+	if !p.EventProcessor.ProcessEvent(kubeEvent) {
+		p.state.dropped.Add(1)
+		return false
+	}
+
+	return true
+}
+
+type countingObjectProcessor struct {
+	router.ObjectProcessor
+	state *processorState
+}
+
+func (p *countingObjectProcessor) ProcessObject(kubeObject *common.KubeObject) bool {
+	p.state.seen.Add(1)
+	if !p.ObjectProcessor.ProcessObject(kubeObject) {
+		p.state.dropped.Add(1)
+		return false
+	}
+
+	return true
+}
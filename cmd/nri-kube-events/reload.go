@@ -0,0 +1,203 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-kube-events/pkg/metrics"
+)
+
+// configReloader watches the configuration file and applies it when it changes.
+// ConfigMaps mounted as volumes are updated in place by the kubelet, so polling the
+// contents works regardless of the symlink dance involved.
+type configReloader struct {
+	path  string
+	apply func(config) error
+
+	current config
+	hash    string
+	// failedHash is the hash of the last configuration that could not be applied,
+	// so it is not retried on every poll.
+	failedHash string
+
+	configInfo   *prometheus.GaugeVec
+	reloadsTotal *prometheus.CounterVec
+}
+
+// newConfigReloader returns a reloader of the configuration file, registering its metrics with reg.
+// Reloaders sharing a registerer share their metrics.
+func newConfigReloader(path string, current config, hash string, apply func(config) error, reg prometheus.Registerer) (*configReloader, error) {
+	configInfo, infoErr := metrics.Register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "kube_events",
+		Name:      "config_info",
+		Help:      "Hash of the currently loaded configuration file, as a label. The value is always 1",
+	}, []string{"hash"}))
+	reloadsTotal, reloadsErr := metrics.Register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "kube_events",
+		Name:      "config_reloads_total",
+		Help:      "Total amount of configuration reloads, per result",
+	}, []string{"result"}))
+
+	if err := errors.Join(infoErr, reloadsErr); err != nil {
+		return nil, fmt.Errorf("could not register config reload metrics: %w", err)
+	}
+
+	r := &configReloader{
+		path:         path,
+		apply:        apply,
+		current:      current,
+		hash:         hash,
+		configInfo:   configInfo,
+		reloadsTotal: reloadsTotal,
+	}
+	r.setConfigHash(hash)
+
+	return r, nil
+}
+
+// Run reloads the configuration every interval if it has changed, and unconditionally on SIGHUP.
+// A zero interval disables polling.
+func (r *configReloader) Run(interval time.Duration, stopChan <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-tick:
+			r.reload(false)
+		case <-hup:
+			logrus.Infof("SIGHUP signal detected, reloading configuration")
+			r.reload(true)
+		}
+	}
+}
+
+// reload applies the configuration file if its contents, or the ones of the secret files it
+// references, changed. It is applied unconditionally if force is set.
+// It returns true if a new configuration was applied.
+func (r *configReloader) reload(force bool) bool {
+	cfg, hash, err := readConfigFile(r.path)
+	if hash == "" {
+		logrus.Warnf("could not read configuration file: %v", err)
+		r.reloadsTotal.WithLabelValues("failure").Inc()
+		return false
+	}
+
+	if !force && (hash == r.hash || hash == r.failedHash) {
+		return false
+	}
+
+	if err == nil {
+		cfg.restartPending = restartRequired(r.current, cfg)
+		keepRestartRequired(&cfg, r.current)
+		err = r.apply(cfg)
+	}
+
+	if err != nil {
+		logrus.Errorf("could not reload configuration, keeping the previous one: %v", err)
+		r.reloadsTotal.WithLabelValues("failure").Inc()
+		r.failedHash = hash
+		return false
+	}
+
+	for _, field := range cfg.restartPending {
+		logrus.Warnf("%s changed, restart nri-kube-events to apply it", field)
+	}
+
+	logrus.Infof("Configuration reloaded, hash %s", hash)
+	r.reloadsTotal.WithLabelValues("success").Inc()
+	r.setConfigHash(hash)
+	r.current = cfg
+	r.hash = hash
+	r.failedHash = ""
+
+	return true
+}
+
+// restartRequiredFields lists the settings which can't be applied to the running routers,
+// returning a pointer to each of them in the given configuration.
+var restartRequiredFields = []struct {
+	name  string
+	field func(cfg *config) interface{}
+}{
+	{"workQueueLength", func(cfg *config) interface{} { return &cfg.WorkQueueLength }},
+	{"eventsAPI", func(cfg *config) interface{} { return &cfg.EventsAPI }},
+	{"captureEvents", func(cfg *config) interface{} { return &cfg.CaptureEvents }},
+	{"captureDescribe", func(cfg *config) interface{} { return &cfg.CaptureDescribe }},
+	{"describeRefresh", func(cfg *config) interface{} { return &cfg.DescribeRefresh }},
+	{"synthesizeEvents", func(cfg *config) interface{} { return &cfg.SynthesizeEvents }},
+	{"stuckThresholds", func(cfg *config) interface{} { return &cfg.StuckThresholds }},
+	{"rateLimit", func(cfg *config) interface{} { return &cfg.RateLimit }},
+}
+
+// restartRequired returns the settings that differ between both configurations
+// and can't be applied to the running routers.
+func restartRequired(old, new config) []string {
+	var changed []string
+	for _, f := range restartRequiredFields {
+		if !reflect.DeepEqual(f.field(&old), f.field(&new)) {
+			changed = append(changed, f.name)
+		}
+	}
+
+	return changed
+}
+
+// keepRestartRequired sets the settings of cfg which can't be applied to the running routers
+// to the values they are running with, so the stored configuration matches what is applied.
+func keepRestartRequired(cfg *config, running config) {
+	for _, f := range restartRequiredFields {
+		reflect.ValueOf(f.field(cfg)).Elem().Set(reflect.ValueOf(f.field(&running)).Elem())
+	}
+}
+
+// hashConfig returns the hash of the configuration file and of the secret files it references.
+func hashConfig(contents []byte, secretFiles map[string][]byte) string {
+	hash := sha256.New()
+	hash.Write(contents)
+
+	paths := make([]string, 0, len(secretFiles))
+	for path := range secretFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		hash.Write([]byte{0})
+		hash.Write([]byte(path))
+		hash.Write([]byte{0})
+		hash.Write(secretFiles[path])
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (r *configReloader) setConfigHash(hash string) {
+	r.configInfo.Reset()
+	r.configInfo.WithLabelValues(hash).Set(1)
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/nri-kube-events/pkg/ratelimit"
+)
+
+const reloadTestConf = `
+sinks:
+- name: stdout
+`
+
+func writeConfig(t *testing.T, path, contents string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+}
+
+func TestConfigReloader_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, reloadTestConf)
+
+	cfg, hash, err := readConfigFile(path)
+	require.NoError(t, err)
+
+	var applied []config
+	var applyErr error
+	reloader, err := newConfigReloader(path, cfg, hash, func(c config) error {
+		if applyErr != nil {
+			return applyErr
+		}
+		applied = append(applied, c)
+		return nil
+	}, prometheus.NewRegistry())
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(reloader.configInfo.WithLabelValues(hash)))
+
+	t.Run("unchanged file is not applied", func(t *testing.T) {
+		assert.False(t, reloader.reload(false))
+		assert.Empty(t, applied)
+	})
+
+	t.Run("forced reload applies unchanged file", func(t *testing.T) {
+		assert.True(t, reloader.reload(true))
+		assert.Len(t, applied, 1)
+	})
+
+	t.Run("changed file is applied", func(t *testing.T) {
+		writeConfig(t, path, reloadTestConf+"enrichment:\n  involvedObject:\n    enabled: true\n")
+		assert.True(t, reloader.reload(false))
+		require.Len(t, applied, 2)
+		assert.True(t, applied[1].Enrichment.InvolvedObject.Enabled)
+		assert.Empty(t, applied[1].restartPending)
+		assert.NotEqual(t, hash, reloader.hash)
+	})
+
+	t.Run("settings requiring a restart keep their running values", func(t *testing.T) {
+		writeConfig(t, path, reloadTestConf+"workQueueLength: 10\n")
+		assert.True(t, reloader.reload(false))
+		require.Len(t, applied, 3)
+		assert.Nil(t, applied[2].WorkQueueLength)
+		assert.Equal(t, []string{"workQueueLength"}, applied[2].restartPending)
+		assert.Nil(t, reloader.current.WorkQueueLength)
+
+		writeConfig(t, path, reloadTestConf)
+		assert.True(t, reloader.reload(false))
+		require.Len(t, applied, 4)
+		assert.Empty(t, applied[3].restartPending, "reverting the settings clears the pending restart")
+	})
+
+	t.Run("invalid file keeps the previous configuration", func(t *testing.T) {
+		previousHash := reloader.hash
+		writeConfig(t, path, "sinks: [")
+		assert.False(t, reloader.reload(false))
+		assert.Len(t, applied, 4)
+		assert.Equal(t, previousHash, reloader.hash)
+	})
+
+	t.Run("failing apply keeps the previous configuration and is not retried", func(t *testing.T) {
+		previousHash := reloader.hash
+		applyErr = errors.New("sink not found")
+		writeConfig(t, path, reloadTestConf+"enrichment:\n  involvedObject:\n    enabled: true\n")
+		assert.False(t, reloader.reload(false))
+		assert.Equal(t, previousHash, reloader.hash)
+		assert.False(t, reloader.current.Enrichment.InvolvedObject.Enabled)
+
+		applyErr = nil
+		assert.False(t, reloader.reload(false), "the same failing configuration should not be retried")
+		assert.True(t, reloader.reload(true), "forced reloads retry it")
+		assert.True(t, reloader.current.Enrichment.InvolvedObject.Enabled)
+	})
+}
+
+func TestRestartRequired(t *testing.T) {
+	enabled := true
+	length := 10
+
+	assert.Empty(t, restartRequired(config{}, config{}))
+	assert.Empty(t, restartRequired(config{}, config{
+		Enrichment: enrichmentConfig{InvolvedObject: involvedObjectEnrichment{Enabled: true}},
+	}))
+	assert.Equal(t,
+		[]string{"workQueueLength", "eventsAPI", "synthesizeEvents", "rateLimit"},
+		restartRequired(config{}, config{
+			WorkQueueLength:  &length,
+			EventsAPI:        EventsAPIEventsV1,
+			SynthesizeEvents: &enabled,
+			RateLimit:        ratelimit.Config{PerObject: &ratelimit.Limit{Rate: 1, Burst: 10}},
+		}),
+	)
+}
+
+func TestKeepRestartRequired(t *testing.T) {
+	length := 10
+	running := config{WorkQueueLength: &length, EventsAPI: EventsAPIEventsV1}
+	cfg := config{Enrichment: enrichmentConfig{InvolvedObject: involvedObjectEnrichment{Enabled: true}}}
+
+	keepRestartRequired(&cfg, running)
+	assert.Empty(t, restartRequired(running, cfg))
+	assert.True(t, cfg.Enrichment.InvolvedObject.Enabled, "other settings are kept")
+}
+
+func TestConfigReloader_ReloadRotatedSecret(t *testing.T) {
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "cluster-name")
+	writeConfig(t, secretPath, "first")
+	path := filepath.Join(dir, "config.yaml")
+	writeConfig(t, path, `
+sinks:
+- name: newRelicInfra
+  config:
+    clusterName: file://`+secretPath+`
+    agentEndpoint: http://localhost:8001/v1/data
+`)
+
+	cfg, hash, err := readConfigFile(path)
+	require.NoError(t, err)
+
+	var applied []config
+	reloader, err := newConfigReloader(path, cfg, hash, func(c config) error {
+		applied = append(applied, c)
+		return nil
+	}, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	assert.False(t, reloader.reload(false))
+
+	writeConfig(t, secretPath, "second")
+	assert.True(t, reloader.reload(false))
+	require.Len(t, applied, 1)
+	assert.Equal(t, "second", applied[0].Sinks[0].Config["clusterName"])
+}
+
+func TestNewConfigReloader_SharedRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	first, err := newConfigReloader("config.yaml", config{}, "first", nil, reg)
+	require.NoError(t, err)
+	second, err := newConfigReloader("config.yaml", config{}, "second", nil, reg)
+	require.NoError(t, err)
+
+	assert.Same(t, first.configInfo, second.configInfo, "reloaders sharing a registerer share their metrics")
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	validateCommand = "validate"
+	// configMapKey is the key of the ConfigMap data holding the configuration file.
+	configMapKey = "config.yaml"
+)
+
+// configSource is a configuration to validate, together with where it was found.
+type configSource struct {
+	name     string
+	contents []byte
+}
+
+// runValidate implements the `validate` subcommand and returns the exit code of the process.
+// The file can be a configuration file, or a manifest holding one or more ConfigMaps with a
+// config.yaml key, like the output of `helm template`.
+func runValidate(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet(validateCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	path := flags.String("config", "config.yaml",
+		"location of the configuration file or of a manifest with nri-kube-events ConfigMaps, - reads from stdin")
+	expand := flags.Bool("expand", true,
+		"expand environment variables and secret files, disable it to validate configurations outside of the cluster")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var contents []byte
+	var err error
+	if *path == "-" {
+		contents, err = io.ReadAll(stdin)
+	} else {
+		contents, err = os.ReadFile(*path)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "could not read %s: %v\n", *path, err)
+		return 1
+	}
+
+	sources, err := configSources(*path, contents)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+
+	exitCode := 0
+	for _, source := range sources {
+		if _, _, err := parseConfig(source.contents, *expand); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", source.name, err)
+			exitCode = 1
+			continue
+		}
+
+		fmt.Fprintf(stdout, "%s: configuration is valid\n", source.name)
+	}
+
+	return exitCode
+}
+
+// configSources returns the configurations held by the ConfigMaps of a manifest.
+// If the contents are not a manifest, they are returned as a configuration file.
+func configSources(path string, contents []byte) ([]configSource, error) {
+	var sources []configSource
+	foundManifest := false
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	for {
+		var doc struct {
+			Kind     string `yaml:"kind"`
+			Metadata struct {
+				Name string `yaml:"name"`
+			} `yaml:"metadata"`
+			Data map[string]string `yaml:"data"`
+		}
+
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// Not a manifest, let loadConfig report the problem.
+			return []configSource{{name: path, contents: contents}}, nil
+		}
+
+		if doc.Kind == "" {
+			continue
+		}
+		foundManifest = true
+
+		if config, ok := doc.Data[configMapKey]; doc.Kind == "ConfigMap" && ok {
+			sources = append(sources, configSource{
+				name:     fmt.Sprintf("%s: ConfigMap %s, key %s", path, doc.Metadata.Name, configMapKey),
+				contents: []byte(config),
+			})
+		}
+	}
+
+	if !foundManifest {
+		return []configSource{{name: path, contents: contents}}, nil
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%s: no ConfigMap with a %s key found", path, configMapKey)
+	}
+
+	return sources, nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const renderedManifest = `---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: nri-kube-events
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nri-kube-events-agent-config
+data:
+  newrelic-infra.yml: |-
+    http_server_enabled: true
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: nri-kube-events-config
+data:
+  config.yaml: |-
+    sinks:
+      - name: newRelicInfra
+        config:
+          agentEndpoint: http://localhost:8001/v1/data
+          clusterName: test
+          agentHTTPTimeout: 30s
+    captureDescribe: true
+    describeRefresh: 24h
+    captureEvents: true
+`
+
+func TestRunValidate(t *testing.T) {
+	dir := t.TempDir()
+	validConfig := filepath.Join(dir, "valid.yaml")
+	require.NoError(t, os.WriteFile(validConfig, []byte(testConf), 0o600))
+	invalidConfig := filepath.Join(dir, "invalid.yaml")
+	require.NoError(t, os.WriteFile(invalidConfig, []byte("captureEvent: true\n"), 0o600))
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		exitCode int
+		stdout   string
+		stderr   string
+	}{
+		{
+			name:   "valid configuration file",
+			args:   []string{"--config", validConfig},
+			stdout: validConfig + ": configuration is valid",
+		},
+		{
+			name:     "invalid configuration file",
+			args:     []string{"-config", invalidConfig},
+			exitCode: 1,
+			stderr:   "line 1: field captureEvent not found in type main.config",
+		},
+		{
+			name:   "rendered manifest from stdin",
+			args:   []string{"--config", "-"},
+			stdin:  renderedManifest,
+			stdout: "-: ConfigMap nri-kube-events-config, key config.yaml: configuration is valid",
+		},
+		{
+			name:     "invalid rendered manifest",
+			args:     []string{"--config", "-"},
+			stdin:    strings.Replace(renderedManifest, "24h", "1d", 1),
+			exitCode: 1,
+			stderr:   "-: ConfigMap nri-kube-events-config, key config.yaml: invalid configuration file:",
+		},
+		{
+			name:     "manifest without configuration",
+			args:     []string{"--config", "-"},
+			stdin:    "kind: Namespace\nmetadata:\n  name: test\n",
+			exitCode: 1,
+			stderr:   "no ConfigMap with a config.yaml key found",
+		},
+		{
+			name:     "unset variables",
+			args:     []string{"--config", "-"},
+			stdin:    "workQueueLength: ${QUEUE_LENGTH}\n",
+			exitCode: 1,
+			stderr:   "line 1: environment variable QUEUE_LENGTH is not set",
+		},
+		{
+			name:   "unexpanded variables",
+			args:   []string{"--config", "-", "--expand=false"},
+			stdin:  "sinks:\n- name: newRelicInfra\n  config:\n    clusterName: ${CLUSTER_NAME}\n    agentEndpoint: http://localhost\n",
+			stderr: "",
+			stdout: "-: configuration is valid",
+		},
+		{
+			name:     "missing file",
+			args:     []string{"--config", filepath.Join(dir, "missing.yaml")},
+			exitCode: 1,
+			stderr:   "could not read",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			exitCode := runValidate(test.args, strings.NewReader(test.stdin), stdout, stderr)
+
+			assert.Equal(t, test.exitCode, exitCode, stderr.String())
+			assert.Contains(t, stdout.String(), test.stdout)
+			assert.Contains(t, stderr.String(), test.stderr)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Package common ...
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package common
+
+import (
+	v1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+)
+
+// EventFromEventsV1 maps an `events.k8s.io/v1` Event into a core/v1 Event, so sinks handle
+// both APIs the same way. The core Event already holds the newer fields (series, related,
+// action, reporting controller and instance). The deprecated fields are used as fallback:
+//
+//   - regarding is the involvedObject, and note the message.
+//   - count is taken from the series if present, since events.k8s.io/v1 deduplicates
+//     recurring events into a series instead of increasing the deprecated count.
+//   - first and last timestamps default to the event time and the last observed time of the series.
+func EventFromEventsV1(e *eventsv1.Event) *v1.Event {
+	event := &v1.Event{
+		ObjectMeta:          e.ObjectMeta,
+		InvolvedObject:      e.Regarding,
+		Related:             e.Related,
+		Reason:              e.Reason,
+		Message:             e.Note,
+		Type:                e.Type,
+		Action:              e.Action,
+		EventTime:           e.EventTime,
+		ReportingController: e.ReportingController,
+		ReportingInstance:   e.ReportingInstance,
+		Source:              e.DeprecatedSource,
+		FirstTimestamp:      e.DeprecatedFirstTimestamp,
+		LastTimestamp:       e.DeprecatedLastTimestamp,
+		Count:               e.DeprecatedCount,
+	}
+
+	if e.Series != nil {
+		event.Series = &v1.EventSeries{
+			Count:            e.Series.Count,
+			LastObservedTime: e.Series.LastObservedTime,
+		}
+		event.Count = e.Series.Count
+
+		if event.LastTimestamp.IsZero() {
+			event.LastTimestamp.Time = e.Series.LastObservedTime.Time
+		}
+	}
+
+	if event.Count == 0 {
+		event.Count = 1
+	}
+
+	if event.FirstTimestamp.IsZero() {
+		event.FirstTimestamp.Time = e.EventTime.Time
+	}
+
+	if event.LastTimestamp.IsZero() {
+		event.LastTimestamp = event.FirstTimestamp
+	}
+
+	return event
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package common_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+func TestEventFromEventsV1(t *testing.T) {
+	eventTime := metav1.NewMicroTime(time.Unix(1000, 0))
+	lastObserved := metav1.NewMicroTime(time.Unix(2000, 0))
+	regarding := v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web"}
+	related := &v1.ObjectReference{Kind: "Node", Name: "worker-1"}
+
+	tests := []struct {
+		name     string
+		input    *eventsv1.Event
+		expected *v1.Event
+	}{
+		{
+			name: "series",
+			input: &eventsv1.Event{
+				ObjectMeta:          metav1.ObjectMeta{Name: "web.123", Namespace: "default"},
+				EventTime:           eventTime,
+				Series:              &eventsv1.EventSeries{Count: 5, LastObservedTime: lastObserved},
+				ReportingController: "kubelet",
+				ReportingInstance:   "kubelet-worker-1",
+				Action:              "Pulling",
+				Reason:              "BackOff",
+				Regarding:           regarding,
+				Related:             related,
+				Note:                "Back-off pulling image",
+				Type:                v1.EventTypeWarning,
+			},
+			expected: &v1.Event{
+				ObjectMeta:          metav1.ObjectMeta{Name: "web.123", Namespace: "default"},
+				InvolvedObject:      regarding,
+				Related:             related,
+				Reason:              "BackOff",
+				Message:             "Back-off pulling image",
+				Type:                v1.EventTypeWarning,
+				Action:              "Pulling",
+				EventTime:           eventTime,
+				ReportingController: "kubelet",
+				ReportingInstance:   "kubelet-worker-1",
+				Series:              &v1.EventSeries{Count: 5, LastObservedTime: lastObserved},
+				Count:               5,
+				FirstTimestamp:      metav1.NewTime(eventTime.Time),
+				LastTimestamp:       metav1.NewTime(lastObserved.Time),
+			},
+		},
+		{
+			name: "deprecated fields",
+			input: &eventsv1.Event{
+				Regarding:                regarding,
+				Note:                     "Started container",
+				DeprecatedSource:         v1.EventSource{Component: "kubelet", Host: "worker-1"},
+				DeprecatedFirstTimestamp: metav1.NewTime(time.Unix(10, 0)),
+				DeprecatedLastTimestamp:  metav1.NewTime(time.Unix(20, 0)),
+				DeprecatedCount:          3,
+			},
+			expected: &v1.Event{
+				InvolvedObject: regarding,
+				Message:        "Started container",
+				Source:         v1.EventSource{Component: "kubelet", Host: "worker-1"},
+				FirstTimestamp: metav1.NewTime(time.Unix(10, 0)),
+				LastTimestamp:  metav1.NewTime(time.Unix(20, 0)),
+				Count:          3,
+			},
+		},
+		{
+			name: "single occurrence",
+			input: &eventsv1.Event{
+				EventTime: eventTime,
+				Regarding: regarding,
+				Note:      "Scheduled",
+			},
+			expected: &v1.Event{
+				InvolvedObject: regarding,
+				Message:        "Scheduled",
+				EventTime:      eventTime,
+				Count:          1,
+				FirstTimestamp: metav1.NewTime(eventTime.Time),
+				LastTimestamp:  metav1.NewTime(eventTime.Time),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, common.EventFromEventsV1(tt.input))
+		})
+	}
+}
@@ -8,12 +8,65 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+const (
+	// Component is set as the source of all the synthesized events.
+	Component = "nri-kube-events"
+	// ReportingController is set as the reporting controller of all the synthesized events.
+	ReportingController = "newrelic.com/nri-kube-events"
+)
+
 // KubeEvent represents a Kubernetes event. It specifies if this is the first
 // time the event is seen or if it's an update to a previous event.
+// Synthesized events are not read from the API server, but generated by
+// nri-kube-events itself, e.g. from object condition transitions.
 type KubeEvent struct {
-	Verb     string    `json:"verb"`
-	Event    *v1.Event `json:"event"`
-	OldEvent *v1.Event `json:"old_event,omitempty"`
+	Verb        string    `json:"verb"`
+	Event       *v1.Event `json:"event"`
+	OldEvent    *v1.Event `json:"old_event,omitempty"`
+	Synthesized bool      `json:"synthesized,omitempty"`
+
+	// InvolvedObject holds metadata of the object the event refers to,
+	// if the event has been enriched.
+	InvolvedObject *ObjectMetadata `json:"involvedObject,omitempty"`
+
+	// Namespace holds metadata of the namespace of the involved object,
+	// if the event has been enriched.
+	Namespace *NamespaceMetadata `json:"namespace,omitempty"`
+
+	// SampleRate is the fraction of the events like this one which are kept, if sampling is enabled,
+	// so counts can be re-weighted by its inverse.
+	SampleRate float64 `json:"sampleRate,omitempty"`
+
+	// Transform is applied by sinks to the attributes they build out of the event, if set.
+	Transform AttributeTransform `json:"-"`
+
+	// Sinks restricts the sinks the event is forwarded to, by ID, if not nil.
+	// It is set by processors routing events to different sinks.
+	Sinks []string `json:"-"`
+}
+
+// AttributeTransform modifies the flattened attributes of an event or object description
+// before a sink sends them. It must be safe for concurrent use.
+type AttributeTransform interface {
+	Apply(attrs map[string]interface{})
+}
+
+// ObjectMetadata describes the object an event refers to.
+// Owners holds the chain of controllers owning the object, starting by the closest one,
+// e.g. a Pod is owned by a ReplicaSet which is owned by a Deployment.
+// Owner is the last one of the chain, the Deployment in the former example.
+type ObjectMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Owners      []OwnerReference  `json:"owners,omitempty"`
+	Owner       *OwnerReference   `json:"owner,omitempty"`
+	NodeName    string            `json:"nodeName,omitempty"`
+}
+
+// OwnerReference identifies the owner of an object.
+type OwnerReference struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
 }
 
 // KubeObject represents a Kubernetes runtime object.
@@ -23,4 +76,21 @@ type KubeObject struct {
 	Verb   string         `json:"verb"`
 	Obj    runtime.Object `json:"obj"`
 	OldObj runtime.Object `json:"old_obj,omitempty"`
+
+	// Namespace holds metadata of the namespace of the object,
+	// if the object has been enriched.
+	Namespace *NamespaceMetadata `json:"namespace,omitempty"`
+
+	// Transform is applied by sinks to the attributes they build out of the object, if set.
+	Transform AttributeTransform `json:"-"`
+
+	// Sinks restricts the sinks the object is forwarded to, by ID, if not nil.
+	// It is set by processors routing objects to different sinks.
+	Sinks []string `json:"-"`
+}
+
+// NamespaceMetadata holds the selected labels and annotations of a namespace.
+type NamespaceMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
@@ -125,3 +125,13 @@ func FlattenStruct(v interface{}) (map[string]interface{}, error) {
 
 	return m, nil
 }
+
+// UnwrapJoined splits an error created by errors.Join, so each one can be reported or prefixed on its own.
+// Any other error is returned as the only element.
+func UnwrapJoined(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+
+	return []error{err}
+}
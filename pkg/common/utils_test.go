@@ -3,6 +3,8 @@
 package common_test
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -86,3 +88,14 @@ func TestFlattenStruct(t *testing.T) {
 
 	assert.Equal(t, want, got)
 }
+
+func TestUnwrapJoined(t *testing.T) {
+	first, second := errors.New("first"), errors.New("second")
+
+	assert.Equal(t, []error{first, second}, common.UnwrapJoined(errors.Join(first, second)))
+	assert.Equal(t, []error{first}, common.UnwrapJoined(first))
+
+	// Only the errors joined at the top level are split.
+	wrapped := fmt.Errorf("wrapped: %w", errors.Join(first, second))
+	assert.Equal(t, []error{wrapped}, common.UnwrapJoined(wrapped))
+}
@@ -4,38 +4,56 @@
 package descriptions
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/cache"
 
 	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/metrics"
 	"github.com/newrelic/nri-kube-events/pkg/router"
 )
 
-var (
-	requestDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: "nr",
+// routerMetrics holds the metrics of a router, labeled by sink.
+type routerMetrics struct {
+	requestDuration *prometheus.HistogramVec
+	received        *prometheus.CounterVec
+	failures        *prometheus.CounterVec
+}
+
+// newRouterMetrics registers the metrics of a router. Routers sharing a registerer share them.
+func newRouterMetrics(reg prometheus.Registerer) (routerMetrics, error) {
+	requestDuration, durationErr := metrics.Register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
 		Subsystem: "k8s_descriptions",
 		Name:      "sink_request_duration_seconds",
 		Help:      "Duration of requests for each sink",
-	}, []string{"sink"})
-	descsReceivedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
-		Namespace: "nr",
+	}, []string{"sink"}))
+	received, receivedErr := metrics.Register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
 		Subsystem: "k8s_descriptions",
 		Name:      "received",
 		Help:      "Total amount of descriptions received per sink, including failures",
-	}, []string{"sink"})
-	descsFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
-		Namespace: "nr",
+	}, []string{"sink"}))
+	failures, failuresErr := metrics.Register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
 		Subsystem: "k8s_descriptions",
 		Name:      "failed",
 		Help:      "Total amount of failed descriptions per sink",
-	}, []string{"sink"})
-)
+	}, []string{"sink"}))
+
+	if err := errors.Join(durationErr, receivedErr, failuresErr); err != nil {
+		return routerMetrics{}, fmt.Errorf("could not register router metrics: %w", err)
+	}
+
+	return routerMetrics{requestDuration: requestDuration, received: received, failures: failures}, nil
+}
 
 type ObjectHandler interface {
 	HandleObject(kubeEvent common.KubeObject) error
@@ -44,11 +62,30 @@ type ObjectHandler interface {
 // Router listens for events coming from a SharedIndexInformer,
 // and forwards them to the registered sinks
 type Router struct {
+	// mtx guards the handlers and processors, which can be replaced while running.
+	// Items being published hold the read lock, so a replacement waits for them to finish.
+	mtx sync.RWMutex
+
 	// list of handlers to send events to
 	handlers map[string]ObjectHandler
 
+	// processors run for every object before it's sent to the handlers
+	processors []router.ObjectProcessor
+
 	// all updates & adds will be appended to this queue
 	workQueue chan common.KubeObject
+
+	// drained is closed once the router has been drained, so items are no longer accepted.
+	drained     chan struct{}
+	drainedOnce sync.Once
+
+	// heartbeat records when the last item was handled, to detect a stalled router.
+	heartbeat router.Heartbeat
+
+	// unrouted holds the handlers receiving every object, regardless of the sinks it is routed to.
+	unrouted map[string]bool
+
+	metrics routerMetrics
 }
 
 type observedObjectHandler struct {
@@ -64,29 +101,47 @@ func (o *observedObjectHandler) HandleObject(kubeObject common.KubeObject) error
 }
 
 // NewRouter returns a new Router which listens to the given SharedIndexInformer,
-// and forwards all incoming events to the given sinks
-func NewRouter(informers []cache.SharedIndexInformer, handlers map[string]ObjectHandler, opts ...router.ConfigOption) *Router {
+// and forwards all incoming events to the given sinks.
+// An error is returned if the options are not valid.
+func NewRouter(informers []cache.SharedIndexInformer, handlers map[string]ObjectHandler, opts ...router.ConfigOption) (*Router, error) {
 	config, err := router.NewConfig(opts...)
 	if err != nil {
-		logrus.Fatalf("Error with Router configuration: %v", err)
+		return nil, fmt.Errorf("error with router configuration: %w", err)
+	}
+
+	routerMetrics, err := newRouterMetrics(config.Registerer())
+	if err != nil {
+		return nil, err
 	}
 
 	workQueue := make(chan common.KubeObject, config.WorkQueueLength())
+	if err := instrument(workQueue, config.Registerer(), config.Name()); err != nil {
+		return nil, err
+	}
+
+	// Objects received once the router has been drained are dropped, so informers can stop.
+	drained := make(chan struct{})
+	enqueue := func(kubeObject common.KubeObject) {
+		select {
+		case workQueue <- kubeObject:
+		case <-drained:
+		}
+	}
 
 	for _, informer := range informers {
 		_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
-				workQueue <- common.KubeObject{
+				enqueue(common.KubeObject{
 					Obj:  obj.(runtime.Object),
 					Verb: "ADDED",
-				}
+				})
 			},
 			UpdateFunc: func(oldObj, newObj interface{}) {
-				workQueue <- common.KubeObject{
+				enqueue(common.KubeObject{
 					Obj:    newObj.(runtime.Object),
 					OldObj: oldObj.(runtime.Object),
 					Verb:   "UPDATE",
-				}
+				})
 			},
 		})
 
@@ -95,37 +150,64 @@ func NewRouter(informers []cache.SharedIndexInformer, handlers map[string]Object
 		}
 	}
 
-	// instrument all sinks with histogram observation
+	return &Router{
+		handlers:   observe(handlers, routerMetrics.requestDuration),
+		processors: config.ObjectProcessors(),
+		workQueue:  workQueue,
+		drained:    drained,
+		metrics:    routerMetrics,
+		unrouted:   config.UnroutedHandlers(),
+	}, nil
+}
+
+// observe instruments all sinks with histogram observation.
+func observe(handlers map[string]ObjectHandler, requestDuration *prometheus.HistogramVec) map[string]ObjectHandler {
 	observedSinks := map[string]ObjectHandler{}
 	for name, handler := range handlers {
 		observedSinks[name] = &observedObjectHandler{
 			ObjectHandler: handler,
-			Observer:      requestDurationSeconds.WithLabelValues(name),
+			Observer:      requestDuration.WithLabelValues(name),
 		}
 	}
 
-	return instrument(&Router{
-		handlers:  observedSinks,
-		workQueue: workQueue,
-	})
+	return observedSinks
 }
 
-func instrument(r *Router) *Router {
-	if err := prometheus.Register(prometheus.NewGaugeFunc(
+// Replace atomically swaps the handlers items are forwarded to and the processors run for them.
+// It blocks until the item being published, if any, has been handled.
+func (r *Router) Replace(handlers map[string]ObjectHandler, processors []router.ObjectProcessor) {
+	observed := observe(handlers, r.metrics.requestDuration)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.handlers = observed
+	r.processors = processors
+}
+
+// instrument registers the length of the work queue of a router, labeled with its name if set.
+// It fails if a router with the same name already registered it with the registerer.
+func instrument(workQueue chan common.KubeObject, reg prometheus.Registerer, name string) error {
+	var labels prometheus.Labels
+	if name != "" {
+		labels = prometheus.Labels{"router": name}
+	}
+
+	if err := reg.Register(prometheus.NewGaugeFunc(
 		prometheus.GaugeOpts{
-			Namespace: "nr",
-			Subsystem: "k8s_descriptions",
-			Name:      "workqueue_length",
-			Help:      "Number of k8s objects currently queued in the workqueue.",
+			Namespace:   metrics.Namespace,
+			Subsystem:   "k8s_descriptions",
+			Name:        "workqueue_length",
+			Help:        "Number of k8s objects currently queued in the workqueue.",
+			ConstLabels: labels,
 		},
 		func() float64 {
-			return float64(len(r.workQueue))
+			return float64(len(workQueue))
 		},
 	)); err != nil {
-		logrus.Warningf("could not register workqueue_queue_length prometheus gauge")
+		return fmt.Errorf("could not register workqueue_length gauge of router %q, routers sharing a registerer need different names: %w", name, err)
 	}
 
-	return r
+	return nil
 }
 
 // Run listens to the workQueue and forwards incoming objects
@@ -133,24 +215,110 @@ func instrument(r *Router) *Router {
 func (r *Router) Run(stopChan <-chan struct{}) {
 	logrus.Infof("Router started")
 	defer logrus.Infof("Router stopped")
+	r.heartbeat.Beat()
 
 	for {
 		select {
 		case <-stopChan:
 			return
 		case event := <-r.workQueue:
-			r.publishObjectDescription(event)
+			r.handle(event)
 		}
 	}
 }
 
-func (r *Router) publishObjectDescription(kubeObject common.KubeObject) {
+// Drain forwards the items left in the workQueue to the sinks, once Run has returned.
+// It returns when the queue is empty and until is closed, or when the context is done, in which
+// case the items left are dropped. A nil until channel means draining until the queue is empty.
+// Items received afterwards are dropped, releasing the informers blocked on a full queue.
+// The amount of items dropped is returned.
+func (r *Router) Drain(ctx context.Context, until <-chan struct{}) int {
+	defer r.drainedOnce.Do(func() { close(r.drained) })
+
+	if until == nil {
+		closed := make(chan struct{})
+		close(closed)
+		until = closed
+	}
+
+	handled := 0
+	for {
+		if ctx.Err() != nil {
+			dropped := len(r.workQueue)
+			logrus.Warnf("Router drained %d objects, grace period exceeded, dropping %d", handled, dropped)
+			return dropped
+		}
+
+		select {
+		case <-ctx.Done():
+		case item := <-r.workQueue:
+			r.handle(item)
+			handled++
+		case <-until:
+			if len(r.workQueue) == 0 {
+				logrus.Infof("Router drained %d objects", handled)
+				return 0
+			}
+		}
+	}
+}
+
+// Status returns a snapshot of the work done by the router.
+func (r *Router) Status() router.Status {
+	return router.Status{
+		LastProcessed: r.heartbeat.Last(),
+		QueueLength:   len(r.workQueue),
+		QueueCapacity: cap(r.workQueue),
+	}
+}
+
+// handle processes and publishes a single item, holding the read lock so
+// handlers and processors are not replaced meanwhile.
+func (r *Router) handle(item common.KubeObject) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	defer r.heartbeat.Beat()
+
+	// Unrouted handlers, like the transitions detector, see every object as it comes from the
+	// informers, before processors can drop it.
 	for name, handler := range r.handlers {
-		descsReceivedTotal.WithLabelValues(name).Inc()
+		if r.unrouted[name] {
+			r.handleObject(name, handler, item)
+		}
+	}
 
-		if err := handler.HandleObject(kubeObject); err != nil {
-			logrus.Warningf("Sink %s HandleEvent error: %v", name, err)
-			descsFailuresTotal.WithLabelValues(name).Inc()
+	if r.processObject(&item) {
+		r.publishObjectDescription(item)
+	}
+}
+
+// processObject runs all the processors for the given object,
+// returning false if any of them dropped it.
+func (r *Router) processObject(kubeObject *common.KubeObject) bool {
+	for _, p := range r.processors {
+		if !p.ProcessObject(kubeObject) {
+			return false
 		}
 	}
+
+	return true
+}
+
+func (r *Router) publishObjectDescription(kubeObject common.KubeObject) {
+	for name, handler := range r.handlers {
+		if r.unrouted[name] || !router.Routed(kubeObject.Sinks, name) {
+			continue
+		}
+
+		r.handleObject(name, handler, kubeObject)
+	}
+}
+
+func (r *Router) handleObject(name string, handler ObjectHandler, kubeObject common.KubeObject) {
+	r.metrics.received.WithLabelValues(name).Inc()
+
+	if err := handler.HandleObject(kubeObject); err != nil {
+		logrus.Warningf("Sink %s HandleEvent error: %v", name, err)
+		r.metrics.failures.WithLabelValues(name).Inc()
+	}
 }
@@ -0,0 +1,370 @@
+// Package diff computes the semantic differences between two versions of a Kubernetes object.
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+const (
+	// MaxChanges is the maximum amount of changes reported for a single object update.
+	// Anything above it is dropped and the result is marked as truncated.
+	MaxChanges = 32
+
+	attrPrefix       = "change."
+	lastAppliedAnnot = "kubectl.kubernetes.io/last-applied-configuration"
+)
+
+// Change describes a single field that differs between two versions of an object.
+// Old is empty when the field was added, New is empty when it was removed.
+type Change struct {
+	Path string
+	Old  string
+	New  string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Path, display(c.Old), display(c.New))
+}
+
+// Result holds the changes between two objects, sorted by path.
+type Result struct {
+	Changes   []Change
+	Truncated bool
+}
+
+// Empty returns true if no changes were found.
+func (r Result) Empty() bool {
+	return len(r.Changes) == 0
+}
+
+// Summary returns a human-readable, single line description of the changes.
+func (r Result) Summary() string {
+	parts := make([]string, 0, len(r.Changes))
+	for _, c := range r.Changes {
+		parts = append(parts, c.String())
+	}
+
+	summary := strings.Join(parts, "; ")
+	if r.Truncated {
+		summary += "; ..."
+	}
+
+	return truncate(summary)
+}
+
+// Attributes returns the changes as flat `change.*` attributes, ready to be attached to an event:
+//
+//	change.count:                      2
+//	change.fields:                     "metadata.labels.app,spec.replicas"
+//	change.summary:                    "metadata.labels.app: <none> -> web; spec.replicas: 2 -> 3"
+//	change.spec.replicas.old:          "2"
+//	change.spec.replicas.new:          "3"
+//	change.metadata.labels.app.new:    "web"
+func (r Result) Attributes() map[string]interface{} {
+	attrs := make(map[string]interface{})
+	if r.Empty() {
+		return attrs
+	}
+
+	fields := make([]string, 0, len(r.Changes))
+	for _, c := range r.Changes {
+		fields = append(fields, c.Path)
+		if c.Old != "" {
+			attrs[attrPrefix+c.Path+".old"] = c.Old
+		}
+		if c.New != "" {
+			attrs[attrPrefix+c.Path+".new"] = c.New
+		}
+	}
+
+	attrs[attrPrefix+"count"] = len(r.Changes)
+	attrs[attrPrefix+"fields"] = truncate(strings.Join(fields, ","))
+	attrs[attrPrefix+"summary"] = r.Summary()
+	if r.Truncated {
+		attrs[attrPrefix+"truncated"] = true
+	}
+
+	return attrs
+}
+
+// Compute returns the changes between oldObj and newObj.
+//
+// Only the fields that describe the intent and the health of an object are compared:
+// labels, annotations, the whole spec, the status phase and the status conditions.
+// Bookkeeping fields like resourceVersion, managedFields or condition timestamps are ignored,
+// so periodic resyncs of an unchanged object produce an empty result.
+//
+// List items that have a `name` (containers, ports, volumes) or a `type` (conditions) are matched
+// by that key instead of their position, e.g. `spec.template.spec.containers[nginx].image`.
+func Compute(oldObj, newObj runtime.Object) (Result, error) {
+	oldMap, err := toMap(oldObj)
+	if err != nil {
+		return Result{}, fmt.Errorf("converting old object: %w", err)
+	}
+
+	newMap, err := toMap(newObj)
+	if err != nil {
+		return Result{}, fmt.Errorf("converting new object: %w", err)
+	}
+
+	var changes []Change
+	walk("", relevant(oldMap), relevant(newMap), &changes)
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	result := Result{Changes: changes}
+	if len(changes) > MaxChanges {
+		result.Changes = changes[:MaxChanges]
+		result.Truncated = true
+	}
+
+	return result, nil
+}
+
+func toMap(obj runtime.Object) (map[string]interface{}, error) {
+	if obj == nil {
+		return nil, nil
+	}
+
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+// relevant strips the given object down to the fields we want to compare.
+func relevant(obj map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	if obj == nil {
+		return out
+	}
+
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		md := make(map[string]interface{})
+		if labels, ok := metadata["labels"]; ok {
+			md["labels"] = labels
+		}
+		if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+			filtered := make(map[string]interface{}, len(annotations))
+			for k, v := range annotations {
+				if k != lastAppliedAnnot {
+					filtered[k] = v
+				}
+			}
+			md["annotations"] = filtered
+		}
+		out["metadata"] = md
+	}
+
+	if spec, ok := obj["spec"]; ok {
+		out["spec"] = spec
+	}
+
+	if status, ok := obj["status"].(map[string]interface{}); ok {
+		st := make(map[string]interface{})
+		if phase, ok := status["phase"]; ok {
+			st["phase"] = phase
+		}
+		if conditions, ok := status["conditions"]; ok {
+			st["conditions"] = conditions
+		}
+		out["status"] = st
+	}
+
+	return out
+}
+
+func walk(path string, oldVal, newVal interface{}, changes *[]Change) {
+	oldMap, oldIsMap := oldVal.(map[string]interface{})
+	newMap, newIsMap := newVal.(map[string]interface{})
+	if (oldIsMap || oldVal == nil) && (newIsMap || newVal == nil) && (oldIsMap || newIsMap) {
+		walkMap(path, oldMap, newMap, changes)
+		return
+	}
+
+	oldList, oldIsList := oldVal.([]interface{})
+	newList, newIsList := newVal.([]interface{})
+	if (oldIsList || oldVal == nil) && (newIsList || newVal == nil) && (oldIsList || newIsList) {
+		walkList(path, oldList, newList, changes)
+		return
+	}
+
+	oldStr, newStr := format(oldVal), format(newVal)
+	if oldStr != newStr {
+		*changes = append(*changes, Change{Path: path, Old: oldStr, New: newStr})
+	}
+}
+
+func walkMap(path string, oldMap, newMap map[string]interface{}, changes *[]Change) {
+	keys := make(map[string]struct{}, len(oldMap)+len(newMap))
+	for k := range oldMap {
+		keys[k] = struct{}{}
+	}
+	for k := range newMap {
+		keys[k] = struct{}{}
+	}
+
+	for k := range keys {
+		if isBookkeepingKey(path, k) {
+			continue
+		}
+		walk(join(path, k), oldMap[k], newMap[k], changes)
+	}
+}
+
+func walkList(path string, oldList, newList []interface{}, changes *[]Change) {
+	key := listKey(oldList, newList)
+	if key == "" {
+		for i := 0; i < len(oldList) || i < len(newList); i++ {
+			walk(fmt.Sprintf("%s[%d]", path, i), at(oldList, i), at(newList, i), changes)
+		}
+		return
+	}
+
+	oldItems := indexBy(oldList, key)
+	newItems := indexBy(newList, key)
+
+	seen := make(map[string]struct{}, len(oldItems)+len(newItems))
+	for _, items := range []map[string]interface{}{oldItems, newItems} {
+		for id := range items {
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+
+			itemPath := fmt.Sprintf("%s[%s]", path, id)
+			oldItem, inOld := oldItems[id]
+			newItem, inNew := newItems[id]
+			if inOld && inNew {
+				walk(itemPath, oldItem, newItem, changes)
+				continue
+			}
+
+			// Whole items being added or removed are reported as a single change.
+			*changes = append(*changes, Change{Path: itemPath, Old: format(oldItem), New: format(newItem)})
+		}
+	}
+}
+
+// listKey returns the field name which uniquely identifies every item in both lists,
+// or an empty string if items should be matched by position.
+func listKey(lists ...[]interface{}) string {
+	for _, key := range []string{"name", "type"} {
+		if hasUniqueKey(key, lists...) {
+			return key
+		}
+	}
+
+	return ""
+}
+
+func hasUniqueKey(key string, lists ...[]interface{}) bool {
+	found := false
+	for _, list := range lists {
+		seen := make(map[string]struct{}, len(list))
+		for _, item := range list {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				return false
+			}
+			id, ok := m[key].(string)
+			if !ok || id == "" {
+				return false
+			}
+			if _, dup := seen[id]; dup {
+				return false
+			}
+			seen[id] = struct{}{}
+			found = true
+		}
+	}
+
+	return found
+}
+
+func indexBy(list []interface{}, key string) map[string]interface{} {
+	items := make(map[string]interface{}, len(list))
+	for _, item := range list {
+		m := item.(map[string]interface{})
+		items[m[key].(string)] = m
+	}
+
+	return items
+}
+
+func at(list []interface{}, i int) interface{} {
+	if i < len(list) {
+		return list[i]
+	}
+
+	return nil
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// conditionTimestampKeys are the timestamps Kubernetes updates in status conditions without
+// their status changing, e.g. on every node heartbeat.
+var conditionTimestampKeys = map[string]bool{
+	"lastTransitionTime": true,
+	"lastUpdateTime":     true,
+	"lastHeartbeatTime":  true,
+	"lastProbeTime":      true,
+}
+
+// isBookkeepingKey returns true for the keys under path that are updated by Kubernetes itself.
+// Other keys, like labels or spec fields whose name ends in "Time", are compared.
+func isBookkeepingKey(path, key string) bool {
+	if path == "metadata" {
+		return key == "managedFields"
+	}
+
+	return strings.HasPrefix(path, "status.conditions[") && conditionTimestampKeys[key]
+}
+
+// format returns the string representation of a value. Nested values are encoded as JSON.
+func format(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return truncate(val)
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprintf("%v", val)
+		}
+		return truncate(string(b))
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+func display(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+
+	return s
+}
+
+func truncate(s string) string {
+	if splits := common.LimitSplit(s, common.NRDBLimit); len(splits) > 1 {
+		return splits[0]
+	}
+
+	return s
+}
@@ -0,0 +1,200 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package diff_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/nri-kube-events/pkg/diff"
+)
+
+func deployment(mutate func(d *appsv1.Deployment)) *appsv1.Deployment {
+	replicas := int32(2)
+	d := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web",
+			Namespace:       "default",
+			ResourceVersion: "1",
+			Labels:          map[string]string{"app": "web"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{Name: "nginx", Image: "nginx:1.25"},
+						{Name: "sidecar", Image: "busybox:1.36"},
+					},
+				},
+			},
+		},
+		Status: appsv1.DeploymentStatus{
+			Conditions: []appsv1.DeploymentCondition{
+				{
+					Type:           appsv1.DeploymentProgressing,
+					Status:         v1.ConditionTrue,
+					Reason:         "NewReplicaSetAvailable",
+					LastUpdateTime: metav1.NewTime(time.Unix(1000, 0)),
+				},
+			},
+		},
+	}
+
+	if mutate != nil {
+		mutate(d)
+	}
+
+	return d
+}
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name     string
+		mutate   func(d *appsv1.Deployment)
+		expected []diff.Change
+	}{
+		{
+			name:     "no changes",
+			expected: nil,
+		},
+		{
+			name: "bookkeeping fields are ignored",
+			mutate: func(d *appsv1.Deployment) {
+				d.ResourceVersion = "2"
+				d.Generation = 5
+				d.Status.ObservedGeneration = 5
+				d.Status.Conditions[0].LastUpdateTime = metav1.NewTime(time.Unix(2000, 0))
+			},
+			expected: nil,
+		},
+		{
+			name: "replicas",
+			mutate: func(d *appsv1.Deployment) {
+				replicas := int32(3)
+				d.Spec.Replicas = &replicas
+			},
+			expected: []diff.Change{
+				{Path: "spec.replicas", Old: "2", New: "3"},
+			},
+		},
+		{
+			name: "container image matched by name",
+			mutate: func(d *appsv1.Deployment) {
+				d.Spec.Template.Spec.Containers[0], d.Spec.Template.Spec.Containers[1] =
+					d.Spec.Template.Spec.Containers[1], d.Spec.Template.Spec.Containers[0]
+				d.Spec.Template.Spec.Containers[1].Image = "nginx:1.26"
+			},
+			expected: []diff.Change{
+				{Path: "spec.template.spec.containers[nginx].image", Old: "nginx:1.25", New: "nginx:1.26"},
+			},
+		},
+		{
+			name: "container removed",
+			mutate: func(d *appsv1.Deployment) {
+				d.Spec.Template.Spec.Containers = d.Spec.Template.Spec.Containers[:1]
+			},
+			expected: []diff.Change{
+				{
+					Path: "spec.template.spec.containers[sidecar]",
+					Old:  `{"image":"busybox:1.36","name":"sidecar","resources":{}}`,
+				},
+			},
+		},
+		{
+			name: "labels",
+			mutate: func(d *appsv1.Deployment) {
+				d.Labels = map[string]string{"app": "frontend", "team": "platform"}
+			},
+			expected: []diff.Change{
+				{Path: "metadata.labels.app", Old: "web", New: "frontend"},
+				{Path: "metadata.labels.team", New: "platform"},
+			},
+		},
+		{
+			name: "labels named like timestamps",
+			mutate: func(d *appsv1.Deployment) {
+				d.Labels["maintenanceTime"] = "0300"
+				d.Annotations = map[string]string{"lastProbeTime": "now"}
+				d.Status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Unix(2000, 0))
+			},
+			expected: []diff.Change{
+				{Path: "metadata.annotations.lastProbeTime", New: "now"},
+				{Path: "metadata.labels.maintenanceTime", New: "0300"},
+			},
+		},
+		{
+			name: "condition transition",
+			mutate: func(d *appsv1.Deployment) {
+				d.Status.Conditions[0].Status = v1.ConditionFalse
+				d.Status.Conditions[0].Reason = "ProgressDeadlineExceeded"
+			},
+			expected: []diff.Change{
+				{Path: "status.conditions[Progressing].reason", Old: "NewReplicaSetAvailable", New: "ProgressDeadlineExceeded"},
+				{Path: "status.conditions[Progressing].status", Old: "True", New: "False"},
+			},
+		},
+		{
+			name: "last-applied-configuration is ignored",
+			mutate: func(d *appsv1.Deployment) {
+				d.Annotations = map[string]string{"kubectl.kubernetes.io/last-applied-configuration": "{}"}
+			},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := diff.Compute(deployment(nil), deployment(tt.mutate))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result.Changes)
+			assert.False(t, result.Truncated)
+		})
+	}
+}
+
+func TestCompute_Truncated(t *testing.T) {
+	labels := map[string]string{}
+	for i := 0; i < diff.MaxChanges+10; i++ {
+		labels[string(rune('a'+i%26))+string(rune('a'+i/26))] = "value"
+	}
+
+	result, err := diff.Compute(deployment(nil), deployment(func(d *appsv1.Deployment) {
+		d.Labels = labels
+	}))
+	require.NoError(t, err)
+	assert.Len(t, result.Changes, diff.MaxChanges)
+	assert.True(t, result.Truncated)
+	assert.Equal(t, true, result.Attributes()["change.truncated"])
+}
+
+func TestResult_Attributes(t *testing.T) {
+	result, err := diff.Compute(deployment(nil), deployment(func(d *appsv1.Deployment) {
+		replicas := int32(3)
+		d.Spec.Replicas = &replicas
+		d.Labels["team"] = "platform"
+	}))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		"change.count":                    2,
+		"change.fields":                   "metadata.labels.team,spec.replicas",
+		"change.summary":                  "metadata.labels.team: <none> -> platform; spec.replicas: 2 -> 3",
+		"change.metadata.labels.team.new": "platform",
+		"change.spec.replicas.old":        "2",
+		"change.spec.replicas.new":        "3",
+	}, result.Attributes())
+}
+
+func TestResult_AttributesEmpty(t *testing.T) {
+	result, err := diff.Compute(deployment(nil), deployment(nil))
+	require.NoError(t, err)
+	assert.True(t, result.Empty())
+	assert.Empty(t, result.Attributes())
+}
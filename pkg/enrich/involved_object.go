@@ -0,0 +1,178 @@
+// Package enrich decorates events with metadata read from shared informer caches.
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package enrich
+
+import (
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+// maxOwnerDepth limits how many owners are followed, protecting against reference cycles.
+const maxOwnerDepth = 5
+
+// InvolvedObjectStores requests from the factory the informers needed to enrich events,
+// returning their stores keyed by object kind.
+// It must be called before the factory is started.
+func InvolvedObjectStores(factory informers.SharedInformerFactory) map[string]cache.Store {
+	return map[string]cache.Store{
+		"Pod":         factory.Core().V1().Pods().Informer().GetStore(),
+		"Node":        factory.Core().V1().Nodes().Informer().GetStore(),
+		"ReplicaSet":  factory.Apps().V1().ReplicaSets().Informer().GetStore(),
+		"Deployment":  factory.Apps().V1().Deployments().Informer().GetStore(),
+		"DaemonSet":   factory.Apps().V1().DaemonSets().Informer().GetStore(),
+		"StatefulSet": factory.Apps().V1().StatefulSets().Informer().GetStore(),
+		"Job":         factory.Batch().V1().Jobs().Informer().GetStore(),
+		"CronJob":     factory.Batch().V1().CronJobs().Informer().GetStore(),
+	}
+}
+
+// InvolvedObject implements the `router.EventProcessor` interface.
+// It looks up the object an event refers to and attaches its labels, the selected annotations,
+// the chain of owners and, for Pods, the node they are running on.
+// Events whose object is not found in the caches are forwarded untouched.
+type InvolvedObject struct {
+	stores      map[string]cache.Store
+	annotations []string
+}
+
+// NewInvolvedObject returns an InvolvedObject enricher reading from the given stores, keyed by kind.
+// Only the annotations in the given list are attached, since they tend to be large.
+func NewInvolvedObject(stores map[string]cache.Store, annotations []string) *InvolvedObject {
+	return &InvolvedObject{
+		stores:      stores,
+		annotations: annotations,
+	}
+}
+
+// ProcessEvent attaches the involved object metadata to the event. It never drops events.
+func (e *InvolvedObject) ProcessEvent(kubeEvent *common.KubeEvent) bool {
+	if kubeEvent.Event == nil {
+		return true
+	}
+
+	ref := kubeEvent.Event.InvolvedObject
+	obj, ok := e.lookup(ref.Kind, ref.Namespace, ref.Name)
+	if !ok {
+		return true
+	}
+
+	md := &common.ObjectMetadata{
+		Labels:      copyMap(obj.GetLabels()),
+		Annotations: selectKeys(obj.GetAnnotations(), e.annotations),
+		Owners:      e.owners(obj),
+	}
+
+	if len(md.Owners) > 0 {
+		md.Owner = &md.Owners[len(md.Owners)-1]
+	}
+
+	if pod, ok := obj.(*v1.Pod); ok {
+		md.NodeName = pod.Spec.NodeName
+	}
+
+	kubeEvent.InvolvedObject = md
+
+	return true
+}
+
+// owners follows the controller references of the given object as far as the caches allow.
+// Owners which are not cached are still reported, but their own owners can't be known.
+func (e *InvolvedObject) owners(obj metav1.Object) []common.OwnerReference {
+	var owners []common.OwnerReference
+
+	for i := 0; i < maxOwnerDepth; i++ {
+		ref := controllerOf(obj)
+		if ref == nil {
+			break
+		}
+
+		owners = append(owners, common.OwnerReference{Kind: ref.Kind, Name: ref.Name})
+
+		owner, ok := e.lookup(ref.Kind, obj.GetNamespace(), ref.Name)
+		if !ok {
+			break
+		}
+		obj = owner
+	}
+
+	return owners
+}
+
+func (e *InvolvedObject) lookup(kind, namespace, name string) (metav1.Object, bool) {
+	store, ok := e.stores[kind]
+	if !ok || name == "" {
+		return nil, false
+	}
+
+	key := name
+	if namespace != "" {
+		key = namespace + "/" + name
+	}
+
+	item, exists, err := store.GetByKey(key)
+	if err != nil {
+		logrus.Debugf("could not look up %s %s: %v", kind, key, err)
+		return nil, false
+	}
+	if !exists {
+		return nil, false
+	}
+
+	obj, err := meta.Accessor(item)
+	if err != nil {
+		logrus.Debugf("cached %s %s is not an object: %v", kind, key, err)
+		return nil, false
+	}
+
+	return obj, true
+}
+
+// controllerOf returns the controller owning the object or, if it has none, the first owner.
+func controllerOf(obj metav1.Object) *metav1.OwnerReference {
+	if ref := metav1.GetControllerOfNoCopy(obj); ref != nil {
+		return ref
+	}
+
+	refs := obj.GetOwnerReferences()
+	if len(refs) > 0 {
+		return &refs[0]
+	}
+
+	return nil
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+
+	return out
+}
+
+func selectKeys(m map[string]string, keys []string) map[string]string {
+	var out map[string]string
+	for _, k := range keys {
+		v, ok := m[k]
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string, len(keys))
+		}
+		out[k] = v
+	}
+
+	return out
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package enrich_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/enrich"
+)
+
+func controlledBy(kind, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+}
+
+func newStores(t *testing.T) map[string]cache.Store {
+	t.Helper()
+
+	pods := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	replicaSets := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	deployments := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	nodes := cache.NewStore(cache.MetaNamespaceKeyFunc)
+
+	require.NoError(t, pods.Add(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web-5d8f-abcde",
+			Namespace:       "default",
+			Labels:          map[string]string{"app": "web"},
+			Annotations:     map[string]string{"team": "platform", "huge": "ignored"},
+			OwnerReferences: controlledBy("ReplicaSet", "web-5d8f"),
+		},
+		Spec: v1.PodSpec{NodeName: "worker-1"},
+	}))
+	require.NoError(t, pods.Add(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "orphan-job-xyz",
+			Namespace:       "default",
+			OwnerReferences: controlledBy("Job", "orphan-job"),
+		},
+	}))
+	require.NoError(t, replicaSets.Add(&appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web-5d8f",
+			Namespace:       "default",
+			OwnerReferences: controlledBy("Deployment", "web"),
+		},
+	}))
+	require.NoError(t, deployments.Add(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+	}))
+	require.NoError(t, nodes.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Labels: map[string]string{"zone": "a"}},
+	}))
+
+	return map[string]cache.Store{
+		"Pod":        pods,
+		"ReplicaSet": replicaSets,
+		"Deployment": deployments,
+		"Node":       nodes,
+	}
+}
+
+func TestInvolvedObject_ProcessEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		ref      v1.ObjectReference
+		expected *common.ObjectMetadata
+	}{
+		{
+			name: "pod owned by a deployment",
+			ref:  v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-5d8f-abcde"},
+			expected: &common.ObjectMetadata{
+				Labels:      map[string]string{"app": "web"},
+				Annotations: map[string]string{"team": "platform"},
+				Owners: []common.OwnerReference{
+					{Kind: "ReplicaSet", Name: "web-5d8f"},
+					{Kind: "Deployment", Name: "web"},
+				},
+				Owner:    &common.OwnerReference{Kind: "Deployment", Name: "web"},
+				NodeName: "worker-1",
+			},
+		},
+		{
+			name: "owner not cached",
+			ref:  v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "orphan-job-xyz"},
+			expected: &common.ObjectMetadata{
+				Owners: []common.OwnerReference{{Kind: "Job", Name: "orphan-job"}},
+				Owner:  &common.OwnerReference{Kind: "Job", Name: "orphan-job"},
+			},
+		},
+		{
+			name: "cluster scoped object",
+			ref:  v1.ObjectReference{Kind: "Node", Name: "worker-1"},
+			expected: &common.ObjectMetadata{
+				Labels: map[string]string{"zone": "a"},
+			},
+		},
+		{
+			name: "object not found",
+			ref:  v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "gone"},
+		},
+		{
+			name: "kind not cached",
+			ref:  v1.ObjectReference{Kind: "HorizontalPodAutoscaler", Namespace: "default", Name: "web"},
+		},
+	}
+
+	enricher := enrich.NewInvolvedObject(newStores(t), []string{"team"})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ke := common.KubeEvent{Event: &v1.Event{InvolvedObject: tt.ref}}
+			assert.True(t, enricher.ProcessEvent(&ke))
+			assert.Equal(t, tt.expected, ke.InvolvedObject)
+		})
+	}
+}
+
+func TestInvolvedObject_Flattened(t *testing.T) {
+	enricher := enrich.NewInvolvedObject(newStores(t), nil)
+
+	ke := common.KubeEvent{Event: &v1.Event{
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-5d8f-abcde"},
+	}}
+	require.True(t, enricher.ProcessEvent(&ke))
+
+	flattened, err := common.FlattenStruct(ke)
+	require.NoError(t, err)
+
+	assert.Equal(t, "web", flattened["involvedObject.labels.app"])
+	assert.Equal(t, "worker-1", flattened["involvedObject.nodeName"])
+	assert.Equal(t, "Deployment", flattened["involvedObject.owner.kind"])
+	assert.Equal(t, "web", flattened["involvedObject.owner.name"])
+	assert.Equal(t, "ReplicaSet", flattened["involvedObject.owners[0].kind"])
+	assert.NotContains(t, flattened, "involvedObject.annotations.team")
+}
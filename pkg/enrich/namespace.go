@@ -0,0 +1,101 @@
+// Package enrich ...
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package enrich
+
+import (
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+// NamespaceStore requests from the factory the Namespace informer, returning its store.
+// It must be called before the factory is started.
+func NamespaceStore(factory informers.SharedInformerFactory) cache.Store {
+	return factory.Core().V1().Namespaces().Informer().GetStore()
+}
+
+// Namespace implements both the `router.EventProcessor` and the `router.ObjectProcessor` interfaces.
+// It attaches the selected labels and annotations of the namespace an event or object belongs to,
+// so they can be used to filter or route data per team or cost center.
+type Namespace struct {
+	store       cache.Store
+	labels      []string
+	annotations []string
+}
+
+// NewNamespace returns a Namespace enricher reading namespaces from the given store.
+// Only the labels and annotations in the given lists are attached.
+func NewNamespace(store cache.Store, labels, annotations []string) *Namespace {
+	return &Namespace{
+		store:       store,
+		labels:      labels,
+		annotations: annotations,
+	}
+}
+
+// ProcessEvent attaches the metadata of the involved object namespace. It never drops events.
+func (n *Namespace) ProcessEvent(kubeEvent *common.KubeEvent) bool {
+	if kubeEvent.Event == nil {
+		return true
+	}
+
+	namespace := kubeEvent.Event.InvolvedObject.Namespace
+	if namespace == "" {
+		namespace = kubeEvent.Event.Namespace
+	}
+
+	kubeEvent.Namespace = n.metadata(namespace)
+
+	return true
+}
+
+// ProcessObject attaches the metadata of the object namespace. It never drops objects.
+func (n *Namespace) ProcessObject(kubeObject *common.KubeObject) bool {
+	if kubeObject.Obj == nil {
+		return true
+	}
+
+	accessor, err := meta.Accessor(kubeObject.Obj)
+	if err != nil {
+		return true
+	}
+
+	kubeObject.Namespace = n.metadata(accessor.GetNamespace())
+
+	return true
+}
+
+func (n *Namespace) metadata(name string) *common.NamespaceMetadata {
+	if name == "" {
+		return nil
+	}
+
+	item, exists, err := n.store.GetByKey(name)
+	if err != nil {
+		logrus.Debugf("could not look up namespace %s: %v", name, err)
+		return nil
+	}
+	if !exists {
+		return nil
+	}
+
+	ns, ok := item.(*v1.Namespace)
+	if !ok {
+		return nil
+	}
+
+	md := &common.NamespaceMetadata{
+		Labels:      selectKeys(ns.Labels, n.labels),
+		Annotations: selectKeys(ns.Annotations, n.annotations),
+	}
+	if md.Labels == nil && md.Annotations == nil {
+		return nil
+	}
+
+	return md
+}
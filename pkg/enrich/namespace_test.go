@@ -0,0 +1,114 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package enrich_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/enrich"
+)
+
+func newNamespaceEnricher(t *testing.T) *enrich.Namespace {
+	t.Helper()
+
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	require.NoError(t, store.Add(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "payments",
+			Labels:      map[string]string{"team": "checkout", "tier": "prod", "other": "ignored"},
+			Annotations: map[string]string{"owner": "jane@example.com"},
+		},
+	}))
+	require.NoError(t, store.Add(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"},
+	}))
+
+	return enrich.NewNamespace(store, []string{"team", "tier"}, []string{"owner"})
+}
+
+func TestNamespace_ProcessEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    *v1.Event
+		expected *common.NamespaceMetadata
+	}{
+		{
+			name: "involved object namespace",
+			event: &v1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Namespace: "default"},
+				InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "payments", Name: "api"},
+			},
+			expected: &common.NamespaceMetadata{
+				Labels:      map[string]string{"team": "checkout", "tier": "prod"},
+				Annotations: map[string]string{"owner": "jane@example.com"},
+			},
+		},
+		{
+			name: "event namespace as fallback",
+			event: &v1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Namespace: "payments"},
+				InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "api"},
+			},
+			expected: &common.NamespaceMetadata{
+				Labels:      map[string]string{"team": "checkout", "tier": "prod"},
+				Annotations: map[string]string{"owner": "jane@example.com"},
+			},
+		},
+		{
+			name: "namespace without selected metadata",
+			event: &v1.Event{
+				InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "unlabeled", Name: "api"},
+			},
+		},
+		{
+			name: "cluster scoped object",
+			event: &v1.Event{
+				InvolvedObject: v1.ObjectReference{Kind: "Node", Name: "worker-1"},
+			},
+		},
+	}
+
+	enricher := newNamespaceEnricher(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ke := common.KubeEvent{Event: tt.event}
+			assert.True(t, enricher.ProcessEvent(&ke))
+			assert.Equal(t, tt.expected, ke.Namespace)
+		})
+	}
+}
+
+func TestNamespace_ProcessObject(t *testing.T) {
+	enricher := newNamespaceEnricher(t)
+
+	ko := common.KubeObject{Obj: &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "payments"},
+	}}
+	assert.True(t, enricher.ProcessObject(&ko))
+	assert.Equal(t, &common.NamespaceMetadata{
+		Labels:      map[string]string{"team": "checkout", "tier": "prod"},
+		Annotations: map[string]string{"owner": "jane@example.com"},
+	}, ko.Namespace)
+}
+
+func TestNamespace_Flattened(t *testing.T) {
+	enricher := newNamespaceEnricher(t)
+
+	ke := common.KubeEvent{Event: &v1.Event{
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "payments", Name: "api"},
+	}}
+	require.True(t, enricher.ProcessEvent(&ke))
+
+	flattened, err := common.FlattenStruct(ke)
+	require.NoError(t, err)
+	assert.Equal(t, "checkout", flattened["namespace.labels.team"])
+	assert.Equal(t, "jane@example.com", flattened["namespace.annotations.owner"])
+}
@@ -4,38 +4,57 @@
 package events
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
 	"k8s.io/client-go/tools/cache"
 
 	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/metrics"
 	"github.com/newrelic/nri-kube-events/pkg/router"
 )
 
-var (
-	requestDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: "nr",
+// routerMetrics holds the metrics of a router, labeled by sink.
+type routerMetrics struct {
+	requestDuration *prometheus.HistogramVec
+	received        *prometheus.CounterVec
+	failures        *prometheus.CounterVec
+}
+
+// newRouterMetrics registers the metrics of a router. Routers sharing a registerer share them.
+func newRouterMetrics(reg prometheus.Registerer) (routerMetrics, error) {
+	requestDuration, durationErr := metrics.Register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
 		Subsystem: "kube_events",
 		Name:      "sink_request_duration_seconds",
 		Help:      "Duration of requests for each sink",
-	}, []string{"sink"})
-	eventsReceivedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
-		Namespace: "nr",
+	}, []string{"sink"}))
+	received, receivedErr := metrics.Register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
 		Subsystem: "kube_events",
 		Name:      "received_events_total",
 		Help:      "Total amount of events received per sink, including failures",
-	}, []string{"sink"})
-	eventsFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
-		Namespace: "nr",
+	}, []string{"sink"}))
+	failures, failuresErr := metrics.Register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
 		Subsystem: "kube_events",
 		Name:      "failed_events_total",
 		Help:      "Total amount of failed events per sink",
-	}, []string{"sink"})
-)
+	}, []string{"sink"}))
+
+	if err := errors.Join(durationErr, receivedErr, failuresErr); err != nil {
+		return routerMetrics{}, fmt.Errorf("could not register router metrics: %w", err)
+	}
+
+	return routerMetrics{requestDuration: requestDuration, received: received, failures: failures}, nil
+}
 
 type EventHandler interface {
 	HandleEvent(kubeEvent common.KubeEvent) error
@@ -44,11 +63,27 @@ type EventHandler interface {
 // Router listens for events coming from a SharedIndexInformer,
 // and forwards them to the registered sinks
 type Router struct {
+	// mtx guards the handlers and processors, which can be replaced while running.
+	// Items being published hold the read lock, so a replacement waits for them to finish.
+	mtx sync.RWMutex
+
 	// list of handlers to send events to
 	handlers map[string]EventHandler
 
+	// processors run for every event before it's sent to the handlers
+	processors []router.EventProcessor
+
 	// all updates & adds will be appended to this queue
 	workQueue chan common.KubeEvent
+
+	// drained is closed once the router has been drained, so items are no longer accepted.
+	drained     chan struct{}
+	drainedOnce sync.Once
+
+	// heartbeat records when the last item was handled, to detect a stalled router.
+	heartbeat router.Heartbeat
+
+	metrics routerMetrics
 }
 
 type observedEventHandler struct {
@@ -64,11 +99,17 @@ func (o *observedEventHandler) HandleEvent(kubeEvent common.KubeEvent) error {
 }
 
 // NewRouter returns a new Router which listens to the given SharedIndexInformer,
-// and forwards all incoming events to the given sinks
-func NewRouter(informer cache.SharedIndexInformer, handlers map[string]EventHandler, opts ...router.ConfigOption) *Router {
+// and forwards all incoming events to the given sinks.
+// An error is returned if the options are not valid.
+func NewRouter(informer cache.SharedIndexInformer, handlers map[string]EventHandler, opts ...router.ConfigOption) (*Router, error) {
 	config, err := router.NewConfig(opts...)
 	if err != nil {
-		logrus.Fatalf("Error with Router configuration: %v", err)
+		return nil, fmt.Errorf("error with router configuration: %w", err)
+	}
+
+	routerMetrics, err := newRouterMetrics(config.Registerer())
+	if err != nil {
+		return nil, err
 	}
 
 	// According to the shared_informer source code it's not designed to
@@ -76,20 +117,38 @@ func NewRouter(informer cache.SharedIndexInformer, handlers map[string]EventHand
 	// Therefore we push to a queue and handle it in another goroutine
 	// See: https://github.com/kubernetes/client-go/blob/c8dc69f8a8bf8d8640493ce26688b26c7bfde8e6/tools/cache/shared_informer.go#L111
 	workQueue := make(chan common.KubeEvent, config.WorkQueueLength())
+	if err := instrument(workQueue, config.Registerer(), config.Name()); err != nil {
+		return nil, err
+	}
+
+	// enqueue drops the events exceeding the limits, if any, so a storm can't fill the queue.
+	// Events received once the router has been drained are dropped, so informers can stop.
+	limiter := config.EventLimiter()
+	drained := make(chan struct{})
+	enqueue := func(kubeEvent common.KubeEvent) {
+		if limiter != nil && !limiter.Allow(kubeEvent) {
+			return
+		}
+
+		select {
+		case workQueue <- kubeEvent:
+		case <-drained:
+		}
+	}
 
 	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			workQueue <- common.KubeEvent{
-				Event: obj.(*v1.Event),
+			enqueue(common.KubeEvent{
+				Event: toCoreEvent(obj),
 				Verb:  "ADDED",
-			}
+			})
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
-			workQueue <- common.KubeEvent{
-				Event:    newObj.(*v1.Event),
-				OldEvent: oldObj.(*v1.Event),
+			enqueue(common.KubeEvent{
+				Event:    toCoreEvent(newObj),
+				OldEvent: toCoreEvent(oldObj),
 				Verb:     "UPDATE",
-			}
+			})
 		},
 	})
 
@@ -97,37 +156,73 @@ func NewRouter(informer cache.SharedIndexInformer, handlers map[string]EventHand
 		logrus.Warnf("Error with add informer event handlers: %v", err)
 	}
 
-	// instrument all sinks with histogram observation
+	return &Router{
+		handlers:   observe(handlers, routerMetrics.requestDuration),
+		processors: config.EventProcessors(),
+		workQueue:  workQueue,
+		drained:    drained,
+		metrics:    routerMetrics,
+	}, nil
+}
+
+// toCoreEvent returns the given informer object as a core/v1 Event.
+// Informers can either watch core/v1 or events.k8s.io/v1 Events.
+func toCoreEvent(obj interface{}) *v1.Event {
+	if e, ok := obj.(*eventsv1.Event); ok {
+		return common.EventFromEventsV1(e)
+	}
+
+	return obj.(*v1.Event)
+}
+
+// observe instruments all sinks with histogram observation.
+func observe(handlers map[string]EventHandler, requestDuration *prometheus.HistogramVec) map[string]EventHandler {
 	observedSinks := map[string]EventHandler{}
 	for name, handler := range handlers {
 		observedSinks[name] = &observedEventHandler{
 			EventHandler: handler,
-			Observer:     requestDurationSeconds.WithLabelValues(name),
+			Observer:     requestDuration.WithLabelValues(name),
 		}
 	}
 
-	return instrument(&Router{
-		handlers:  observedSinks,
-		workQueue: workQueue,
-	})
+	return observedSinks
+}
+
+// Replace atomically swaps the handlers items are forwarded to and the processors run for them.
+// It blocks until the item being published, if any, has been handled.
+func (r *Router) Replace(handlers map[string]EventHandler, processors []router.EventProcessor) {
+	observed := observe(handlers, r.metrics.requestDuration)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.handlers = observed
+	r.processors = processors
 }
 
-func instrument(r *Router) *Router {
-	if err := prometheus.Register(prometheus.NewGaugeFunc(
+// instrument registers the length of the work queue of a router, labeled with its name if set.
+// It fails if a router with the same name already registered it with the registerer.
+func instrument(workQueue chan common.KubeEvent, reg prometheus.Registerer, name string) error {
+	var labels prometheus.Labels
+	if name != "" {
+		labels = prometheus.Labels{"router": name}
+	}
+
+	if err := reg.Register(prometheus.NewGaugeFunc(
 		prometheus.GaugeOpts{
-			Namespace: "nr",
-			Subsystem: "kube_events",
-			Name:      "workqueue_length",
-			Help:      "Number of k8s events currently queued in the workqueue.",
+			Namespace:   metrics.Namespace,
+			Subsystem:   "kube_events",
+			Name:        "workqueue_length",
+			Help:        "Number of k8s events currently queued in the workqueue.",
+			ConstLabels: labels,
 		},
 		func() float64 {
-			return float64(len(r.workQueue))
+			return float64(len(workQueue))
 		},
 	)); err != nil {
-		logrus.Warningf("could not register workqueue_queue_length prometheus gauge")
+		return fmt.Errorf("could not register workqueue_length gauge of router %q, routers sharing a registerer need different names: %w", name, err)
 	}
 
-	return r
+	return nil
 }
 
 // Run listens to the workQueue and forwards incoming events
@@ -135,24 +230,109 @@ func instrument(r *Router) *Router {
 func (r *Router) Run(stopChan <-chan struct{}) {
 	logrus.Infof("Router started")
 	defer logrus.Infof("Router stopped")
+	r.heartbeat.Beat()
 
 	for {
 		select {
 		case <-stopChan:
 			return
 		case event := <-r.workQueue:
-			r.publishEvent(event)
+			r.handle(event)
 		}
 	}
 }
 
+// Drain forwards the items left in the workQueue to the sinks, once Run has returned.
+// It returns when the queue is empty and until is closed, or when the context is done, in which
+// case the items left are dropped. A nil until channel means draining until the queue is empty.
+// Items received afterwards are dropped, releasing the informers blocked on a full queue.
+// The amount of items dropped is returned.
+func (r *Router) Drain(ctx context.Context, until <-chan struct{}) int {
+	defer r.drainedOnce.Do(func() { close(r.drained) })
+
+	if until == nil {
+		closed := make(chan struct{})
+		close(closed)
+		until = closed
+	}
+
+	handled := 0
+	for {
+		if ctx.Err() != nil {
+			dropped := len(r.workQueue)
+			logrus.Warnf("Router drained %d events, grace period exceeded, dropping %d", handled, dropped)
+			return dropped
+		}
+
+		select {
+		case <-ctx.Done():
+		case item := <-r.workQueue:
+			r.handle(item)
+			handled++
+		case <-until:
+			if len(r.workQueue) == 0 {
+				logrus.Infof("Router drained %d events", handled)
+				return 0
+			}
+		}
+	}
+}
+
+// Status returns a snapshot of the work done by the router.
+func (r *Router) Status() router.Status {
+	return router.Status{
+		LastProcessed: r.heartbeat.Last(),
+		QueueLength:   len(r.workQueue),
+		QueueCapacity: cap(r.workQueue),
+	}
+}
+
+// handle processes and publishes a single item, holding the read lock so
+// handlers and processors are not replaced meanwhile.
+func (r *Router) handle(item common.KubeEvent) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	defer r.heartbeat.Beat()
+
+	if r.processEvent(&item) {
+		r.publishEvent(item)
+	}
+}
+
+// processEvent runs all the processors for the given event,
+// returning false if any of them dropped it.
+func (r *Router) processEvent(kubeEvent *common.KubeEvent) bool {
+	for _, p := range r.processors {
+		if !p.ProcessEvent(kubeEvent) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Publish queues an event which did not come from the informer, like the synthesized ones,
+// so it is forwarded to the registered sinks as any other event.
+// Events published once the router has been drained are dropped.
+func (r *Router) Publish(kubeEvent common.KubeEvent) {
+	select {
+	case r.workQueue <- kubeEvent:
+	case <-r.drained:
+		logrus.Debugf("Router already drained, dropping published event")
+	}
+}
+
 func (r *Router) publishEvent(kubeEvent common.KubeEvent) {
 	for name, handler := range r.handlers {
-		eventsReceivedTotal.WithLabelValues(name).Inc()
+		if !router.Routed(kubeEvent.Sinks, name) {
+			continue
+		}
+
+		r.metrics.received.WithLabelValues(name).Inc()
 
 		if err := handler.HandleEvent(kubeEvent); err != nil {
 			logrus.Warningf("Sink %s HandleEvent error: %v", name, err)
-			eventsFailuresTotal.WithLabelValues(name).Inc()
+			r.metrics.failures.WithLabelValues(name).Inc()
 		}
 	}
 }
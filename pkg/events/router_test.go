@@ -1,20 +1,26 @@
 package events
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	dto "github.com/prometheus/client_model/go"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	v1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
 	"k8s.io/client-go/tools/cache"
 
 	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/metrics"
+	"github.com/newrelic/nri-kube-events/pkg/router"
 )
 
 func TestNewRouter(t *testing.T) {
@@ -76,6 +82,29 @@ func TestNewRouter(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "AddEventHandler events.k8s.io/v1 AddFunc",
+			args: args{
+				informer: new(MockSharedIndexInformer),
+			},
+			assert: func(t *testing.T, args args, r *Router) {
+				assert.Len(t, args.informer.Calls, 1)
+				hf := args.informer.Calls[0].Arguments.Get(0).(cache.ResourceEventHandlerFuncs)
+				added := &eventsv1.Event{
+					Note:   "Back-off pulling image",
+					Series: &eventsv1.EventSeries{Count: 4},
+				}
+				go hf.AddFunc(added)
+				select {
+				case ke := <-r.workQueue:
+					assert.Equal(t, "ADDED", ke.Verb)
+					assert.Equal(t, "Back-off pulling image", ke.Event.Message)
+					assert.EqualValues(t, 4, ke.Event.Count)
+				case <-time.After(1 * time.Second):
+					assert.Fail(t, "Nothing on worker queue")
+				}
+			},
+		},
 		{
 			name: "workQueue",
 			args: args{
@@ -117,7 +146,8 @@ func TestNewRouter(t *testing.T) {
 				On("AddEventHandler", mock.AnythingOfType("cache.ResourceEventHandlerFuncs")).
 				Once()
 
-			r := NewRouter(tt.args.informer, tt.args.handlers)
+			r, err := NewRouter(tt.args.informer, tt.args.handlers, withTestRegisterer())
+			assert.NoError(t, err)
 			assert.NotNil(t, r)
 			tt.assert(t, tt.args, r)
 			tt.args.informer.AssertExpectations(t)
@@ -174,7 +204,8 @@ func TestRouter_Run(t *testing.T) {
 		"stub": stubSink,
 	}
 
-	r := NewRouter(informer, handlers)
+	r, err := NewRouter(informer, handlers, withTestRegisterer())
+	assert.NoError(t, err)
 	stopChan := make(chan struct{})
 
 	wg := sync.WaitGroup{}
@@ -213,7 +244,8 @@ func TestRouter_RunError(t *testing.T) {
 		"stub": stubSink,
 	}
 
-	r := NewRouter(informer, handlers)
+	r, err := NewRouter(informer, handlers, withTestRegisterer())
+	assert.NoError(t, err)
 	stopChan := make(chan struct{})
 
 	wg := sync.WaitGroup{}
@@ -240,10 +272,228 @@ func TestRouter_RunError(t *testing.T) {
 
 	wg.Wait()
 	stubSink.AssertExpectations(t)
-	c, err := eventsFailuresTotal.GetMetricWithLabelValues("stub")
+	c, err := r.metrics.failures.GetMetricWithLabelValues("stub")
 	assert.NoError(t, err)
 	m := dto.Metric{}
 	assert.NoError(t, c.Write(&m))
 	expCnt := float64(1)
 	assert.Equal(t, expCnt, *m.Counter.Value)
 }
+
+func TestRouter_Publish(t *testing.T) {
+	informer := new(MockSharedIndexInformer)
+	informer.SetupMock()
+
+	r, err := NewRouter(informer, nil, withTestRegisterer())
+	assert.NoError(t, err)
+	synthesized := common.KubeEvent{
+		Verb:        "ADDED",
+		Event:       &v1.Event{Reason: "KubeletNotReady"},
+		Synthesized: true,
+	}
+
+	go r.Publish(synthesized)
+
+	select {
+	case ke := <-r.workQueue:
+		assert.Equal(t, synthesized, ke)
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "Nothing on worker queue")
+	}
+}
+
+func TestRouter_Replace(t *testing.T) {
+	informer := new(MockSharedIndexInformer)
+	informer.SetupMock()
+
+	oldSink := new(stubSink)
+	r, err := NewRouter(informer, map[string]EventHandler{"old": oldSink}, withTestRegisterer())
+	assert.NoError(t, err)
+
+	newSink := new(stubSink)
+	newSink.On("HandleEvent", mock.AnythingOfType("KubeEvent")).Return(nil).Once()
+	r.Replace(map[string]EventHandler{"new": newSink}, []router.EventProcessor{})
+
+	r.handle(common.KubeEvent{Event: &v1.Event{}})
+
+	oldSink.AssertNotCalled(t, "HandleEvent", mock.Anything)
+	newSink.AssertExpectations(t)
+	assert.Len(t, r.handlers, 1)
+	assert.Contains(t, r.handlers, "new")
+}
+
+func TestRouter_RoutedEvents(t *testing.T) {
+	informer := new(MockSharedIndexInformer)
+	informer.SetupMock()
+
+	routed, other := new(stubSink), new(stubSink)
+	routed.On("HandleEvent", mock.AnythingOfType("KubeEvent")).Return(nil).Twice()
+	other.On("HandleEvent", mock.AnythingOfType("KubeEvent")).Return(nil).Once()
+	r, err := NewRouter(informer, map[string]EventHandler{"routed": routed, "other": other}, withTestRegisterer())
+	assert.NoError(t, err)
+
+	r.handle(common.KubeEvent{Event: &v1.Event{}, Sinks: []string{"routed"}})
+	r.handle(common.KubeEvent{Event: &v1.Event{}, Sinks: []string{}})
+	r.handle(common.KubeEvent{Event: &v1.Event{}})
+
+	routed.AssertExpectations(t)
+	other.AssertExpectations(t)
+	assert.Equal(t, float64(2), testutil.ToFloat64(r.metrics.received.WithLabelValues("routed")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(r.metrics.received.WithLabelValues("other")))
+}
+
+// denyReason is a limiter dropping the events with the given reason.
+type denyReason string
+
+func (d denyReason) Allow(kubeEvent common.KubeEvent) bool {
+	return kubeEvent.Event.Reason != string(d)
+}
+
+func TestRouter_EventLimiter(t *testing.T) {
+	informer := new(MockSharedIndexInformer)
+	informer.SetupMock()
+
+	r, err := NewRouter(informer, nil, router.WithEventLimiter(denyReason("BackOff")), withTestRegisterer())
+	assert.NoError(t, err)
+
+	hf := informer.Calls[0].Arguments.Get(0).(cache.ResourceEventHandlerFuncs)
+	hf.AddFunc(&v1.Event{Reason: "BackOff"})
+	hf.UpdateFunc(&v1.Event{Reason: "BackOff"}, &v1.Event{Reason: "BackOff"})
+	hf.AddFunc(&v1.Event{Reason: "Started"})
+	r.Publish(common.KubeEvent{Verb: "ADDED", Event: &v1.Event{Reason: "BackOff"}, Synthesized: true})
+
+	assert.Len(t, r.workQueue, 2, "published events are not limited")
+	assert.Equal(t, "Started", (<-r.workQueue).Event.Reason)
+	assert.True(t, (<-r.workQueue).Synthesized)
+}
+
+func TestRouter_Drain(t *testing.T) {
+	informer := new(MockSharedIndexInformer)
+	informer.SetupMock()
+
+	sink := new(stubSink)
+	sink.On("HandleEvent", mock.AnythingOfType("KubeEvent")).Return(nil).Times(3)
+	r, err := NewRouter(informer, map[string]EventHandler{"stub": sink}, router.WithWorkQueueLength(intPtr(10)), withTestRegisterer())
+	assert.NoError(t, err)
+
+	r.Publish(common.KubeEvent{Event: &v1.Event{}})
+	r.Publish(common.KubeEvent{Event: &v1.Event{}})
+
+	until := make(chan struct{})
+	dropped := make(chan int)
+	go func() { dropped <- r.Drain(context.Background(), until) }()
+
+	// Items published until the drain is told to stop are handled too.
+	r.Publish(common.KubeEvent{Event: &v1.Event{}})
+	close(until)
+
+	select {
+	case d := <-dropped:
+		assert.Equal(t, 0, d)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Drain did not return")
+	}
+	sink.AssertExpectations(t)
+
+	// Once drained, publishing does not block even if the queue is full.
+	for i := 0; i < 11; i++ {
+		r.Publish(common.KubeEvent{Event: &v1.Event{}})
+	}
+}
+
+func TestRouter_DrainGracePeriodExceeded(t *testing.T) {
+	informer := new(MockSharedIndexInformer)
+	informer.SetupMock()
+
+	sink := new(stubSink)
+	r, err := NewRouter(informer, map[string]EventHandler{"stub": sink}, router.WithWorkQueueLength(intPtr(10)), withTestRegisterer())
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		r.Publish(common.KubeEvent{Event: &v1.Event{}})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, 5, r.Drain(ctx, nil))
+	sink.AssertNotCalled(t, "HandleEvent", mock.Anything)
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestRouter_Status(t *testing.T) {
+	informer := new(MockSharedIndexInformer)
+	informer.SetupMock()
+
+	sink := new(stubSink)
+	sink.On("HandleEvent", mock.AnythingOfType("KubeEvent")).Return(nil)
+	r, err := NewRouter(informer, map[string]EventHandler{"stub": sink}, router.WithWorkQueueLength(intPtr(4)), withTestRegisterer())
+	assert.NoError(t, err)
+
+	r.Publish(common.KubeEvent{Event: &v1.Event{}})
+	r.Publish(common.KubeEvent{Event: &v1.Event{}})
+
+	status := r.Status()
+	assert.True(t, status.LastProcessed.IsZero())
+	assert.Equal(t, 2, status.QueueLength)
+	assert.Equal(t, 4, status.QueueCapacity)
+	assert.Equal(t, 0.5, status.Saturation())
+	assert.True(t, status.Stalled(time.Now(), time.Minute), "items are queued and none was processed")
+
+	r.Drain(context.Background(), nil)
+
+	status = r.Status()
+	assert.Equal(t, 0, status.QueueLength)
+	assert.WithinDuration(t, time.Now(), status.LastProcessed, time.Second)
+	assert.False(t, status.Stalled(time.Now(), time.Minute))
+}
+
+func TestNewRouter_InvalidConfig(t *testing.T) {
+	informer := new(MockSharedIndexInformer)
+
+	r, err := NewRouter(informer, nil, router.WithWorkQueueLength(intPtr(0)))
+	assert.ErrorIs(t, err, router.ErrInvalidWorkQueueLength)
+	assert.Nil(t, r)
+	assert.Empty(t, informer.Calls, "no handlers are added to the informer")
+}
+
+func TestNewRouter_Registerer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	// Routers sharing a registerer share their metrics, and report the length of their own work queue.
+	for _, name := range []string{"first", "second"} {
+		informer := new(MockSharedIndexInformer)
+		informer.SetupMock()
+
+		r, err := NewRouter(informer, nil, router.WithRegisterer(metrics.WithClusterName(reg, "test")), router.WithName(name))
+		assert.NoError(t, err)
+		r.metrics.received.WithLabelValues("stub").Inc()
+	}
+
+	expected := `
+# HELP nr_kube_events_received_events_total Total amount of events received per sink, including failures
+# TYPE nr_kube_events_received_events_total counter
+nr_kube_events_received_events_total{cluster="test",sink="stub"} 2
+# HELP nr_kube_events_workqueue_length Number of k8s events currently queued in the workqueue.
+# TYPE nr_kube_events_workqueue_length gauge
+nr_kube_events_workqueue_length{cluster="test",router="first"} 0
+nr_kube_events_workqueue_length{cluster="test",router="second"} 0
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"nr_kube_events_received_events_total", "nr_kube_events_workqueue_length"))
+
+	informer := new(MockSharedIndexInformer)
+	_, err := NewRouter(informer, nil, router.WithRegisterer(metrics.WithClusterName(reg, "test")), router.WithName("first"))
+	assert.ErrorContains(t, err, `workqueue_length gauge of router "first"`)
+	assert.Empty(t, informer.Calls, "no handlers are added to the informer")
+
+	_, err = NewRouter(new(MockSharedIndexInformer), nil, router.WithRegisterer(nil))
+	assert.ErrorIs(t, err, router.ErrNilRegisterer)
+}
+
+// withTestRegisterer registers the metrics of the router with a new registry, so every test can create routers.
+func withTestRegisterer() router.ConfigOption {
+	return router.WithRegisterer(prometheus.NewRegistry())
+}
@@ -0,0 +1,259 @@
+// Package expr compiles CEL expressions matching events and objects, used to filter and route them.
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/ext"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+// costLimit bounds the work done evaluating an expression for a single item, so a costly
+// expression, like a comprehension over every label, can't stall the routers.
+const costLimit = 1000000
+
+// Variables available to event expressions. The event is typed after `v1.Event`, and
+// fields are named after their JSON names, e.g. `event.involvedObject.kind`.
+const (
+	VarVerb           = "verb"
+	VarEvent          = "event"
+	VarSynthesized    = "synthesized"
+	VarInvolvedObject = "involvedObject"
+	// VarNamespace holds the namespace metadata, since `namespace` is a reserved word in CEL.
+	VarNamespace = "ns"
+)
+
+// Variables available to object expressions, besides VarVerb and VarNamespace. Objects are
+// maps of their JSON representation, since their type depends on the kind.
+const (
+	VarKind      = "kind"
+	VarObject    = "object"
+	VarOldObject = "oldObject"
+)
+
+// jsonFieldName names the fields of native types after their JSON names. Fields promoted from
+// embedded structs are skipped, like `event.name`, which is available as `event.metadata.name`.
+// Fields without a JSON name keep their Go name, like `event.lastTimestamp.Time`.
+func jsonFieldName(field reflect.StructField) string {
+	if len(field.Index) > 1 {
+		return ""
+	}
+
+	tag, ok := field.Tag.Lookup("json")
+	if !ok {
+		return field.Name
+	}
+
+	return strings.Split(tag, ",")[0]
+}
+
+var eventEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		ext.NativeTypes(
+			reflect.TypeOf(&v1.Event{}),
+			reflect.TypeOf(&common.ObjectMetadata{}),
+			reflect.TypeOf(&common.NamespaceMetadata{}),
+			types.ParseStructField(jsonFieldName),
+		),
+		ext.Strings(),
+		cel.OptionalTypes(),
+		cel.Variable(VarVerb, cel.StringType),
+		cel.Variable(VarEvent, cel.ObjectType("v1.Event")),
+		cel.Variable(VarSynthesized, cel.BoolType),
+		cel.Variable(VarInvolvedObject, cel.ObjectType("common.ObjectMetadata")),
+		cel.Variable(VarNamespace, cel.ObjectType("common.NamespaceMetadata")),
+	)
+})
+
+var objectEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		ext.NativeTypes(
+			reflect.TypeOf(&common.NamespaceMetadata{}),
+			types.ParseStructField(jsonFieldName),
+		),
+		ext.Strings(),
+		cel.OptionalTypes(),
+		cel.Variable(VarVerb, cel.StringType),
+		cel.Variable(VarKind, cel.StringType),
+		cel.Variable(VarObject, cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable(VarOldObject, cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable(VarNamespace, cel.ObjectType("common.NamespaceMetadata")),
+	)
+})
+
+// compile type checks the expression, which must return a bool. Expressions returning fields
+// of objects, whose type is only known when evaluated, are accepted too.
+func compile(env *cel.Env, source string) (cel.Program, error) {
+	ast, issues := env.Compile(source)
+	if issues.Err() != nil {
+		return nil, issuesError(issues)
+	}
+
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("expression must return a bool, got %s", ast.OutputType())
+	}
+
+	return env.Program(ast, cel.CostLimit(costLimit))
+}
+
+// issuesError reports the compile issues in a single line, with their column, so they can be
+// prefixed with the line of the configuration file the expression was found at.
+func issuesError(issues *cel.Issues) error {
+	msgs := make([]string, 0, len(issues.Errors()))
+	for _, issue := range issues.Errors() {
+		msgs = append(msgs, fmt.Sprintf("column %d: %s", issue.Location.Column()+1, issue.Message))
+	}
+
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// match evaluates the program, failing if it does not return a bool.
+func match(program cel.Program, vars map[string]any) (bool, error) {
+	out, _, err := program.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+
+	matched, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression must return a bool, got %s", out.Type())
+	}
+
+	return matched, nil
+}
+
+// Event is a compiled expression matching events. It is safe for concurrent use.
+type Event struct {
+	source  string
+	program cel.Program
+}
+
+// CompileEvent compiles the expression, checking it against the variables available to events.
+func CompileEvent(source string) (*Event, error) {
+	env, err := eventEnv()
+	if err != nil {
+		return nil, fmt.Errorf("could not create CEL environment: %w", err)
+	}
+
+	program, err := compile(env, source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{source: source, program: program}, nil
+}
+
+// Match evaluates the expression for the event. Errors, like reading a missing map key, are returned
+// so they can be reported, and the event must be considered as not matching.
+func (e *Event) Match(kubeEvent common.KubeEvent) (bool, error) {
+	event := kubeEvent.Event
+	if event == nil {
+		event = &v1.Event{}
+	}
+
+	involvedObject := kubeEvent.InvolvedObject
+	if involvedObject == nil {
+		involvedObject = &common.ObjectMetadata{}
+	}
+
+	namespace := kubeEvent.Namespace
+	if namespace == nil {
+		namespace = &common.NamespaceMetadata{}
+	}
+
+	return match(e.program, map[string]any{
+		VarVerb:           kubeEvent.Verb,
+		VarEvent:          event,
+		VarSynthesized:    kubeEvent.Synthesized,
+		VarInvolvedObject: involvedObject,
+		VarNamespace:      namespace,
+	})
+}
+
+// String returns the source of the expression.
+func (e *Event) String() string {
+	return e.source
+}
+
+// Object is a compiled expression matching objects. It is safe for concurrent use.
+type Object struct {
+	source  string
+	program cel.Program
+}
+
+// CompileObject compiles the expression, checking it against the variables available to objects.
+func CompileObject(source string) (*Object, error) {
+	env, err := objectEnv()
+	if err != nil {
+		return nil, fmt.Errorf("could not create CEL environment: %w", err)
+	}
+
+	program, err := compile(env, source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Object{source: source, program: program}, nil
+}
+
+// Match evaluates the expression for the object. Errors, like reading a missing map key, are returned
+// so they can be reported, and the object must be considered as not matching.
+func (o *Object) Match(kubeObject common.KubeObject) (bool, error) {
+	obj, err := toMap(kubeObject.Obj)
+	if err != nil {
+		return false, err
+	}
+
+	oldObj, err := toMap(kubeObject.OldObj)
+	if err != nil {
+		return false, err
+	}
+
+	namespace := kubeObject.Namespace
+	if namespace == nil {
+		namespace = &common.NamespaceMetadata{}
+	}
+
+	var kind string
+	if kubeObject.Obj != nil {
+		kind = common.K8SObjGetGVK(kubeObject.Obj).Kind
+	}
+
+	return match(o.program, map[string]any{
+		VarVerb:      kubeObject.Verb,
+		VarKind:      kind,
+		VarObject:    obj,
+		VarOldObject: oldObj,
+		VarNamespace: namespace,
+	})
+}
+
+// String returns the source of the expression.
+func (o *Object) String() string {
+	return o.source
+}
+
+// toMap returns the JSON representation of the object as a map, empty if the object is nil.
+func toMap(obj runtime.Object) (map[string]interface{}, error) {
+	if obj == nil || reflect.ValueOf(obj).IsNil() {
+		return map[string]interface{}{}, nil
+	}
+
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert object: %w", err)
+	}
+
+	return m, nil
+}
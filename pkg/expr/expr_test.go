@@ -0,0 +1,208 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package expr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+func testEvent(eventType string, count int32) common.KubeEvent {
+	return common.KubeEvent{
+		Verb: "ADDED",
+		Event: &v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "pod.17c3e0", Namespace: "shop"},
+			InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "pod", Namespace: "shop"},
+			Reason:         "BackOff",
+			Type:           eventType,
+			Count:          count,
+		},
+		InvolvedObject: &common.ObjectMetadata{Labels: map[string]string{"app": "web"}},
+		Namespace:      &common.NamespaceMetadata{Labels: map[string]string{"tier": "prod"}},
+	}
+}
+
+func TestEvent_Match(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		event    common.KubeEvent
+		expected bool
+	}{
+		{
+			name:     "warnings on prod pods",
+			source:   `event.type == "Warning" && event.count > 5 && event.involvedObject.kind == "Pod" && ns.labels["tier"] == "prod"`,
+			event:    testEvent("Warning", 6),
+			expected: true,
+		},
+		{
+			name:     "count too low",
+			source:   `event.type == "Warning" && event.count > 5`,
+			event:    testEvent("Warning", 5),
+			expected: false,
+		},
+		{
+			name:     "metadata and enrichment",
+			source:   `event.metadata.namespace == "shop" && involvedObject.labels["app"] == "web" && verb == "ADDED"`,
+			event:    testEvent("Normal", 1),
+			expected: true,
+		},
+		{
+			name:     "optional label",
+			source:   `ns.labels.?team.orValue("none") == "none"`,
+			event:    testEvent("Normal", 1),
+			expected: true,
+		},
+		{
+			name:     "string functions",
+			source:   `event.reason.lowerAscii().startsWith("back")`,
+			event:    testEvent("Normal", 1),
+			expected: true,
+		},
+		{
+			name:     "not enriched",
+			source:   `!("tier" in ns.labels) && !synthesized`,
+			event:    common.KubeEvent{Verb: "ADDED", Event: &v1.Event{}},
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, err := CompileEvent(test.source)
+			require.NoError(t, err)
+
+			matched, err := e.Match(test.event)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, matched)
+		})
+	}
+}
+
+func TestEvent_MatchError(t *testing.T) {
+	e, err := CompileEvent(`ns.labels["team"] == "a"`)
+	require.NoError(t, err)
+
+	matched, err := e.Match(testEvent("Normal", 1))
+	assert.False(t, matched)
+	assert.ErrorContains(t, err, "no such key: team")
+}
+
+func TestCompile_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		object bool
+		err    string
+	}{
+		{name: "unknown field", source: `event.cont > 5`, err: "undefined field 'cont'"},
+		{name: "wrong type", source: `event.count == "5"`, err: "found no matching overload for '_==_' applied to '(int, string)'"},
+		{name: "unknown variable", source: `reason == "BackOff"`, err: "undeclared reference to 'reason'"},
+		{name: "not a bool", source: `event.count`, err: "expression must return a bool, got int"},
+		{name: "syntax", source: `event.type ==`, err: "column 14: Syntax error: mismatched input '<EOF>'"},
+		{name: "event variable in objects", source: `event.type == "Warning"`, object: true, err: "undeclared reference to 'event'"},
+		{name: "object not a bool", source: `size(object)`, object: true, err: "expression must return a bool, got int"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var err error
+			if test.object {
+				_, err = CompileObject(test.source)
+			} else {
+				_, err = CompileEvent(test.source)
+			}
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestObject_Match(t *testing.T) {
+	pod := &v1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "shop", Labels: map[string]string{"app": "web"}},
+		Status:     v1.PodStatus{Phase: v1.PodFailed},
+	}
+	oldPod := pod.DeepCopy()
+	oldPod.Status.Phase = v1.PodRunning
+
+	tests := []struct {
+		name     string
+		source   string
+		object   common.KubeObject
+		expected bool
+	}{
+		{
+			name:     "kind and fields",
+			source:   `kind == "Pod" && object.metadata.labels.app == "web" && object.status.phase == "Failed"`,
+			object:   common.KubeObject{Verb: "ADDED", Obj: pod},
+			expected: true,
+		},
+		{
+			name:     "changed field",
+			source:   `verb == "UPDATE" && oldObject.status.phase != object.status.phase`,
+			object:   common.KubeObject{Verb: "UPDATE", Obj: pod, OldObj: oldPod},
+			expected: true,
+		},
+		{
+			name:     "no old object",
+			source:   `size(oldObject) == 0 && ns.labels.?tier.orValue("") == "prod"`,
+			object:   common.KubeObject{Verb: "ADDED", Obj: pod, Namespace: &common.NamespaceMetadata{Labels: map[string]string{"tier": "prod"}}},
+			expected: true,
+		},
+		{
+			name:     "dynamic bool field",
+			source:   `object.metadata.labels.app == "web" && object.?spec.?hostNetwork.orValue(false) == false`,
+			object:   common.KubeObject{Verb: "ADDED", Obj: pod},
+			expected: true,
+		},
+		{
+			name:     "other kind",
+			source:   `kind == "Node"`,
+			object:   common.KubeObject{Verb: "ADDED", Obj: pod},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o, err := CompileObject(test.source)
+			require.NoError(t, err)
+
+			matched, err := o.Match(test.object)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, matched)
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	f, err := NewFilter("warnings", `event.type == "Warning"`, "")
+	require.NoError(t, err)
+
+	warning, normal := testEvent("Warning", 1), testEvent("Normal", 1)
+	assert.True(t, f.ProcessEvent(&warning))
+	assert.False(t, f.ProcessEvent(&normal))
+	assert.True(t, f.ProcessObject(&common.KubeObject{Verb: "ADDED", Obj: &v1.Pod{}}), "objects are forwarded without an expression")
+
+	f, err = NewFilter("labels", "", `object.metadata.labels["app"] == "web"`)
+	require.NoError(t, err)
+	assert.True(t, f.ProcessEvent(&normal), "events are forwarded without an expression")
+	assert.False(t, f.ProcessObject(&common.KubeObject{Verb: "ADDED", Obj: &v1.Pod{}}), "objects failing to evaluate are dropped")
+
+	o, err := CompileObject(`object.metadata.name`)
+	require.NoError(t, err, "fields of objects are only type checked when evaluated")
+	_, err = o.Match(common.KubeObject{Verb: "ADDED", Obj: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod"}}})
+	assert.EqualError(t, err, "expression must return a bool, got string")
+
+	_, err = NewFilter("invalid", `event.cont > 1`, `size(object)`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "events: column 6: undefined field 'cont'")
+	assert.Contains(t, err.Error(), "objects: expression must return a bool, got int")
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package expr
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+// Filter implements both the `router.EventProcessor` and the `router.ObjectProcessor` interfaces.
+// It only forwards the events and objects matching its expressions. Items are forwarded untouched
+// if there is no expression for them, and dropped if their expression fails to evaluate.
+type Filter struct {
+	name    string
+	events  *Event
+	objects *Object
+}
+
+// NewFilter compiles the given event and object expressions, either of which can be empty
+// to forward all the items of that type. All compile errors are returned.
+func NewFilter(name, events, objects string) (*Filter, error) {
+	f := &Filter{name: name}
+
+	var eventsErr, objectsErr error
+	if events != "" {
+		if f.events, eventsErr = CompileEvent(events); eventsErr != nil {
+			eventsErr = fmt.Errorf("events: %w", eventsErr)
+		}
+	}
+
+	if objects != "" {
+		if f.objects, objectsErr = CompileObject(objects); objectsErr != nil {
+			objectsErr = fmt.Errorf("objects: %w", objectsErr)
+		}
+	}
+
+	if err := errors.Join(eventsErr, objectsErr); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// ProcessEvent returns whether the event matches the events expression.
+func (f *Filter) ProcessEvent(kubeEvent *common.KubeEvent) bool {
+	if f.events == nil {
+		return true
+	}
+
+	matched, err := f.events.Match(*kubeEvent)
+	if err != nil {
+		logrus.Debugf("Filter %s dropped event, could not evaluate %q: %v", f.name, f.events, err)
+	}
+
+	return matched
+}
+
+// ProcessObject returns whether the object matches the objects expression.
+func (f *Filter) ProcessObject(kubeObject *common.KubeObject) bool {
+	if f.objects == nil {
+		return true
+	}
+
+	matched, err := f.objects.Match(*kubeObject)
+	if err != nil {
+		logrus.Debugf("Filter %s dropped object, could not evaluate %q: %v", f.name, f.objects, err)
+	}
+
+	return matched
+}
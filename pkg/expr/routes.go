@@ -0,0 +1,190 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+// Supported routing modes.
+const (
+	// RoutingFirstMatch sends items to the sinks of the first route they match.
+	RoutingFirstMatch = "firstMatch"
+	// RoutingAllMatches sends items to the sinks of every route they match.
+	RoutingAllMatches = "allMatches"
+)
+
+// Route sends the items matching its CEL expressions to the given sinks, by ID. A route only
+// matches the types of items it has an expression for.
+type Route struct {
+	// Name identifies the route in logs, defaults to its position.
+	Name    string   `yaml:"name,omitempty"`
+	Events  string   `yaml:"events,omitempty"`
+	Objects string   `yaml:"objects,omitempty"`
+	Sinks   []string `yaml:"sinks"`
+}
+
+// RouteName returns the name of the route at the given position.
+func (r Route) RouteName(i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	return fmt.Sprintf("routes[%d]", i)
+}
+
+// Validate checks the route has sinks and at least one expression, and compiles its expressions.
+// All errors found are returned.
+func (r Route) Validate() error {
+	_, err := r.compile(r.Name)
+	return err
+}
+
+func (r Route) compile(name string) (compiledRoute, error) {
+	compiled := compiledRoute{name: name, sinks: slices.Clone(r.Sinks)}
+
+	var errs []error
+	if r.Events == "" && r.Objects == "" {
+		errs = append(errs, errors.New("events or objects expression is required"))
+	}
+
+	if len(r.Sinks) == 0 {
+		errs = append(errs, errors.New("sinks are required"))
+	}
+
+	var err error
+	if r.Events != "" {
+		if compiled.events, err = CompileEvent(r.Events); err != nil {
+			errs = append(errs, fmt.Errorf("events: %w", err))
+		}
+	}
+
+	if r.Objects != "" {
+		if compiled.objects, err = CompileObject(r.Objects); err != nil {
+			errs = append(errs, fmt.Errorf("objects: %w", err))
+		}
+	}
+
+	return compiled, errors.Join(errs...)
+}
+
+type compiledRoute struct {
+	name    string
+	events  *Event
+	objects *Object
+	sinks   []string
+}
+
+// RoutingTable implements both the `router.EventProcessor` and the `router.ObjectProcessor` interfaces.
+// It restricts the sinks events and objects are forwarded to, to the sinks of the routes they match,
+// or to the default sinks if they match none. Items routed to no sink are still forwarded, so handlers
+// receiving every item, like the transitions detector, see them, but no sink does.
+type RoutingTable struct {
+	routes       []compiledRoute
+	defaultSinks []string
+	allMatches   bool
+}
+
+// NewRoutingTable compiles the expressions of the given routes. The mode is either RoutingFirstMatch,
+// the default if empty, or RoutingAllMatches. All compile errors are returned.
+func NewRoutingTable(routes []Route, defaultSinks []string, mode string) (*RoutingTable, error) {
+	var errs []error
+	switch mode {
+	case "", RoutingFirstMatch, RoutingAllMatches:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported mode %q, expected %q or %q", mode, RoutingFirstMatch, RoutingAllMatches))
+	}
+
+	t := &RoutingTable{
+		routes:       make([]compiledRoute, 0, len(routes)),
+		defaultSinks: append([]string{}, defaultSinks...),
+		allMatches:   mode == RoutingAllMatches,
+	}
+
+	for i, route := range routes {
+		compiled, err := route.compile(route.RouteName(i))
+		if err != nil {
+			for _, e := range common.UnwrapJoined(err) {
+				errs = append(errs, fmt.Errorf("routes[%d] (%s): %w", i, route.RouteName(i), e))
+			}
+			continue
+		}
+
+		t.routes = append(t.routes, compiled)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// route returns the sinks of the routes matched by the given function, or the default sinks if none matched.
+// The returned slice is never nil, since nil means the item is forwarded to every sink.
+func (t *RoutingTable) route(matches func(compiledRoute) bool) []string {
+	sinks := []string{}
+	matched := false
+	for _, r := range t.routes {
+		if !matches(r) {
+			continue
+		}
+
+		matched = true
+		for _, sink := range r.sinks {
+			if !slices.Contains(sinks, sink) {
+				sinks = append(sinks, sink)
+			}
+		}
+
+		if !t.allMatches {
+			break
+		}
+	}
+
+	if !matched {
+		return t.defaultSinks
+	}
+
+	return sinks
+}
+
+// ProcessEvent routes the event. It never drops events.
+func (t *RoutingTable) ProcessEvent(kubeEvent *common.KubeEvent) bool {
+	kubeEvent.Sinks = t.route(func(r compiledRoute) bool {
+		if r.events == nil {
+			return false
+		}
+
+		matched, err := r.events.Match(*kubeEvent)
+		if err != nil {
+			logrus.Debugf("Route %s skipped event, could not evaluate %q: %v", r.name, r.events, err)
+		}
+		return matched
+	})
+
+	return true
+}
+
+// ProcessObject routes the object. It never drops objects.
+func (t *RoutingTable) ProcessObject(kubeObject *common.KubeObject) bool {
+	kubeObject.Sinks = t.route(func(r compiledRoute) bool {
+		if r.objects == nil {
+			return false
+		}
+
+		matched, err := r.objects.Match(*kubeObject)
+		if err != nil {
+			logrus.Debugf("Route %s skipped object, could not evaluate %q: %v", r.name, r.objects, err)
+		}
+		return matched
+	})
+
+	return true
+}
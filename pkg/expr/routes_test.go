@@ -0,0 +1,95 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package expr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+func TestRoutingTable_Events(t *testing.T) {
+	routes := []Route{
+		{Name: "security", Events: `event.reason in ["FailedMount", "Unhealthy"] || event.type == "Warning"`, Sinks: []string{"webhook"}},
+		{Name: "prod", Events: `ns.labels.?tier.orValue("") == "prod"`, Sinks: []string{"newRelicInfra", "webhook"}},
+		{Name: "pods", Objects: `kind == "Pod"`, Sinks: []string{"stdout"}},
+	}
+
+	tests := []struct {
+		name     string
+		mode     string
+		defaults []string
+		event    common.KubeEvent
+		expected []string
+	}{
+		{
+			name:     "first match",
+			event:    testEvent("Warning", 1),
+			defaults: []string{"newRelicInfra"},
+			expected: []string{"webhook"},
+		},
+		{
+			name:     "all matches without duplicates",
+			mode:     RoutingAllMatches,
+			event:    testEvent("Warning", 1),
+			expected: []string{"webhook", "newRelicInfra"},
+		},
+		{
+			name:     "default",
+			mode:     RoutingAllMatches,
+			event:    common.KubeEvent{Verb: "ADDED", Event: &v1.Event{Type: "Normal"}},
+			defaults: []string{"newRelicInfra"},
+			expected: []string{"newRelicInfra"},
+		},
+		{
+			name:     "no route nor default",
+			event:    common.KubeEvent{Verb: "ADDED", Event: &v1.Event{Type: "Normal"}},
+			expected: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table, err := NewRoutingTable(routes, test.defaults, test.mode)
+			require.NoError(t, err)
+
+			assert.True(t, table.ProcessEvent(&test.event), "events are never dropped")
+			assert.Equal(t, test.expected, test.event.Sinks)
+			assert.NotNil(t, test.event.Sinks, "routed events are restricted to the given sinks")
+		})
+	}
+}
+
+func TestRoutingTable_Objects(t *testing.T) {
+	table, err := NewRoutingTable([]Route{
+		{Events: `event.type == "Warning"`, Sinks: []string{"webhook"}},
+		{Objects: `object.metadata.labels["app"] == "web"`, Sinks: []string{"stdout"}},
+	}, []string{"newRelicInfra"}, RoutingFirstMatch)
+	require.NoError(t, err)
+
+	web := common.KubeObject{Verb: "ADDED", Obj: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}}}}
+	assert.True(t, table.ProcessObject(&web))
+	assert.Equal(t, []string{"stdout"}, web.Sinks)
+
+	unlabeled := common.KubeObject{Verb: "ADDED", Obj: &v1.Pod{}}
+	assert.True(t, table.ProcessObject(&unlabeled))
+	assert.Equal(t, []string{"newRelicInfra"}, unlabeled.Sinks, "objects failing to evaluate use the default route")
+}
+
+func TestNewRoutingTable_Errors(t *testing.T) {
+	_, err := NewRoutingTable([]Route{
+		{Name: "empty"},
+		{Events: `event.cont > 1`, Sinks: []string{"stdout"}},
+	}, nil, "everything")
+	require.Error(t, err)
+
+	assert.EqualError(t, err, `unsupported mode "everything", expected "firstMatch" or "allMatches"`+"\n"+
+		"routes[0] (empty): events or objects expression is required\n"+
+		"routes[0] (empty): sinks are required\n"+
+		"routes[1] (routes[1]): events: column 6: undefined field 'cont'")
+}
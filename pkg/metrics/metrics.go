@@ -0,0 +1,45 @@
+// Package metrics registers the Prometheus collectors of nri-kube-events with an injected registerer.
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package metrics
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace prefixes the names of all the metrics.
+const Namespace = "nr"
+
+// ClusterLabel is the name of the constant label holding the cluster name.
+const ClusterLabel = "cluster"
+
+// Register registers the collector. If an equal collector was registered already, like by another
+// router sharing the registry or by a sink created before a reload, the existing one is returned
+// instead, so they share it.
+func Register[T prometheus.Collector](reg prometheus.Registerer, collector T) (T, error) {
+	err := reg.Register(collector)
+	if err == nil {
+		return collector, nil
+	}
+
+	var alreadyRegistered prometheus.AlreadyRegisteredError
+	if errors.As(err, &alreadyRegistered) {
+		if existing, ok := alreadyRegistered.ExistingCollector.(T); ok {
+			return existing, nil
+		}
+	}
+
+	return collector, err
+}
+
+// WithClusterName returns a registerer adding the cluster name as a constant label to the metrics
+// registered with it. The given registerer is returned unchanged if the name is empty.
+func WithClusterName(reg prometheus.Registerer, clusterName string) prometheus.Registerer {
+	if clusterName == "" {
+		return reg
+	}
+
+	return prometheus.WrapRegistererWith(prometheus.Labels{ClusterLabel: clusterName}, reg)
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package pipeline
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// createEventsInformer creates a SharedIndexInformer that will listen for Events of the given API.
+// Only events happening after creation will be returned, existing events are discarded.
+func createEventsInformer(clientset kubernetes.Interface, eventsAPI string, stopChan <-chan struct{}) (cache.SharedIndexInformer, error) {
+	// Setting resync to 0 means the SharedInformer will never refresh its internal cache against the API Server.
+	// This is important, because later on we clear the initial cache.
+	resync := time.Duration(0)
+	sharedInformers := informers.NewSharedInformerFactory(clientset, resync)
+
+	var eventsInformer cache.SharedIndexInformer
+	switch eventsAPI {
+	case "", EventsAPICoreV1:
+		eventsInformer = sharedInformers.Core().V1().Events().Informer()
+	case EventsAPIEventsV1:
+		eventsInformer = sharedInformers.Events().V1().Events().Informer()
+	default:
+		return nil, fmt.Errorf("unsupported eventsAPI %q, expected %q or %q", eventsAPI, EventsAPICoreV1, EventsAPIEventsV1)
+	}
+
+	sharedInformers.Start(stopChan)
+
+	// wait for the internal cache to sync. This is the only time the cache will be filled,
+	// since we've set resync to 0. This behavior is very important,
+	// because we will delete the cache to prevent duplicate events from being sent.
+	// If we remove this cache-deletion and you restart nri-kube-events, we will sent lots of duplicated events
+	sharedInformers.WaitForCacheSync(stopChan)
+
+	// There doesn't seem to be a way to start a SharedInformer without local cache,
+	// So we manually delete the cached events. We are only interested in new events.
+	for _, obj := range eventsInformer.GetStore().List() {
+		if err := eventsInformer.GetStore().Delete(obj); err != nil {
+			logrus.Warningln("Unable to delete cached event, duplicated event is possible")
+		}
+	}
+
+	return eventsInformer, nil
+}
+
+// createInformers requests from the factory the SharedIndexInformers for the resources we care about.
+// The factory must be started afterwards.
+func createInformers(sharedInformers informers.SharedInformerFactory) []cache.SharedIndexInformer {
+	return []cache.SharedIndexInformer{
+		sharedInformers.Batch().V1().CronJobs().Informer(),
+		sharedInformers.Apps().V1().DaemonSets().Informer(),
+		sharedInformers.Apps().V1().Deployments().Informer(),
+		sharedInformers.Core().V1().Namespaces().Informer(),
+		sharedInformers.Core().V1().Nodes().Informer(),
+		sharedInformers.Batch().V1().Jobs().Informer(),
+		sharedInformers.Core().V1().PersistentVolumes().Informer(),
+		sharedInformers.Core().V1().PersistentVolumeClaims().Informer(),
+		sharedInformers.Core().V1().Pods().Informer(),
+		sharedInformers.Core().V1().Services().Informer(),
+	}
+}
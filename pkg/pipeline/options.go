@@ -0,0 +1,230 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/informers"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/ratelimit"
+	"github.com/newrelic/nri-kube-events/pkg/router"
+	"github.com/newrelic/nri-kube-events/pkg/sinks"
+	"github.com/newrelic/nri-kube-events/pkg/transitions"
+)
+
+// Supported values for WithEventsAPI.
+const (
+	EventsAPICoreV1   = "v1"
+	EventsAPIEventsV1 = "events.k8s.io/v1"
+)
+
+const (
+	// DefaultDescribeRefresh is how often objects are described again even if they did not change.
+	DefaultDescribeRefresh = 24 * time.Hour
+	// DefaultGracePeriod bounds the time spent draining the routers and flushing the sinks on shutdown.
+	DefaultGracePeriod = 25 * time.Second
+	// StuckCheckInterval is how often objects are checked against the stuck thresholds.
+	StuckCheckInterval = 30 * time.Second
+)
+
+var ErrInvalidDuration = errors.New("duration should be greater than 0")
+
+// Option sets attributes of the Pipeline.
+type Option func(*Pipeline) error
+
+// WithSinks sets the sinks events and descriptions are sent to, by ID.
+func WithSinks(activeSinks map[string]sinks.Sink) Option {
+	return func(p *Pipeline) error {
+		p.activeSinks = activeSinks
+		return nil
+	}
+}
+
+// WithSinkWrapper sets a function wrapping every sink before it is handed to the routers,
+// to observe or alter what the sinks handle. It is applied to the sinks set on reloads too.
+func WithSinkWrapper(wrap func(id string, sink sinks.Sink) sinks.Sink) Option {
+	return func(p *Pipeline) error {
+		p.wrapSink = wrap
+		return nil
+	}
+}
+
+// WithEventProcessors appends processors to be run for every event, to filter or decorate them.
+func WithEventProcessors(processors ...router.EventProcessor) Option {
+	return func(p *Pipeline) error {
+		p.eventProcessors = append(p.eventProcessors, processors...)
+		return nil
+	}
+}
+
+// WithObjectProcessors appends processors to be run for every object, to filter or decorate them.
+func WithObjectProcessors(processors ...router.ObjectProcessor) Option {
+	return func(p *Pipeline) error {
+		p.objectProcessors = append(p.objectProcessors, processors...)
+		return nil
+	}
+}
+
+// WithInformerFactory sets the factory objects are watched with. Sharing it with processors,
+// like the enrichers, means objects are only watched and cached once.
+// The describe refresh is the resync period of the factory.
+func WithInformerFactory(factory informers.SharedInformerFactory) Option {
+	return func(p *Pipeline) error {
+		p.objectInformers = factory
+		return nil
+	}
+}
+
+// WithDescribeRefresh sets how often objects are described again if they did not change.
+// It is ignored if an informer factory is set.
+func WithDescribeRefresh(refresh time.Duration) Option {
+	return func(p *Pipeline) error {
+		if refresh <= 0 {
+			return fmt.Errorf("invalid describe refresh %s: %w", refresh, ErrInvalidDuration)
+		}
+
+		p.describeRefresh = refresh
+		return nil
+	}
+}
+
+// WithWorkQueueLength sets the length of the work queue of both routers, ignoring nil values.
+func WithWorkQueueLength(length *int) Option {
+	return func(p *Pipeline) error {
+		if length == nil {
+			return nil
+		}
+
+		if *length <= 0 {
+			return router.ErrInvalidWorkQueueLength
+		}
+
+		p.workQueueLength = length
+		return nil
+	}
+}
+
+// WithEventsAPI sets the API Events are read from, EventsAPICoreV1 (default) or EventsAPIEventsV1.
+func WithEventsAPI(eventsAPI string) Option {
+	return func(p *Pipeline) error {
+		switch eventsAPI {
+		case "", EventsAPICoreV1, EventsAPIEventsV1:
+		default:
+			return fmt.Errorf("unsupported eventsAPI %q, expected %q or %q", eventsAPI, EventsAPICoreV1, EventsAPIEventsV1)
+		}
+
+		p.eventsAPI = eventsAPI
+		return nil
+	}
+}
+
+// WithCaptureEvents enables or disables sending Kubernetes Events to the sinks. Enabled by default.
+func WithCaptureEvents(enabled bool) Option {
+	return func(p *Pipeline) error {
+		p.captureEvents = enabled
+		return nil
+	}
+}
+
+// WithCaptureDescribe enables or disables sending object descriptions to the sinks. Enabled by default.
+func WithCaptureDescribe(enabled bool) Option {
+	return func(p *Pipeline) error {
+		p.captureDescribe = enabled
+		return nil
+	}
+}
+
+// WithSynthesizeEvents enables or disables emitting events for object condition and phase transitions.
+// It requires events to be captured. Disabled by default.
+func WithSynthesizeEvents(enabled bool) Option {
+	return func(p *Pipeline) error {
+		p.synthesizeEvents = enabled
+		return nil
+	}
+}
+
+// WithStuckThresholds sets the thresholds of the phases and conditions objects are reported for when
+// they stay in them for too long, checked every StuckCheckInterval against the watched objects.
+// Conditions are timed from their last transition, and phases from when the object was seen entering them.
+// It requires events to be synthesized.
+func WithStuckThresholds(thresholds ...transitions.Threshold) Option {
+	return func(p *Pipeline) error {
+		var errs []error
+		for i, threshold := range thresholds {
+			if err := threshold.Validate(); err != nil {
+				for _, e := range common.UnwrapJoined(err) {
+					errs = append(errs, fmt.Errorf("stuckThresholds[%d]: %w", i, e))
+				}
+			}
+		}
+
+		if err := errors.Join(errs...); err != nil {
+			return fmt.Errorf("invalid stuck thresholds: %w", err)
+		}
+
+		p.stuckThresholds = thresholds
+		return nil
+	}
+}
+
+// WithRateLimit sets the limits applied to events before they are queued, to protect the pipeline
+// from event storms. Summaries of the suppressed events are published as synthesized events.
+func WithRateLimit(config ratelimit.Config) Option {
+	return func(p *Pipeline) error {
+		if err := config.Validate(); err != nil {
+			return fmt.Errorf("invalid rate limit: %w", err)
+		}
+
+		p.rateLimit = config
+		return nil
+	}
+}
+
+// WithRegisterer sets the registerer the metrics of the routers are registered with, the Prometheus
+// default registerer if not set. Sinks register their metrics when created, see sinks.WithRegisterer.
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(p *Pipeline) error {
+		if reg == nil {
+			return router.ErrNilRegisterer
+		}
+
+		p.registerer = reg
+		return nil
+	}
+}
+
+// WithName sets the name the work queue length of the routers is labeled with in metrics, as `router`.
+// Pipelines sharing a registerer must have different names.
+func WithName(name string) Option {
+	return func(p *Pipeline) error {
+		p.name = name
+		return nil
+	}
+}
+
+// WithClusterName sets the name of the watched cluster, used as involved object of the synthesized events
+// not related to a single object, like the summaries of the global rate limit.
+func WithClusterName(clusterName string) Option {
+	return func(p *Pipeline) error {
+		p.clusterName = clusterName
+		return nil
+	}
+}
+
+// WithGracePeriod sets the time spent draining the routers and flushing the sinks on shutdown,
+// before queued items are dropped.
+func WithGracePeriod(gracePeriod time.Duration) Option {
+	return func(p *Pipeline) error {
+		if gracePeriod <= 0 {
+			return fmt.Errorf("invalid grace period %s: %w", gracePeriod, ErrInvalidDuration)
+		}
+
+		p.gracePeriod = gracePeriod
+		return nil
+	}
+}
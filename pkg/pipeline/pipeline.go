@@ -0,0 +1,416 @@
+// Package pipeline runs the events and descriptions routers in-process, so nri-kube-events can be
+// embedded by other programs.
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/newrelic/nri-kube-events/pkg/descriptions"
+	"github.com/newrelic/nri-kube-events/pkg/events"
+	"github.com/newrelic/nri-kube-events/pkg/ratelimit"
+	"github.com/newrelic/nri-kube-events/pkg/router"
+	"github.com/newrelic/nri-kube-events/pkg/sinks"
+	"github.com/newrelic/nri-kube-events/pkg/transitions"
+)
+
+// TransitionsHandlerName is the name under which the transitions detector is registered
+// in the descriptions router, next to the sinks. No sink can use it as ID.
+const TransitionsHandlerName = "transitions"
+
+// Pipeline watches Kubernetes Events and objects and sends them to the sinks, through the
+// events and descriptions routers.
+type Pipeline struct {
+	clientset       kubernetes.Interface
+	objectInformers informers.SharedInformerFactory
+	describeRefresh time.Duration
+	workQueueLength *int
+	eventsAPI       string
+	gracePeriod     time.Duration
+	registerer      prometheus.Registerer
+	name            string
+	clusterName     string
+
+	captureEvents    bool
+	captureDescribe  bool
+	synthesizeEvents bool
+	stuckThresholds  []transitions.Threshold
+	rateLimit        ratelimit.Config
+
+	wrapSink         func(id string, sink sinks.Sink) sinks.Sink
+	eventProcessors  []router.EventProcessor
+	objectProcessors []router.ObjectProcessor
+
+	// stopChan is closed when the context given to Start is done.
+	stopChan <-chan struct{}
+	wg       sync.WaitGroup
+
+	// mtx guards the active sinks, which are replaced on reloads, and the routers,
+	// which are created by Start. Either router can be nil if disabled.
+	mtx          sync.RWMutex
+	activeSinks  map[string]sinks.Sink
+	stopSinks    context.CancelFunc
+	eventRouter  *events.Router
+	descRouter   *descriptions.Router
+	detector     *transitions.Detector
+	eventsSynced cache.InformerSynced
+}
+
+// New returns a Pipeline watching the cluster of the given clientset, which does nothing until started.
+func New(clientset kubernetes.Interface, opts ...Option) (*Pipeline, error) {
+	p := &Pipeline{
+		clientset:       clientset,
+		describeRefresh: DefaultDescribeRefresh,
+		gracePeriod:     DefaultGracePeriod,
+		registerer:      prometheus.DefaultRegisterer,
+		captureEvents:   true,
+		captureDescribe: true,
+		activeSinks:     map[string]sinks.Sink{},
+		stopSinks:       func() {},
+	}
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, fmt.Errorf("error with pipeline configuration: %w", err)
+		}
+	}
+
+	if p.objectInformers == nil {
+		p.objectInformers = informers.NewSharedInformerFactory(clientset, p.describeRefresh)
+	}
+
+	return p, nil
+}
+
+// Run starts the pipeline and blocks until the context is done. Items queued by then are sent
+// to the sinks, which are flushed afterwards, within the grace period.
+func (p *Pipeline) Run(ctx context.Context) error {
+	if err := p.Start(ctx); err != nil {
+		return err
+	}
+
+	p.Shutdown()
+	return nil
+}
+
+// Start starts the sinks, the informers and the routers, returning once events are being watched.
+// Everything runs until the context is done, after which Shutdown must be called.
+func (p *Pipeline) Start(ctx context.Context) error {
+	p.stopChan = ctx.Done()
+
+	if err := p.startSinks(p.activeSinks); err != nil {
+		return fmt.Errorf("could not start sinks: %w", err)
+	}
+
+	if err := p.startRouters(); err != nil {
+		p.closeSinks()
+		return err
+	}
+
+	// Informers requested after this point are started by Replace.
+	p.objectInformers.Start(p.stopChan)
+	return nil
+}
+
+func (p *Pipeline) startRouters() error {
+	activeSinks := p.Sinks()
+
+	var eventRouter *events.Router
+	var eventsSynced cache.InformerSynced
+	var limiter *ratelimit.Limiter
+	if p.captureEvents {
+		eventsInformer, err := createEventsInformer(p.clientset, p.eventsAPI, p.stopChan)
+		if err != nil {
+			return fmt.Errorf("could not create events informer: %w", err)
+		}
+
+		opts := []router.ConfigOption{
+			router.WithWorkQueueLength(p.workQueueLength), // will ignore null values
+			router.WithEventProcessors(p.eventProcessors...),
+			router.WithRegisterer(p.registerer),
+			router.WithName(p.name),
+		}
+
+		if p.rateLimit.Enabled() {
+			limiter, err = ratelimit.New(p.rateLimit, p.clusterName, p.registerer)
+			if err != nil {
+				return fmt.Errorf("could not create rate limiter: %w", err)
+			}
+			opts = append(opts, router.WithEventLimiter(limiter))
+		}
+
+		eventsSynced = eventsInformer.HasSynced
+		eventRouter, err = events.NewRouter(eventsInformer, p.eventHandlers(activeSinks), opts...)
+		if err != nil {
+			return fmt.Errorf("could not create events router: %w", err)
+		}
+	}
+
+	var detector *transitions.Detector
+	if p.synthesizeEvents {
+		if eventRouter != nil {
+			detector = transitions.NewDetector(eventRouter, p.stuckThresholds...)
+		} else {
+			logrus.Warnf("synthesizeEvents requires captureEvents to be enabled, no events will be synthesized")
+		}
+	}
+
+	var descRouter *descriptions.Router
+	var objectStores []transitions.Lister
+	if p.captureDescribe || detector != nil {
+		objectInformers := createInformers(p.objectInformers)
+		for _, informer := range objectInformers {
+			objectStores = append(objectStores, informer.GetStore())
+		}
+
+		var err error
+		descRouter, err = descriptions.NewRouter(objectInformers, p.objectHandlers(activeSinks, detector),
+			router.WithWorkQueueLength(p.workQueueLength), // will ignore null values
+			router.WithObjectProcessors(p.objectProcessors...),
+			router.WithRegisterer(p.registerer),
+			router.WithName(p.name),
+			router.WithUnroutedHandlers(TransitionsHandlerName),
+		)
+		if err != nil {
+			return fmt.Errorf("could not create descriptions router: %w", err)
+		}
+	}
+
+	p.mtx.Lock()
+	p.eventRouter, p.descRouter, p.detector, p.eventsSynced = eventRouter, descRouter, detector, eventsSynced
+	p.mtx.Unlock()
+
+	if eventRouter != nil {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			eventRouter.Run(p.stopChan)
+		}()
+	}
+
+	if descRouter != nil {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			descRouter.Run(p.stopChan)
+		}()
+	}
+
+	// Summaries are published until the router is drained, so the limiter is not waited for on shutdown.
+	if limiter != nil {
+		go limiter.Run(p.stopChan, eventRouter)
+	}
+
+	// The same goes for the events of the objects exceeding the stuck thresholds.
+	if detector != nil && len(p.stuckThresholds) > 0 {
+		go detector.Run(p.stopChan, StuckCheckInterval, objectStores...)
+	}
+
+	return nil
+}
+
+func (p *Pipeline) eventHandlers(activeSinks map[string]sinks.Sink) map[string]events.EventHandler {
+	handlers := make(map[string]events.EventHandler)
+	for id, sink := range activeSinks {
+		handlers[id] = p.wrap(id, sink)
+	}
+
+	return handlers
+}
+
+func (p *Pipeline) objectHandlers(activeSinks map[string]sinks.Sink, detector *transitions.Detector) map[string]descriptions.ObjectHandler {
+	handlers := make(map[string]descriptions.ObjectHandler)
+	if p.captureDescribe {
+		for id, sink := range activeSinks {
+			handlers[id] = p.wrap(id, sink)
+		}
+	}
+
+	if detector != nil {
+		handlers[TransitionsHandlerName] = detector
+	}
+
+	return handlers
+}
+
+func (p *Pipeline) wrap(id string, sink sinks.Sink) sinks.Sink {
+	if p.wrapSink == nil {
+		return sink
+	}
+
+	return p.wrapSink(id, sink)
+}
+
+// Replace starts the given sinks and swaps them, together with the processors, into the running
+// routers. The previous sinks are flushed and closed afterwards. Nothing is replaced if the new
+// sinks can't be started. It must be called after Start.
+func (p *Pipeline) Replace(activeSinks map[string]sinks.Sink, eventProcessors []router.EventProcessor, objectProcessors []router.ObjectProcessor) error {
+	p.mtx.RLock()
+	previous, stopPrevious := p.activeSinks, p.stopSinks
+	eventRouter, descRouter, detector := p.eventRouter, p.descRouter, p.detector
+	p.mtx.RUnlock()
+
+	if err := p.startSinks(activeSinks); err != nil {
+		return fmt.Errorf("could not start sinks: %w", err)
+	}
+
+	// Start any informer requested by the new processors.
+	p.objectInformers.Start(p.stopChan)
+
+	if eventRouter != nil {
+		eventRouter.Replace(p.eventHandlers(activeSinks), eventProcessors)
+	}
+
+	if descRouter != nil {
+		descRouter.Replace(p.objectHandlers(activeSinks, detector), objectProcessors)
+	}
+
+	// The routers no longer use the previous sinks, so anything they buffered can be sent.
+	ctx, cancel := context.WithTimeout(context.Background(), p.gracePeriod)
+	defer cancel()
+	if err := sinks.Shutdown(ctx, previous); err != nil {
+		logrus.Warnf("could not shut down replaced sinks: %v", err)
+	}
+	stopPrevious()
+
+	return nil
+}
+
+// startSinks starts the given sinks, which become the active ones.
+func (p *Pipeline) startSinks(activeSinks map[string]sinks.Sink) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := sinks.Start(ctx, activeSinks); err != nil {
+		cancel()
+		return err
+	}
+
+	p.mtx.Lock()
+	p.activeSinks = activeSinks
+	p.stopSinks = cancel
+	p.mtx.Unlock()
+	return nil
+}
+
+// closeSinks shuts the active sinks down within the grace period.
+func (p *Pipeline) closeSinks() {
+	ctx, cancel := context.WithTimeout(context.Background(), p.gracePeriod)
+	defer cancel()
+
+	p.mtx.RLock()
+	activeSinks, stopSinks := p.activeSinks, p.stopSinks
+	p.mtx.RUnlock()
+
+	if err := sinks.Shutdown(ctx, activeSinks); err != nil {
+		logrus.Warnf("could not shut down sinks: %v", err)
+	}
+	stopSinks()
+}
+
+// Sinks returns the active sinks, by ID.
+func (p *Pipeline) Sinks() map[string]sinks.Sink {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	return p.activeSinks
+}
+
+// RouterStatuses returns the status of the running routers, by name.
+func (p *Pipeline) RouterStatuses() map[string]router.Status {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	statuses := make(map[string]router.Status)
+	if p.eventRouter != nil {
+		statuses["eventRouter"] = p.eventRouter.Status()
+	}
+
+	if p.descRouter != nil {
+		statuses["descriptionsRouter"] = p.descRouter.Status()
+	}
+
+	return statuses
+}
+
+// InformersSynced returns an error if the events informer or any of the object informers
+// started has not synced its cache yet.
+func (p *Pipeline) InformersSynced() error {
+	p.mtx.RLock()
+	eventsSynced := p.eventsSynced
+	p.mtx.RUnlock()
+
+	var unsynced []string
+	if eventsSynced != nil && !eventsSynced() {
+		unsynced = append(unsynced, "events")
+	}
+
+	// Waiting with a closed channel checks each informer once without blocking.
+	closed := make(chan struct{})
+	close(closed)
+	for informerType, synced := range p.objectInformers.WaitForCacheSync(closed) {
+		if !synced {
+			unsynced = append(unsynced, informerType.String())
+		}
+	}
+
+	if len(unsynced) == 0 {
+		return nil
+	}
+
+	sort.Strings(unsynced)
+	return fmt.Errorf("informers not synced: %s", strings.Join(unsynced, ", "))
+}
+
+// Shutdown waits for the routers to stop once the context given to Start is done, then drains
+// the items queued in them and flushes the sinks afterwards, within the grace period.
+func (p *Pipeline) Shutdown() {
+	<-p.stopChan
+	p.wg.Wait()
+
+	logrus.Infof("Draining queued items, grace period %s", p.gracePeriod)
+	ctx, cancel := context.WithTimeout(context.Background(), p.gracePeriod)
+	defer cancel()
+
+	p.mtx.RLock()
+	eventRouter, descRouter := p.eventRouter, p.descRouter
+	p.mtx.RUnlock()
+
+	// Transitions detected while draining descriptions are published as events,
+	// so events are drained until descriptions are done.
+	var descDropped, eventsDropped int
+	descDrained := make(chan struct{})
+	go func() {
+		defer close(descDrained)
+		if descRouter != nil {
+			descDropped = descRouter.Drain(ctx, nil)
+		}
+	}()
+
+	if eventRouter != nil {
+		eventsDropped = eventRouter.Drain(ctx, descDrained)
+	}
+	<-descDrained
+
+	p.mtx.RLock()
+	activeSinks, stopSinks := p.activeSinks, p.stopSinks
+	p.mtx.RUnlock()
+
+	if err := sinks.Shutdown(ctx, activeSinks); err != nil {
+		logrus.Warnf("could not shut down sinks: %v", err)
+	}
+	stopSinks()
+
+	if dropped := descDropped + eventsDropped; dropped > 0 {
+		logrus.Warnf("Shutdown dropped %d queued items", dropped)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/expr"
+	"github.com/newrelic/nri-kube-events/pkg/router"
+	"github.com/newrelic/nri-kube-events/pkg/sinks"
+	"github.com/newrelic/nri-kube-events/pkg/transitions"
+)
+
+// recordingSink keeps the names of the events and objects handled, and whether it was closed.
+type recordingSink struct {
+	mtx     sync.Mutex
+	events  []string
+	objects []string
+	closed  bool
+}
+
+func (s *recordingSink) HandleEvent(kubeEvent common.KubeEvent) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.events = append(s.events, kubeEvent.Event.Name)
+	return nil
+}
+
+func (s *recordingSink) HandleObject(kubeObject common.KubeObject) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if pod, ok := kubeObject.Obj.(*v1.Pod); ok {
+		s.objects = append(s.objects, pod.Name)
+	}
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.closed = true
+	return nil
+}
+
+func (s *recordingSink) handledEvents() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]string(nil), s.events...)
+}
+
+func (s *recordingSink) handledObjects() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]string(nil), s.objects...)
+}
+
+func (s *recordingSink) isClosed() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.closed
+}
+
+// dropReason drops events with the given reason.
+type dropReason string
+
+func (d dropReason) ProcessEvent(kubeEvent *common.KubeEvent) bool {
+	return kubeEvent.Event.Reason != string(d)
+}
+
+func createEvent(t *testing.T, clientset *fake.Clientset, name, reason string) {
+	t.Helper()
+
+	_, err := clientset.CoreV1().Events("default").Create(context.Background(), &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Reason:     reason,
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+func TestPipeline(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1.Event{ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: "default"}})
+	sink := &recordingSink{}
+
+	p, err := New(clientset,
+		WithSinks(map[string]sinks.Sink{"recording": sink}),
+		WithEventProcessors(dropReason("Noisy")),
+		WithGracePeriod(time.Second),
+		WithRegisterer(prometheus.NewRegistry()),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error)
+	go func() { ran <- p.Run(ctx) }()
+
+	assert.Eventually(t, func() bool { return len(p.RouterStatuses()) == 2 && p.InformersSynced() == nil },
+		5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, p.RouterStatuses(), "eventRouter")
+	assert.Contains(t, p.RouterStatuses(), "descriptionsRouter")
+
+	createEvent(t, clientset, "started", "Started")
+	createEvent(t, clientset, "noisy", "Noisy")
+	_, err = clientset.CoreV1().Pods("default").Create(context.Background(),
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return len(sink.handledEvents()) == 1 && len(sink.handledObjects()) == 1 },
+		5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"started"}, sink.handledEvents(), "existing events are skipped and processors run")
+	assert.Equal(t, []string{"pod"}, sink.handledObjects())
+
+	replacement := &recordingSink{}
+	require.NoError(t, p.Replace(map[string]sinks.Sink{"replacement": replacement}, nil, nil))
+	assert.True(t, sink.isClosed(), "replaced sinks are closed")
+	assert.Equal(t, map[string]sinks.Sink{"replacement": replacement}, p.Sinks())
+
+	createEvent(t, clientset, "noisy-again", "Noisy")
+	assert.Eventually(t, func() bool { return len(replacement.handledEvents()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"noisy-again"}, replacement.handledEvents(), "processors are replaced")
+
+	cancel()
+	select {
+	case err := <-ran:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "Run did not return")
+	}
+	assert.True(t, replacement.isClosed(), "sinks are closed on shutdown")
+}
+
+func TestPipeline_TransitionsOfFilteredObjects(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	sink := &recordingSink{}
+
+	noPods, err := expr.NewFilter("no-pods", "", `kind != "Pod"`)
+	require.NoError(t, err)
+
+	p, err := New(clientset,
+		WithSinks(map[string]sinks.Sink{"recording": sink}),
+		WithObjectProcessors(noPods),
+		WithSynthesizeEvents(true),
+		WithRegisterer(prometheus.NewRegistry()),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, p.Start(ctx))
+	assert.Eventually(t, func() bool { return p.InformersSynced() == nil }, 5*time.Second, 10*time.Millisecond)
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", ResourceVersion: "1"},
+		Status:     v1.PodStatus{Phase: v1.PodPending},
+	}
+	_, err = clientset.CoreV1().Pods("default").Create(context.Background(), pod, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	pod.ResourceVersion = "2"
+	pod.Status.Phase = v1.PodFailed
+	_, err = clientset.CoreV1().Pods("default").UpdateStatus(context.Background(), pod, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return len(sink.handledEvents()) == 1 }, 5*time.Second, 10*time.Millisecond,
+		"transitions are detected from objects dropped by filters")
+	assert.Regexp(t, `^pod\.`, sink.handledEvents()[0])
+
+	cancel()
+	p.Shutdown()
+	assert.Empty(t, sink.handledObjects(), "filtered objects are not sent")
+}
+
+func TestPipeline_CaptureDisabled(t *testing.T) {
+	p, err := New(fake.NewSimpleClientset(), WithCaptureEvents(false), WithCaptureDescribe(false), WithRegisterer(prometheus.NewRegistry()))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, p.Start(ctx))
+	assert.Empty(t, p.RouterStatuses())
+
+	cancel()
+	p.Shutdown()
+}
+
+// slowSink takes a while to handle every event, so work queues fill up.
+type slowSink struct {
+	recordingSink
+	delay time.Duration
+}
+
+func (s *slowSink) HandleEvent(kubeEvent common.KubeEvent) error {
+	time.Sleep(s.delay)
+	return s.recordingSink.HandleEvent(kubeEvent)
+}
+
+func TestPipeline_ShutdownReleasesInformers(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	clientset := fake.NewSimpleClientset()
+	sink := &slowSink{delay: 50 * time.Millisecond}
+	length := 1
+	p, err := New(clientset,
+		WithSinks(map[string]sinks.Sink{"slow": sink}),
+		WithCaptureDescribe(false),
+		WithWorkQueueLength(&length),
+		WithGracePeriod(time.Nanosecond),
+		WithRegisterer(prometheus.NewRegistry()),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, p.Start(ctx))
+	assert.Eventually(t, func() bool { return p.InformersSynced() == nil }, 5*time.Second, 10*time.Millisecond)
+
+	// The informer is blocked on the full queue, and the grace period is over before it is drained.
+	for i := range 20 {
+		createEvent(t, clientset, fmt.Sprintf("event-%d", i), "Started")
+	}
+	assert.Eventually(t, func() bool { return len(sink.handledEvents()) > 0 }, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	p.Shutdown()
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	zero := 0
+
+	tests := []struct {
+		name   string
+		option Option
+		err    string
+	}{
+		{name: "work queue length", option: WithWorkQueueLength(&zero), err: router.ErrInvalidWorkQueueLength.Error()},
+		{name: "events API", option: WithEventsAPI("events/v2"), err: `unsupported eventsAPI "events/v2", expected "v1" or "events.k8s.io/v1"`},
+		{name: "describe refresh", option: WithDescribeRefresh(0), err: "invalid describe refresh 0s: " + ErrInvalidDuration.Error()},
+		{name: "grace period", option: WithGracePeriod(-time.Second), err: "invalid grace period -1s: " + ErrInvalidDuration.Error()},
+		{name: "registerer", option: WithRegisterer(nil), err: router.ErrNilRegisterer.Error()},
+		{
+			name:   "stuck thresholds",
+			option: WithStuckThresholds(transitions.Threshold{Kind: "Pod", Phase: "Pending"}),
+			err:    "invalid stuck thresholds: stuckThresholds[0]: after must be positive, got 0s",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(fake.NewSimpleClientset(), test.option)
+			assert.EqualError(t, err, "error with pipeline configuration: "+test.err)
+		})
+	}
+}
+
+func TestCreateEventsInformer(t *testing.T) {
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+
+	informer, err := createEventsInformer(fake.NewSimpleClientset(), EventsAPIEventsV1, stopChan)
+	assert.NoError(t, err)
+	assert.True(t, informer.HasSynced())
+
+	_, err = createEventsInformer(fake.NewSimpleClientset(), "events/v2", stopChan)
+	assert.EqualError(t, err, `unsupported eventsAPI "events/v2", expected "v1" or "events.k8s.io/v1"`)
+}
@@ -0,0 +1,351 @@
+// Package ratelimit protects the events pipeline from event storms, like the ones caused by a crashlooping
+// pod or a flapping node, limiting the rate of events per involved object and overall.
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/metrics"
+)
+
+// Supported actions taken on the events exceeding a limit.
+const (
+	// ActionShed drops every event exceeding the limit.
+	ActionShed = "shed"
+	// ActionSample keeps one out of every SampleEvery events exceeding the limit.
+	ActionSample = "sample"
+)
+
+const (
+	// DefaultSummaryInterval is how often summaries of the suppressed events are published.
+	DefaultSummaryInterval = time.Minute
+
+	// ReasonEventsSuppressed is the reason of the summary events.
+	ReasonEventsSuppressed = "EventsSuppressed"
+
+	// KindCluster is the kind of the involved object of the global limit summaries, named after the cluster.
+	KindCluster = "Cluster"
+)
+
+// Label values of the suppression metrics.
+const (
+	limitObject = "object"
+	limitGlobal = "global"
+)
+
+// Limit is a token bucket allowing Burst events at once, refilled at Rate events per second.
+type Limit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+func (l *Limit) validate(name string) []error {
+	if l == nil {
+		return nil
+	}
+
+	var errs []error
+	if l.Rate <= 0 {
+		errs = append(errs, fmt.Errorf("%s.rate must be positive, got %g", name, l.Rate))
+	}
+
+	if l.Burst <= 0 {
+		errs = append(errs, fmt.Errorf("%s.burst must be positive, got %d", name, l.Burst))
+	}
+
+	return errs
+}
+
+// Config defines the limits applied to events, either of which can be nil to disable it.
+type Config struct {
+	// PerObject limits the events of each involved object.
+	PerObject *Limit `yaml:"perObject,omitempty"`
+	// Global limits the events of all the objects together.
+	Global *Limit `yaml:"global,omitempty"`
+	// Action taken on the events exceeding a limit, ActionShed (default) or ActionSample.
+	Action string `yaml:"action,omitempty"`
+	// SampleEvery is the amount of events exceeding a limit out of which one is kept, if sampling.
+	SampleEvery int `yaml:"sampleEvery,omitempty"`
+	// SummaryInterval is how often summaries of the suppressed events are published, DefaultSummaryInterval if zero.
+	SummaryInterval time.Duration `yaml:"summaryInterval,omitempty"`
+}
+
+// Enabled returns whether any limit is set.
+func (c Config) Enabled() bool {
+	return c.PerObject != nil || c.Global != nil
+}
+
+// Validate checks the values of the configuration, returning all the errors found.
+func (c Config) Validate() error {
+	var errs []error
+	errs = append(errs, c.PerObject.validate("perObject")...)
+	errs = append(errs, c.Global.validate("global")...)
+
+	switch c.Action {
+	case "", ActionShed:
+		if c.SampleEvery != 0 {
+			errs = append(errs, fmt.Errorf("sampleEvery requires action %q", ActionSample))
+		}
+	case ActionSample:
+		if c.SampleEvery < 2 {
+			errs = append(errs, fmt.Errorf("sampleEvery must be at least 2, got %d", c.SampleEvery))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported action %q, expected %q or %q", c.Action, ActionShed, ActionSample))
+	}
+
+	if c.SummaryInterval < 0 {
+		errs = append(errs, fmt.Errorf("summaryInterval must be positive, got %s", c.SummaryInterval))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Publisher receives the summary events. It's implemented by `events.Router`.
+type Publisher interface {
+	Publish(kubeEvent common.KubeEvent)
+}
+
+// bucket tracks the events of a single involved object, or of all of them for the global limit.
+type bucket struct {
+	limiter *rate.Limiter
+	// exceeded counts the events exceeding the limit since it was last allowed, to sample them.
+	exceeded int
+	// suppressed counts the events dropped since the last summary.
+	suppressed int
+	// reference is the involved object of the last suppressed event.
+	reference v1.ObjectReference
+}
+
+// Limiter implements the `router.EventLimiter` interface. It drops or samples the events exceeding
+// the per object and the global limits, and periodically publishes a summary event for every object
+// with suppressed events. It is safe for concurrent use.
+type Limiter struct {
+	config      Config
+	clusterName string
+	now         func() time.Time
+
+	mtx     sync.Mutex
+	objects map[string]*bucket
+	global  *bucket
+
+	suppressed *prometheus.CounterVec
+	tracked    prometheus.Gauge
+}
+
+// New returns a Limiter enforcing the given configuration, registering its metrics with the given registerer.
+// The summaries of the global limit are reported for the cluster with the given name.
+func New(config Config, clusterName string, reg prometheus.Registerer) (*Limiter, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rate limit configuration: %w", err)
+	}
+
+	if config.SummaryInterval == 0 {
+		config.SummaryInterval = DefaultSummaryInterval
+	}
+
+	suppressed, suppressedErr := metrics.Register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "kube_events",
+		Name:      "suppressed_events_total",
+		Help:      "Total amount of events suppressed by the rate limits, per limit",
+	}, []string{"limit"}))
+	tracked, trackedErr := metrics.Register(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "kube_events",
+		Name:      "rate_limited_objects",
+		Help:      "Number of involved objects currently tracked by the per object rate limit",
+	}))
+
+	if err := errors.Join(suppressedErr, trackedErr); err != nil {
+		return nil, fmt.Errorf("could not register rate limit metrics: %w", err)
+	}
+
+	l := &Limiter{
+		config:      config,
+		clusterName: clusterName,
+		now:         time.Now,
+		objects:     make(map[string]*bucket),
+		suppressed:  suppressed,
+		tracked:     tracked,
+	}
+
+	if config.Global != nil {
+		l.global = &bucket{limiter: rate.NewLimiter(rate.Limit(config.Global.Rate), config.Global.Burst)}
+	}
+
+	return l, nil
+}
+
+// objectKey identifies the involved object of the event.
+func objectKey(ref v1.ObjectReference) string {
+	return ref.Kind + "/" + ref.Namespace + "/" + ref.Name
+}
+
+// Allow returns whether the event is within the limits, or is kept by sampling.
+func (l *Limiter) Allow(kubeEvent common.KubeEvent) bool {
+	if kubeEvent.Event == nil {
+		return true
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	ref := kubeEvent.Event.InvolvedObject
+
+	if l.config.PerObject != nil {
+		key := objectKey(ref)
+		b, ok := l.objects[key]
+		if !ok {
+			b = &bucket{limiter: rate.NewLimiter(rate.Limit(l.config.PerObject.Rate), l.config.PerObject.Burst)}
+			l.objects[key] = b
+			l.tracked.Set(float64(len(l.objects)))
+		}
+
+		if !l.admit(b, now, ref) {
+			l.suppressed.WithLabelValues(limitObject).Inc()
+			return false
+		}
+	}
+
+	if l.global != nil && !l.admit(l.global, now, ref) {
+		l.suppressed.WithLabelValues(limitGlobal).Inc()
+		return false
+	}
+
+	return true
+}
+
+// admit takes a token from the bucket, or decides whether to keep the event if there is none.
+func (l *Limiter) admit(b *bucket, now time.Time, ref v1.ObjectReference) bool {
+	if b.limiter.AllowN(now, 1) {
+		b.exceeded = 0
+		return true
+	}
+
+	b.exceeded++
+	if l.config.Action == ActionSample && b.exceeded%l.config.SampleEvery == 1 {
+		return true
+	}
+
+	b.suppressed++
+	b.reference = ref
+	return false
+}
+
+// Run publishes the summaries of the suppressed events every summary interval, until the stop channel
+// is closed. Objects whose limit is no longer exceeded are forgotten meanwhile.
+func (l *Limiter) Run(stopChan <-chan struct{}, publisher Publisher) {
+	ticker := time.NewTicker(l.config.SummaryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			for _, summary := range l.Summaries() {
+				publisher.Publish(summary)
+			}
+		}
+	}
+}
+
+// Summaries returns a synthesized event for every object with events suppressed since the last call,
+// and one for the events suppressed by the global limit, resetting their counts.
+func (l *Limiter) Summaries() []common.KubeEvent {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	var summaries []common.KubeEvent
+
+	keys := make([]string, 0, len(l.objects))
+	for key := range l.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		b := l.objects[key]
+		if b.suppressed > 0 {
+			message := fmt.Sprintf("%d events suppressed for %s %s in the last %s",
+				b.suppressed, b.reference.Kind, namespacedName(b.reference), l.config.SummaryInterval)
+			summaries = append(summaries, l.summary(now, b.reference, b.suppressed, message))
+			b.suppressed = 0
+			continue
+		}
+
+		// The limiter is full again, so it is the same as a new one.
+		if b.limiter.TokensAt(now) >= float64(b.limiter.Burst()) {
+			delete(l.objects, key)
+		}
+	}
+	l.tracked.Set(float64(len(l.objects)))
+
+	if l.global != nil && l.global.suppressed > 0 {
+		message := fmt.Sprintf("%d events suppressed by the global rate limit in the last %s",
+			l.global.suppressed, l.config.SummaryInterval)
+		cluster := v1.ObjectReference{Kind: KindCluster, Name: l.clusterName}
+		summaries = append(summaries, l.summary(now, cluster, l.global.suppressed, message))
+		l.global.suppressed = 0
+	}
+
+	if len(summaries) > 0 {
+		logrus.Debugf("Publishing %d summaries of suppressed events", len(summaries))
+	}
+
+	return summaries
+}
+
+func namespacedName(ref v1.ObjectReference) string {
+	if ref.Namespace == "" {
+		return ref.Name
+	}
+
+	return ref.Namespace + "/" + ref.Name
+}
+
+// summary returns a synthesized Warning event reporting the suppressed events of the given object.
+func (l *Limiter) summary(now time.Time, ref v1.ObjectReference, suppressed int, message string) common.KubeEvent {
+	timestamp := metav1.NewTime(now)
+	name := ref.Name
+	if name == "" {
+		name = "global"
+	}
+
+	return common.KubeEvent{
+		Verb: "ADDED",
+		Event: &v1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s.%x", name, now.UnixNano()),
+				Namespace: ref.Namespace,
+			},
+			InvolvedObject:      ref,
+			Reason:              ReasonEventsSuppressed,
+			Message:             message,
+			Type:                v1.EventTypeWarning,
+			Count:               int32(min(suppressed, math.MaxInt32)),
+			FirstTimestamp:      metav1.NewTime(now.Add(-l.config.SummaryInterval)),
+			LastTimestamp:       timestamp,
+			Source:              v1.EventSource{Component: common.Component},
+			ReportingController: common.ReportingController,
+		},
+		Synthesized: true,
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+func podEvent(name string) common.KubeEvent {
+	return common.KubeEvent{
+		Verb: "ADDED",
+		Event: &v1.Event{
+			InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "shop", Name: name},
+			Reason:         "BackOff",
+		},
+	}
+}
+
+func newTestLimiter(t *testing.T, config Config) (*Limiter, *time.Time) {
+	t.Helper()
+
+	l, err := New(config, "test-cluster", prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+// allowed returns how many of the given events are allowed.
+func allowed(l *Limiter, events ...common.KubeEvent) int {
+	n := 0
+	for _, e := range events {
+		if l.Allow(e) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func repeat(e common.KubeEvent, n int) []common.KubeEvent {
+	events := make([]common.KubeEvent, n)
+	for i := range events {
+		events[i] = e
+	}
+
+	return events
+}
+
+func TestLimiter_PerObject(t *testing.T) {
+	l, now := newTestLimiter(t, Config{PerObject: &Limit{Rate: 1, Burst: 5}})
+
+	assert.Equal(t, 5, allowed(l, repeat(podEvent("crashing"), 20)...), "the burst is allowed")
+	assert.Equal(t, 5, allowed(l, repeat(podEvent("other"), 5)...), "other objects have their own limit")
+	assert.Equal(t, float64(15), testutil.ToFloat64(l.suppressed.WithLabelValues(limitObject)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(l.tracked))
+
+	*now = now.Add(2 * time.Second)
+	assert.Equal(t, 2, allowed(l, repeat(podEvent("crashing"), 5)...), "tokens are refilled at the rate")
+}
+
+func TestLimiter_Global(t *testing.T) {
+	l, _ := newTestLimiter(t, Config{Global: &Limit{Rate: 1, Burst: 3}})
+
+	assert.Equal(t, 3, allowed(l, podEvent("a"), podEvent("b"), podEvent("c"), podEvent("d")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(l.suppressed.WithLabelValues(limitGlobal)))
+	assert.True(t, l.Allow(common.KubeEvent{Verb: "ADDED"}), "items without event are not limited")
+}
+
+func TestLimiter_Sample(t *testing.T) {
+	l, _ := newTestLimiter(t, Config{PerObject: &Limit{Rate: 1, Burst: 2}, Action: ActionSample, SampleEvery: 5})
+
+	// 2 within the burst, then 1 out of every 5 of the 18 exceeding it.
+	assert.Equal(t, 2+4, allowed(l, repeat(podEvent("crashing"), 20)...))
+	assert.Equal(t, float64(14), testutil.ToFloat64(l.suppressed.WithLabelValues(limitObject)))
+}
+
+func TestLimiter_Summaries(t *testing.T) {
+	l, now := newTestLimiter(t, Config{
+		PerObject:       &Limit{Rate: 1, Burst: 1},
+		Global:          &Limit{Rate: 1, Burst: 4},
+		SummaryInterval: 30 * time.Second,
+	})
+
+	allowed(l, repeat(podEvent("crashing"), 4)...)
+	allowed(l, podEvent("a"), podEvent("b"), podEvent("c"), podEvent("d"))
+
+	summaries := l.Summaries()
+	require.Len(t, summaries, 2)
+
+	object := summaries[0]
+	assert.True(t, object.Synthesized)
+	assert.Equal(t, ReasonEventsSuppressed, object.Event.Reason)
+	assert.Equal(t, v1.EventTypeWarning, object.Event.Type)
+	assert.Equal(t, int32(3), object.Event.Count)
+	assert.Equal(t, v1.ObjectReference{Kind: "Pod", Namespace: "shop", Name: "crashing"}, object.Event.InvolvedObject)
+	assert.Equal(t, "3 events suppressed for Pod shop/crashing in the last 30s", object.Event.Message)
+	assert.Equal(t, "shop", object.Event.Namespace)
+
+	global := summaries[1]
+	assert.Equal(t, "1 events suppressed by the global rate limit in the last 30s", global.Event.Message)
+	assert.Equal(t, v1.ObjectReference{Kind: KindCluster, Name: "test-cluster"}, global.Event.InvolvedObject)
+
+	assert.Empty(t, l.Summaries(), "counts are reset")
+	assert.Len(t, l.objects, 5, "objects are tracked while their limit is exceeded")
+
+	*now = now.Add(time.Minute)
+	assert.Empty(t, l.Summaries())
+	assert.Empty(t, l.objects, "idle objects are forgotten")
+	assert.Equal(t, float64(0), testutil.ToFloat64(l.tracked))
+}
+
+type recordingPublisher chan common.KubeEvent
+
+func (p recordingPublisher) Publish(kubeEvent common.KubeEvent) {
+	p <- kubeEvent
+}
+
+func TestLimiter_Run(t *testing.T) {
+	l, err := New(Config{PerObject: &Limit{Rate: 1, Burst: 1}, SummaryInterval: 10 * time.Millisecond}, "test-cluster", prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	allowed(l, repeat(podEvent("crashing"), 3)...)
+
+	published := make(recordingPublisher, 1)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Run(stop, published)
+	}()
+
+	select {
+	case summary := <-published:
+		assert.Equal(t, int32(2), summary.Event.Count)
+	case <-time.After(time.Second):
+		assert.Fail(t, "no summary published")
+	}
+
+	close(stop)
+	<-done
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, Config{}.Validate())
+	assert.False(t, Config{}.Enabled())
+	assert.True(t, Config{Global: &Limit{Rate: 0.5, Burst: 1}}.Enabled())
+
+	err := Config{
+		PerObject:       &Limit{Rate: -1},
+		Action:          "throttle",
+		SummaryInterval: -time.Second,
+	}.Validate()
+	assert.EqualError(t, err, "perObject.rate must be positive, got -1\n"+
+		"perObject.burst must be positive, got 0\n"+
+		`unsupported action "throttle", expected "shed" or "sample"`+"\n"+
+		"summaryInterval must be positive, got -1s")
+
+	assert.EqualError(t, Config{Action: ActionSample, SampleEvery: 1}.Validate(), "sampleEvery must be at least 2, got 1")
+
+	_, err = New(Config{Global: &Limit{Rate: 1}}, "test-cluster", prometheus.NewRegistry())
+	assert.EqualError(t, err, "invalid rate limit configuration: global.burst must be positive, got 0")
+}
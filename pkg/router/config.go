@@ -3,14 +3,63 @@
 // SPDX-License-Identifier: Apache-2.0
 package router
 
-import "errors"
+import (
+	"errors"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
 
 var ErrInvalidWorkQueueLength = errors.New("new workQueueLength value. Value should be greater than 0")
 
+var ErrNilRegisterer = errors.New("prometheus registerer must not be nil")
+
+// EventProcessor inspects, decorates or drops events before they are forwarded to the sinks.
+// Processors run sequentially in the router goroutine, and must not modify the `*v1.Event`
+// since it's shared with the informer cache.
+type EventProcessor interface {
+	// ProcessEvent returns false if the event must be dropped.
+	ProcessEvent(kubeEvent *common.KubeEvent) bool
+}
+
+// ObjectProcessor inspects, decorates or drops objects before they are forwarded to the sinks.
+// The same restrictions as for EventProcessor apply: the `runtime.Object` must not be modified.
+type ObjectProcessor interface {
+	// ProcessObject returns false if the object must be dropped.
+	ProcessObject(kubeObject *common.KubeObject) bool
+}
+
+// EventLimiter decides whether events coming from the informer are queued, to protect the router
+// from event storms. It is called from the informer goroutine, before events are queued.
+type EventLimiter interface {
+	// Allow returns false if the event must be dropped.
+	Allow(kubeEvent common.KubeEvent) bool
+}
+
 type Config struct {
 	// workQueueLength defines the workQueue's channel backlog.
 	// It's needed to handle surges of new objects.
 	workQueueLength int
+
+	// eventProcessors are run in order for every event before it reaches the sinks.
+	eventProcessors []EventProcessor
+
+	// objectProcessors are run in order for every object before it reaches the sinks.
+	objectProcessors []ObjectProcessor
+
+	// registerer is where the metrics of the router are registered.
+	registerer prometheus.Registerer
+
+	// unroutedHandlers receive every item, regardless of the sinks it is routed to.
+	unroutedHandlers map[string]bool
+
+	// eventLimiter drops events before they are queued, if set.
+	eventLimiter EventLimiter
+
+	// name labels the metrics of the router, so several routers can share a registerer.
+	name string
 }
 
 // ConfigOption set attributes of the `router.Config`.
@@ -19,6 +68,7 @@ type ConfigOption func(*Config) error
 func NewConfig(opts ...ConfigOption) (*Config, error) {
 	c := &Config{
 		workQueueLength: 1024,
+		registerer:      prometheus.DefaultRegisterer,
 	}
 	for _, opt := range opts {
 		err := opt(c)
@@ -46,6 +96,98 @@ func WithWorkQueueLength(length *int) ConfigOption {
 	}
 }
 
+// WithEventProcessors appends processors to be run for every event.
+func WithEventProcessors(processors ...EventProcessor) ConfigOption {
+	return func(rc *Config) error {
+		rc.eventProcessors = append(rc.eventProcessors, processors...)
+		return nil
+	}
+}
+
+// WithObjectProcessors appends processors to be run for every object.
+func WithObjectProcessors(processors ...ObjectProcessor) ConfigOption {
+	return func(rc *Config) error {
+		rc.objectProcessors = append(rc.objectProcessors, processors...)
+		return nil
+	}
+}
+
+// WithRegisterer sets the registerer the metrics of the router are registered with,
+// the Prometheus default registerer if not set.
+func WithRegisterer(reg prometheus.Registerer) ConfigOption {
+	return func(rc *Config) error {
+		if reg == nil {
+			return ErrNilRegisterer
+		}
+
+		rc.registerer = reg
+		return nil
+	}
+}
+
+// WithUnroutedHandlers sets handlers, by name, which receive every item regardless of the sinks
+// it is routed to and before processors run, like the transitions detector, which must see every object.
+func WithUnroutedHandlers(names ...string) ConfigOption {
+	return func(rc *Config) error {
+		if rc.unroutedHandlers == nil {
+			rc.unroutedHandlers = make(map[string]bool)
+		}
+
+		for _, name := range names {
+			rc.unroutedHandlers[name] = true
+		}
+		return nil
+	}
+}
+
+// WithEventLimiter sets a limiter deciding which events coming from the informer are queued.
+// Published events, like the synthesized ones, are not limited.
+func WithEventLimiter(limiter EventLimiter) ConfigOption {
+	return func(rc *Config) error {
+		rc.eventLimiter = limiter
+		return nil
+	}
+}
+
+// WithName sets the name the work queue length of the router is labeled with, as `router`.
+// Routers sharing a registerer must have different names.
+func WithName(name string) ConfigOption {
+	return func(rc *Config) error {
+		rc.name = name
+		return nil
+	}
+}
+
 func (rc *Config) WorkQueueLength() int {
 	return rc.workQueueLength
 }
+
+func (rc *Config) EventProcessors() []EventProcessor {
+	return rc.eventProcessors
+}
+
+func (rc *Config) ObjectProcessors() []ObjectProcessor {
+	return rc.objectProcessors
+}
+
+func (rc *Config) Registerer() prometheus.Registerer {
+	return rc.registerer
+}
+
+func (rc *Config) UnroutedHandlers() map[string]bool {
+	return rc.unroutedHandlers
+}
+
+func (rc *Config) EventLimiter() EventLimiter {
+	return rc.eventLimiter
+}
+
+func (rc *Config) Name() string {
+	return rc.name
+}
+
+// Routed returns whether an item routed to the given sinks is forwarded to the named handler.
+// Items are forwarded to every handler if sinks is nil, meaning they were not routed.
+func Routed(sinks []string, name string) bool {
+	return sinks == nil || slices.Contains(sinks, name)
+}
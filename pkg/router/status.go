@@ -0,0 +1,52 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package router
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Status is a snapshot of the work done by a router, used to check its health.
+type Status struct {
+	// LastProcessed is when the router last finished handling an item, or when it started if it has not handled any.
+	LastProcessed time.Time
+	// QueueLength is the amount of items waiting in the work queue.
+	QueueLength int
+	// QueueCapacity is the amount of items the work queue can hold.
+	QueueCapacity int
+}
+
+// Saturation returns the fraction of the work queue in use, between 0 and 1.
+func (s Status) Saturation() float64 {
+	if s.QueueCapacity == 0 {
+		return 0
+	}
+
+	return float64(s.QueueLength) / float64(s.QueueCapacity)
+}
+
+// Stalled returns true if items are queued but none was processed for longer than the given timeout.
+func (s Status) Stalled(now time.Time, timeout time.Duration) bool {
+	return s.QueueLength > 0 && now.Sub(s.LastProcessed) > timeout
+}
+
+// Heartbeat records when a router last processed an item. It is safe for concurrent use.
+type Heartbeat struct {
+	last atomic.Int64
+}
+
+// Beat records the current time.
+func (h *Heartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// Last returns the time recorded last, or the zero time if none was.
+func (h *Heartbeat) Last() time.Time {
+	last := h.last.Load()
+	if last == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, last)
+}
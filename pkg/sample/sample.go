@@ -0,0 +1,133 @@
+// Package sample keeps a fraction of the events matching sampling rules, like high-volume Normal events.
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package sample
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+// Supported sampling methods.
+const (
+	// MethodHash keeps or drops events depending on a hash of their UID, so every update of the
+	// same event, like its count increasing, gets the same decision.
+	MethodHash = "hash"
+	// MethodRandom keeps or drops every event and update independently.
+	MethodRandom = "random"
+)
+
+// Rule keeps the given rate of the events with the given type and reason. Empty fields match any value.
+type Rule struct {
+	Type   string `yaml:"type,omitempty"`
+	Reason string `yaml:"reason,omitempty"`
+	// Rate is the fraction of the matching events kept, between 0 and 1.
+	Rate *float64 `yaml:"rate"`
+	// Method is either MethodHash (default) or MethodRandom.
+	Method string `yaml:"method,omitempty"`
+}
+
+// Validate checks the rule has a valid rate and method.
+func (r Rule) Validate() error {
+	var errs []error
+	switch {
+	case r.Rate == nil:
+		errs = append(errs, errors.New("rate is required"))
+	case *r.Rate < 0 || *r.Rate > 1:
+		errs = append(errs, fmt.Errorf("rate must be between 0 and 1, got %g", *r.Rate))
+	}
+
+	switch r.Method {
+	case "", MethodHash, MethodRandom:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported method %q, expected %q or %q", r.Method, MethodHash, MethodRandom))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r Rule) matches(kubeEvent *common.KubeEvent) bool {
+	return (r.Type == "" || r.Type == kubeEvent.Event.Type) &&
+		(r.Reason == "" || r.Reason == kubeEvent.Event.Reason)
+}
+
+// Sampler implements the `router.EventProcessor` interface. It keeps the events matching its rules
+// at the rate of the first matching rule, and every other event. Kept events carry the rate they were
+// kept at in their SampleRate, so counts can be re-weighted. It is safe for concurrent use.
+type Sampler struct {
+	rules  []Rule
+	random func() float64
+}
+
+// New returns a Sampler applying the given rules, in order, or an error if any of them is not valid.
+func New(rules []Rule) (*Sampler, error) {
+	var errs []error
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			for _, e := range common.UnwrapJoined(err) {
+				errs = append(errs, fmt.Errorf("sampling[%d]: %w", i, e))
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return &Sampler{rules: rules, random: rand.Float64}, nil
+}
+
+// ProcessEvent returns whether the event is kept, setting the rate it was kept at.
+func (s *Sampler) ProcessEvent(kubeEvent *common.KubeEvent) bool {
+	if kubeEvent.Event == nil {
+		return true
+	}
+
+	rate := 1.0
+	method := MethodHash
+	for _, rule := range s.rules {
+		if rule.matches(kubeEvent) {
+			rate = *rule.Rate
+			method = rule.Method
+			break
+		}
+	}
+
+	if !s.keep(kubeEvent, rate, method) {
+		return false
+	}
+
+	kubeEvent.SampleRate = rate
+	return true
+}
+
+func (s *Sampler) keep(kubeEvent *common.KubeEvent, rate float64, method string) bool {
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	case method == MethodRandom:
+		return s.random() < rate
+	default:
+		return hashFraction(kubeEvent) < rate
+	}
+}
+
+// hashFraction maps the UID of the event, or its name if not set, to a number between 0 and 1.
+func hashFraction(kubeEvent *common.KubeEvent) float64 {
+	key := string(kubeEvent.Event.UID)
+	if key == "" {
+		key = kubeEvent.Event.Namespace + "/" + kubeEvent.Event.Name
+	}
+
+	// SHA-256 spreads similar keys, like consecutive UIDs, evenly, unlike faster hashes like FNV.
+	sum := sha256.Sum256([]byte(key))
+	return float64(binary.BigEndian.Uint64(sum[:8])) / math.MaxUint64
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package sample
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+func rate(r float64) *float64 {
+	return &r
+}
+
+func event(uid, eventType, reason string) common.KubeEvent {
+	return common.KubeEvent{
+		Verb: "ADDED",
+		Event: &v1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod." + uid, Namespace: "shop", UID: types.UID(uid)},
+			Type:       eventType,
+			Reason:     reason,
+		},
+	}
+}
+
+// kept returns how many of n different events with the given type and reason are kept.
+func kept(s *Sampler, n int, eventType, reason string) int {
+	count := 0
+	for i := 0; i < n; i++ {
+		e := event(fmt.Sprintf("uid-%d", i), eventType, reason)
+		if s.ProcessEvent(&e) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func TestSampler_Rules(t *testing.T) {
+	s, err := New([]Rule{
+		{Type: "Warning", Rate: rate(1)},
+		{Type: "Normal", Reason: "Pulled", Rate: rate(0.1)},
+		{Reason: "Scheduled", Rate: rate(0)},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1000, kept(s, 1000, "Warning", "Pulled"), "the first matching rule is used")
+	assert.InDelta(t, 100, kept(s, 1000, "Normal", "Pulled"), 30)
+	assert.Equal(t, 0, kept(s, 1000, "Normal", "Scheduled"))
+	assert.Equal(t, 1000, kept(s, 1000, "Normal", "Started"), "events matching no rule are kept")
+
+	pulled, started := event("a", "Normal", "Pulled"), event("b", "Normal", "Started")
+	for !s.ProcessEvent(&pulled) {
+		pulled.Event.UID += "a"
+	}
+	assert.Equal(t, 0.1, pulled.SampleRate)
+	require.True(t, s.ProcessEvent(&started))
+	assert.Equal(t, 1.0, started.SampleRate)
+
+	attrs, err := common.FlattenStruct(pulled)
+	require.NoError(t, err)
+	assert.Equal(t, 0.1, attrs["sampleRate"])
+}
+
+func TestSampler_HashIsDeterministic(t *testing.T) {
+	s, err := New([]Rule{{Reason: "Pulled", Rate: rate(0.5)}})
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		e := event(fmt.Sprintf("uid-%d", i), "Normal", "Pulled")
+		first := s.ProcessEvent(&e)
+
+		update := e
+		update.Verb = "UPDATE"
+		update.Event = e.Event.DeepCopy()
+		update.Event.Count = 5
+		assert.Equal(t, first, s.ProcessEvent(&update), "updates of the same event get the same decision")
+	}
+}
+
+func TestSampler_Random(t *testing.T) {
+	s, err := New([]Rule{{Reason: "Pulled", Rate: rate(0.25), Method: MethodRandom}})
+	require.NoError(t, err)
+
+	draws := []float64{0.1, 0.3, 0.2, 0.9}
+	s.random = func() float64 {
+		d := draws[0]
+		draws = draws[1:]
+		return d
+	}
+
+	e := event("same", "Normal", "Pulled")
+	var decisions []bool
+	for i := 0; i < 4; i++ {
+		decisions = append(decisions, s.ProcessEvent(&e))
+	}
+	assert.Equal(t, []bool{true, false, true, false}, decisions, "every event is sampled independently")
+}
+
+func TestNew_InvalidRules(t *testing.T) {
+	_, err := New([]Rule{
+		{Type: "Normal", Rate: rate(0.5)},
+		{Type: "Normal", Rate: rate(-0.5), Method: "reservoir"},
+		{Reason: "Pulled"},
+	})
+	assert.EqualError(t, err, "sampling[1]: rate must be between 0 and 1, got -0.5\n"+
+		`sampling[1]: unsupported method "reservoir", expected "hash" or "random"`+"\n"+
+		"sampling[2]: rate is required")
+}
@@ -0,0 +1,191 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package sinks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+// Defaulter is implemented by sink configurations that set default values after being decoded.
+type Defaulter interface {
+	Default()
+}
+
+// Validator is implemented by sink configurations that validate their values after defaults are set.
+type Validator interface {
+	Validate() error
+}
+
+// UnmarshalYAML keeps the config node as is, so each sink can decode it into its own type.
+func (s *SinkConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.MappingNode {
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: sink must be a mapping", value.Line)}}
+	}
+
+	var errs []string
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		key, val := value.Content[i], value.Content[i+1]
+		switch key.Value {
+		case "name":
+			if err := val.Decode(&s.Name); err != nil {
+				return err
+			}
+		case "type":
+			if err := val.Decode(&s.Type); err != nil {
+				return err
+			}
+		case "id":
+			if err := val.Decode(&s.ID); err != nil {
+				return err
+			}
+		case "config":
+			switch val.Kind {
+			case yaml.MappingNode:
+				s.raw = val
+				s.Config = scalarValues(val)
+			case yaml.ScalarNode:
+				if val.Tag != "!!null" {
+					errs = append(errs, fmt.Sprintf("line %d: sink config must be a mapping", val.Line))
+				}
+			default:
+				errs = append(errs, fmt.Sprintf("line %d: sink config must be a mapping", val.Line))
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("line %d: field %s not found in type sinks.SinkConfig", key.Line, key.Value))
+		}
+	}
+
+	if len(errs) > 0 {
+		return &yaml.TypeError{Errors: errs}
+	}
+
+	return nil
+}
+
+// scalarValues returns the top level scalar values of a mapping node.
+func scalarValues(node *yaml.Node) map[string]string {
+	values := make(map[string]string)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if val := node.Content[i+1]; val.Kind == yaml.ScalarNode {
+			values[node.Content[i].Value] = val.Value
+		}
+	}
+
+	return values
+}
+
+// flatNode builds a mapping node out of a flat configuration. Values are left untagged,
+// so they are resolved to the type of the field they are decoded into.
+func flatNode(config map[string]string) *yaml.Node {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range keys {
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: config[key]},
+		)
+	}
+
+	return node
+}
+
+// ConfigNode returns a copy of the configuration of the sink as a mapping node, e.g. to display it.
+func (s SinkConfig) ConfigNode() *yaml.Node {
+	if s.raw == nil {
+		return flatNode(s.Config)
+	}
+
+	return copyNode(s.raw)
+}
+
+func copyNode(node *yaml.Node) *yaml.Node {
+	copied := *node
+	copied.Content = make([]*yaml.Node, 0, len(node.Content))
+	for _, child := range node.Content {
+		copied.Content = append(copied.Content, copyNode(child))
+	}
+
+	return &copied
+}
+
+// Decode decodes the configuration of the sink into out, rejecting unknown fields.
+// Afterwards, defaults are set and validated if out implements Defaulter and Validator.
+// Errors found while decoding are prefixed with the line of the configuration file they were found at,
+// and fields that could be decoded are validated anyway, so all the errors are returned at once.
+func (s SinkConfig) Decode(out interface{}) error {
+	node := s.raw
+	if node == nil {
+		node = flatNode(s.Config)
+	}
+
+	// Decoding a node directly does not support rejecting unknown fields, so it is encoded back first.
+	contents, err := yaml.Marshal(node)
+	if err != nil {
+		return fmt.Errorf("could not encode sink config: %w", err)
+	}
+
+	var decodeErr error
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return fmt.Errorf("could not decode sink config: %w", err)
+		}
+		decodeErr = relocateErrors(typeErr, node.Line)
+	}
+
+	if d, ok := out.(Defaulter); ok {
+		d.Default()
+	}
+
+	if v, ok := out.(Validator); ok {
+		return joinFlat(decodeErr, v.Validate())
+	}
+
+	return decodeErr
+}
+
+// relocateErrors turns the line numbers of the decoding errors, relative to the encoded node,
+// into lines of the configuration file. They are removed if the node does not come from a file.
+func relocateErrors(typeErr *yaml.TypeError, firstLine int) error {
+	errs := make([]error, 0, len(typeErr.Errors))
+	for _, msg := range typeErr.Errors {
+		var line int
+		if _, scanErr := fmt.Sscanf(msg, "line %d:", &line); scanErr == nil {
+			msg = strings.TrimSpace(strings.SplitN(msg, ":", 2)[1])
+			if firstLine > 0 {
+				msg = fmt.Sprintf("line %d: %s", line+firstLine-1, msg)
+			}
+		}
+
+		errs = append(errs, errors.New(msg))
+	}
+
+	return errors.Join(errs...)
+}
+
+// joinFlat joins the errors like errors.Join, but keeping the errors of joined errors
+// at the top level, so they can be reported one by one.
+func joinFlat(errs ...error) error {
+	var flat []error
+	for _, err := range errs {
+		flat = append(flat, common.UnwrapJoined(err)...)
+	}
+
+	return errors.Join(flat...)
+}
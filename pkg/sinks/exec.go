@@ -0,0 +1,395 @@
+// Package sinks ...
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package sinks
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+func init() {
+	register("exec", createExecSink, validateExecConfig)
+}
+
+const (
+	defaultExecAckTimeout     = 10 * time.Second
+	defaultExecRestartBackoff = 5 * time.Second
+	// execMaxAckSize is the longest acknowledgement line read from the program.
+	execMaxAckSize = 64 * 1024
+
+	execMessageEvent  = "event"
+	execMessageObject = "object"
+)
+
+// execConfig is the configuration of the exec sink.
+type execConfig struct {
+	// Command is the program to run and its arguments.
+	Command []string `yaml:"command"`
+	// Env holds environment variables set for the program, besides the ones of nri-kube-events.
+	Env map[string]string `yaml:"env"`
+	// AckTimeout is how long an item waits to be acknowledged by the program before failing.
+	AckTimeout time.Duration `yaml:"ackTimeout"`
+	// RestartBackoff is the shortest time between two starts of the program, if it exits.
+	RestartBackoff time.Duration `yaml:"restartBackoff"`
+}
+
+func (c *execConfig) Default() {
+	if c.AckTimeout == 0 {
+		c.AckTimeout = defaultExecAckTimeout
+	}
+
+	if c.RestartBackoff == 0 {
+		c.RestartBackoff = defaultExecRestartBackoff
+	}
+}
+
+func (c *execConfig) Validate() error {
+	var errs []error
+
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		errs = append(errs, errors.New("command is required"))
+	}
+
+	if c.AckTimeout < 0 {
+		errs = append(errs, fmt.Errorf("ackTimeout must be positive, got %s", c.AckTimeout))
+	}
+
+	if c.RestartBackoff < 0 {
+		errs = append(errs, fmt.Errorf("restartBackoff must be positive, got %s", c.RestartBackoff))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateExecConfig(config SinkConfig) error {
+	return config.Decode(&execConfig{})
+}
+
+func createExecSink(config SinkConfig, _ string, _ prometheus.Registerer) (Sink, error) {
+	var c execConfig
+	if err := config.Decode(&c); err != nil {
+		return nil, err
+	}
+
+	return &execSink{
+		config:  c,
+		log:     logrus.WithField("sink", config.SinkID()),
+		pending: make(map[uint64]chan error),
+	}, nil
+}
+
+// execMessage is a line written to the standard input of the program, holding either an event or an object.
+type execMessage struct {
+	ID   uint64 `json:"id"`
+	Type string `json:"type"`
+	// Kind is the kind of the object, which objects read from informers lack.
+	Kind   string             `json:"kind,omitempty"`
+	Event  *common.KubeEvent  `json:"event,omitempty"`
+	Object *common.KubeObject `json:"object,omitempty"`
+}
+
+// execAck is a line read from the standard output of the program, acknowledging the message
+// with the same ID. A non-empty error means the program failed to handle it.
+type execAck struct {
+	ID    uint64 `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// execSink sends events and objects to an external program, as a JSON message per line written
+// to its standard input, and waits for the program to acknowledge each of them on its standard output.
+// The program is started again if it exits, no sooner than the restart backoff after the last start.
+//
+// The sink is safe for concurrent use: messages written by different goroutines are acknowledged
+// independently, by ID.
+type execSink struct {
+	config execConfig
+	log    *logrus.Entry
+
+	// writeMtx serializes the messages written to the program.
+	writeMtx sync.Mutex
+
+	// mtx guards the running program, the messages waiting for an acknowledgement and the health.
+	mtx       sync.Mutex
+	proc      *execProcess
+	lastStart time.Time
+	closed    bool
+	nextID    uint64
+	pending   map[uint64]chan error
+	// err is the error of the last message sent, reported by Health.
+	err error
+}
+
+// execProcess is a started instance of the program.
+type execProcess struct {
+	cmd   *exec.Cmd
+	stdin *os.File
+	// exited is closed once the program has exited, err holding the reason.
+	exited chan struct{}
+	err    error
+}
+
+// Start starts the program, failing if it can't be run.
+func (s *execSink) Start(_ context.Context) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	_, err := s.startLocked()
+	return err
+}
+
+func (s *execSink) HandleEvent(kubeEvent common.KubeEvent) error {
+	return s.send(execMessage{Type: execMessageEvent, Event: &kubeEvent})
+}
+
+func (s *execSink) HandleObject(kubeObject common.KubeObject) error {
+	msg := execMessage{Type: execMessageObject, Object: &kubeObject}
+	if kubeObject.Obj != nil {
+		msg.Kind = common.K8SObjGetGVK(kubeObject.Obj).Kind
+	}
+
+	return s.send(msg)
+}
+
+// send writes the message to the program and waits for its acknowledgement.
+func (s *execSink) send(msg execMessage) error {
+	s.mtx.Lock()
+	proc, err := s.runningLocked()
+	if err != nil {
+		s.err = err
+		s.mtx.Unlock()
+		return err
+	}
+
+	s.nextID++
+	msg.ID = s.nextID
+	acked := make(chan error, 1)
+	s.pending[msg.ID] = acked
+	s.mtx.Unlock()
+
+	err = s.write(proc, msg)
+	if err == nil {
+		timeout := time.NewTimer(s.config.AckTimeout)
+		defer timeout.Stop()
+
+		select {
+		case err = <-acked:
+		case <-proc.exited:
+			err = fmt.Errorf("program exited before acknowledging message %d: %w", msg.ID, proc.err)
+		case <-timeout.C:
+			err = fmt.Errorf("message %d not acknowledged after %s", msg.ID, s.config.AckTimeout)
+		}
+	}
+
+	s.mtx.Lock()
+	delete(s.pending, msg.ID)
+	s.err = err
+	s.mtx.Unlock()
+
+	return err
+}
+
+func (s *execSink) write(proc *execProcess, msg execMessage) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("could not marshal message: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.writeMtx.Lock()
+	defer s.writeMtx.Unlock()
+
+	// A program not reading its input would otherwise block the routers once the pipe is full.
+	if err := proc.stdin.SetWriteDeadline(time.Now().Add(s.config.AckTimeout)); err != nil {
+		return fmt.Errorf("could not write message to program: %w", err)
+	}
+	if _, err := proc.stdin.Write(line); err != nil {
+		return fmt.Errorf("could not write message to program: %w", err)
+	}
+
+	return nil
+}
+
+// runningLocked returns the running program, starting it again if it exited and the restart
+// backoff has elapsed. It must be called with mtx held.
+func (s *execSink) runningLocked() (*execProcess, error) {
+	if s.closed {
+		return nil, errors.New("sink is closed")
+	}
+
+	if s.proc != nil {
+		select {
+		case <-s.proc.exited:
+		default:
+			return s.proc, nil
+		}
+
+		if wait := s.config.RestartBackoff - time.Since(s.lastStart); wait > 0 {
+			return nil, fmt.Errorf("program exited (%v), restarting in %s", s.proc.err, wait.Round(time.Millisecond))
+		}
+	}
+
+	return s.startLocked()
+}
+
+// startLocked starts the program. It must be called with mtx held.
+func (s *execSink) startLocked() (*execProcess, error) {
+	s.lastStart = time.Now()
+
+	cmd := exec.Command(s.config.Command[0], s.config.Command[1:]...)
+	cmd.Env = os.Environ()
+	for name, value := range s.config.Env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+
+	stdin, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not create pipe to program: %w", err)
+	}
+	cmd.Stdin = stdin
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		_ = stdin.Close()
+		_ = stdinWriter.Close()
+		return nil, fmt.Errorf("could not create pipe from program: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		_ = stdin.Close()
+		_ = stdinWriter.Close()
+		return nil, fmt.Errorf("could not create pipe from program: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		_ = stdin.Close()
+		_ = stdinWriter.Close()
+		return nil, fmt.Errorf("could not start program %s: %w", s.config.Command[0], err)
+	}
+	// The program holds its own copy of the read end.
+	_ = stdin.Close()
+
+	proc := &execProcess{cmd: cmd, stdin: stdinWriter, exited: make(chan struct{})}
+	s.proc = proc
+	s.log.Infof("Started program %s, pid %d", s.config.Command[0], cmd.Process.Pid)
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		s.readAcks(stdout)
+	}()
+	go func() {
+		defer readers.Done()
+		s.logOutput(stderr)
+	}()
+	go func() {
+		// Wait closes the pipes, so it must be called once they have been read.
+		readers.Wait()
+		proc.err = cmd.Wait()
+		if proc.err == nil {
+			proc.err = errors.New("exit status 0")
+		}
+		_ = proc.stdin.Close()
+		close(proc.exited)
+		s.log.Warnf("Program %s exited: %v", s.config.Command[0], proc.err)
+	}()
+
+	return proc, nil
+}
+
+// readAcks delivers the acknowledgements written by the program to the messages waiting for them.
+func (s *execSink) readAcks(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 4096), execMaxAckSize)
+	for scanner.Scan() {
+		var ack execAck
+		if err := json.Unmarshal(scanner.Bytes(), &ack); err != nil {
+			s.log.Warnf("Ignoring invalid acknowledgement %q: %v", scanner.Text(), err)
+			continue
+		}
+
+		s.mtx.Lock()
+		acked, ok := s.pending[ack.ID]
+		s.mtx.Unlock()
+		if !ok {
+			s.log.Debugf("Ignoring acknowledgement of unknown message %d", ack.ID)
+			continue
+		}
+
+		if ack.Error != "" {
+			acked <- fmt.Errorf("program failed to handle message %d: %s", ack.ID, ack.Error)
+		} else {
+			acked <- nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		s.log.Warnf("Could not read acknowledgements: %v", err)
+		// Keep draining, so the program does not block writing to a full pipe.
+		_, _ = io.Copy(io.Discard, stdout)
+	}
+}
+
+// logOutput logs every line written by the program to its standard error.
+func (s *execSink) logOutput(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		s.log.Info(scanner.Text())
+	}
+	_, _ = io.Copy(io.Discard, stderr)
+}
+
+// Health returns the error of the last message sent, if it failed.
+func (s *execSink) Health() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.err
+}
+
+// Close closes the standard input of the program, which is expected to exit once it has read all the
+// messages. It is killed if it does not exit within the acknowledgement timeout.
+func (s *execSink) Close() error {
+	s.mtx.Lock()
+	s.closed = true
+	proc := s.proc
+	s.mtx.Unlock()
+
+	if proc == nil {
+		return nil
+	}
+
+	s.writeMtx.Lock()
+	_ = proc.stdin.Close()
+	s.writeMtx.Unlock()
+
+	timeout := time.NewTimer(s.config.AckTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-proc.exited:
+		return nil
+	case <-timeout.C:
+	}
+
+	if err := proc.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("could not kill program: %w", err)
+	}
+	<-proc.exited
+
+	return fmt.Errorf("program did not exit within %s and was killed", s.config.AckTimeout)
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package sinks
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+// TestExecSinkHelperProcess is the program run by the exec sink in these tests, not a test itself.
+// It acknowledges every message, except events with reason Fail, which are failed, Silent, which are
+// not acknowledged, and Exit, which makes it exit. Objects are failed unless they are Pods.
+func TestExecSinkHelperProcess(t *testing.T) {
+	if os.Getenv("EXEC_SINK_HELPER") != "1" {
+		return
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var msg struct {
+			ID    uint64 `json:"id"`
+			Type  string `json:"type"`
+			Kind  string `json:"kind"`
+			Event struct {
+				Event v1.Event `json:"event"`
+			} `json:"event"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid message: %v\n", err)
+			os.Exit(2)
+		}
+
+		ack := execAck{ID: msg.ID}
+		switch {
+		case msg.Type == execMessageObject && msg.Kind != "Pod":
+			ack.Error = "unexpected kind " + msg.Kind
+		case msg.Type == execMessageEvent && msg.Event.Event.Reason == "Fail":
+			ack.Error = "failed on purpose"
+		case msg.Type == execMessageEvent && msg.Event.Event.Reason == "Silent":
+			continue
+		case msg.Type == execMessageEvent && msg.Event.Event.Reason == "Exit":
+			os.Exit(3)
+		}
+
+		line, _ := json.Marshal(ack)
+		fmt.Println(string(line))
+	}
+
+	os.Exit(0)
+}
+
+func createExecTestSink(t *testing.T, config string) *execSink {
+	t.Helper()
+
+	sinkConfig := decodeSinkConfig(t, `
+name: exec
+config:
+  command: [`+os.Args[0]+`, -test.run=TestExecSinkHelperProcess]
+  env:
+    EXEC_SINK_HELPER: "1"
+`+config)
+
+	sink, err := createExecSink(sinkConfig, "0.0.0", prometheus.NewRegistry())
+	require.NoError(t, err)
+	require.NoError(t, sink.(Starter).Start(context.Background()))
+	t.Cleanup(func() { _ = sink.(Closer).Close() })
+
+	return sink.(*execSink)
+}
+
+func execTestEvent(reason string) common.KubeEvent {
+	return common.KubeEvent{
+		Verb:  "ADDED",
+		Event: &v1.Event{ObjectMeta: metav1.ObjectMeta{Name: "event"}, Reason: reason},
+	}
+}
+
+func TestExecSink_Acknowledgements(t *testing.T) {
+	sink := createExecTestSink(t, "")
+
+	assert.NoError(t, sink.HandleEvent(execTestEvent("Started")))
+	assert.NoError(t, sink.HandleObject(common.KubeObject{Verb: "ADDED", Obj: &v1.Pod{}}))
+	assert.NoError(t, sink.Health())
+
+	err := sink.HandleEvent(execTestEvent("Fail"))
+	assert.EqualError(t, err, "program failed to handle message 3: failed on purpose")
+	assert.Equal(t, err, sink.Health())
+
+	assert.EqualError(t, sink.HandleObject(common.KubeObject{Verb: "ADDED", Obj: &v1.Node{}}),
+		"program failed to handle message 4: unexpected kind Node")
+}
+
+func TestExecSink_AckTimeout(t *testing.T) {
+	sink := createExecTestSink(t, "  ackTimeout: 100ms\n")
+
+	assert.EqualError(t, sink.HandleEvent(execTestEvent("Silent")), "message 1 not acknowledged after 100ms")
+	assert.NoError(t, sink.HandleEvent(execTestEvent("Started")), "late acknowledgements are ignored")
+}
+
+func TestExecSink_Restart(t *testing.T) {
+	sink := createExecTestSink(t, "  restartBackoff: 500ms\n")
+
+	err := sink.HandleEvent(execTestEvent("Exit"))
+	assert.ErrorContains(t, err, "program exited before acknowledging message 1: exit status 3")
+
+	err = sink.HandleEvent(execTestEvent("Started"))
+	assert.ErrorContains(t, err, "program exited (exit status 3), restarting in")
+
+	assert.Eventually(t, func() bool { return sink.HandleEvent(execTestEvent("Started")) == nil },
+		5*time.Second, 50*time.Millisecond, "program is restarted after the backoff")
+	assert.NoError(t, sink.Health())
+}
+
+func TestExecSink_Close(t *testing.T) {
+	sink := createExecTestSink(t, "")
+	require.NoError(t, sink.HandleEvent(execTestEvent("Started")))
+
+	assert.NoError(t, sink.Close())
+	assert.EqualError(t, sink.HandleEvent(execTestEvent("Started")), "sink is closed")
+}
+
+func TestCreateExecSink_InvalidConfig(t *testing.T) {
+	config := decodeSinkConfig(t, `
+name: exec
+config:
+  ackTimeout: -1s
+`)
+
+	_, err := createExecSink(config, "0.0.0", prometheus.NewRegistry())
+	assert.EqualError(t, err, "command is required\nackTimeout must be positive, got -1s")
+}
+
+func TestCreateExecSink_StartError(t *testing.T) {
+	config := decodeSinkConfig(t, `
+name: exec
+config:
+  command: [/nonexistent/sink]
+`)
+
+	sink, err := createExecSink(config, "0.0.0", prometheus.NewRegistry())
+	require.NoError(t, err)
+	assert.ErrorContains(t, sink.(Starter).Start(context.Background()), "could not start program /nonexistent/sink")
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Starter is implemented by sinks running background work, like sending batches or keeping
+// connections. Start must not block, and the context is cancelled once the sink has been closed.
+type Starter interface {
+	Start(ctx context.Context) error
+}
+
+// Flusher is implemented by sinks that buffer items, so they are sent before the sink is discarded.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Closer is implemented by sinks holding resources that must be released when the sink is discarded.
+type Closer interface {
+	Close() error
+}
+
+// HealthChecker is implemented by sinks that can tell whether they are able to deliver items.
+type HealthChecker interface {
+	Health() error
+}
+
+// Lifecycle is the extended interface of sinks that own background goroutines, buffers or connections.
+// Sinks can implement any subset of it; the missing methods are not called.
+//
+// Sinks are started once created, and then receive items until they are discarded, either on
+// shutdown or because the configuration was reloaded. Discarded sinks are flushed and then closed.
+type Lifecycle interface {
+	Sink
+	Starter
+	Flusher
+	Closer
+	HealthChecker
+}
+
+// Start starts the given sinks implementing Starter. If any of them fails, all of them are
+// closed, since they won't be used, and the error is returned.
+func Start(ctx context.Context, sinks map[string]Sink) error {
+	for id, sink := range sinks {
+		if s, ok := sink.(Starter); ok {
+			if err := s.Start(ctx); err != nil {
+				closeSinks(sinks)
+				return fmt.Errorf("could not start sink %s: %w", id, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Health returns the errors reported by the given sinks implementing HealthChecker, by sink ID.
+// Healthy sinks are not included.
+func Health(sinks map[string]Sink) map[string]error {
+	unhealthy := make(map[string]error)
+	for id, sink := range sinks {
+		if h, ok := sink.(HealthChecker); ok {
+			if err := h.Health(); err != nil {
+				unhealthy[id] = err
+			}
+		}
+	}
+
+	return unhealthy
+}
+
+// Shutdown flushes and then closes the given sinks, if they implement Flusher and Closer.
+// Sinks are shut down concurrently, and all the errors found are returned.
+func Shutdown(ctx context.Context, sinks map[string]Sink) error {
+	var mtx sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+
+	for id, sink := range sinks {
+		wg.Add(1)
+		go func(id string, sink Sink) {
+			defer wg.Done()
+
+			var err error
+			if f, ok := sink.(Flusher); ok {
+				if flushErr := f.Flush(ctx); flushErr != nil {
+					err = fmt.Errorf("could not flush sink %s: %w", id, flushErr)
+				}
+			}
+
+			if c, ok := sink.(Closer); ok {
+				if closeErr := c.Close(); closeErr != nil {
+					err = errors.Join(err, fmt.Errorf("could not close sink %s: %w", id, closeErr))
+				}
+			}
+
+			if err != nil {
+				mtx.Lock()
+				errs = append(errs, err)
+				mtx.Unlock()
+			}
+		}(id, sink)
+	}
+
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// closeSinks closes the given sinks without flushing them, e.g. when they never received items.
+func closeSinks(sinks map[string]Sink) {
+	for _, sink := range sinks {
+		if c, ok := sink.(Closer); ok {
+			_ = c.Close()
+		}
+	}
+}
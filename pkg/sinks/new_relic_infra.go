@@ -7,11 +7,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	sdkArgs "github.com/newrelic/infra-integrations-sdk/args"
@@ -19,16 +22,17 @@ import (
 	sdkEvent "github.com/newrelic/infra-integrations-sdk/data/event"
 	sdkIntegration "github.com/newrelic/infra-integrations-sdk/integration"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sethgrid/pester"
 	"github.com/sirupsen/logrus"
 	"k8s.io/kubectl/pkg/describe"
 
 	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/diff"
+	"github.com/newrelic/nri-kube-events/pkg/metrics"
 )
 
 func init() {
-	register("newRelicInfra", createNewRelicInfraSink)
+	register("newRelicInfra", createNewRelicInfraSink, validateNewRelicInfraConfig)
 }
 
 const (
@@ -36,39 +40,125 @@ const (
 	newRelicCategory        = "kubernetes"
 	newRelicSDKName         = "kube_events"
 	defaultAgentHTTPTimeout = time.Second * 10
+	defaultBatchMaxEvents   = 1
+	defaultBatchMaxBytes    = 1 << 20
+	defaultBatchMaxLatency  = time.Second
+	// defaultSendErrTTL bounds how long a failed send is reported by Health. A quiet cluster may
+	// not send anything after a transient agent error, so the error would otherwise never clear.
+	defaultSendErrTTL = 5 * time.Minute
 
 	bucketStart  = 1 << 11
 	bucketFactor = 2
 	bucketCount  = 6
+
+	// Reasons a batch is sent for, named after the setting that triggered it.
+	flushReasonMaxEvents  = "maxEvents"
+	flushReasonMaxBytes   = "maxBytes"
+	flushReasonMaxLatency = "maxLatency"
+	flushReasonFlush      = "flush"
 )
 
-func createNewRelicInfraSink(config SinkConfig, integrationVersion string) (Sink, error) {
-	clusterName := config.MustGetString("clusterName")
-	agentEndpoint := config.MustGetString("agentEndpoint")
-	agentHTTPTimeout := config.GetDurationOr("agentHTTPTimeout", defaultAgentHTTPTimeout)
+// newRelicInfraConfig is the configuration of the newRelicInfra sink.
+type newRelicInfraConfig struct {
+	ClusterName      string        `yaml:"clusterName"`
+	AgentEndpoint    string        `yaml:"agentEndpoint"`
+	AgentHTTPTimeout time.Duration `yaml:"agentHTTPTimeout"`
+	Batch            batchConfig   `yaml:"batch"`
+}
+
+// batchConfig sets when the events accumulated by the sink are sent to the agent in a single payload.
+// A batch is sent as soon as any of the limits is reached.
+type batchConfig struct {
+	// MaxEvents is the amount of events sent in a payload. The default of 1 sends every event right away.
+	MaxEvents int `yaml:"maxEvents"`
+	// MaxBytes is the approximate size of the events sent in a payload.
+	MaxBytes int `yaml:"maxBytes"`
+	// MaxLatency is the longest time an event waits in a batch before being sent.
+	MaxLatency time.Duration `yaml:"maxLatency"`
+}
+
+func (c *newRelicInfraConfig) Default() {
+	if c.AgentHTTPTimeout == 0 {
+		c.AgentHTTPTimeout = defaultAgentHTTPTimeout
+	}
+
+	if c.Batch.MaxEvents == 0 {
+		c.Batch.MaxEvents = defaultBatchMaxEvents
+	}
+
+	if c.Batch.MaxBytes == 0 {
+		c.Batch.MaxBytes = defaultBatchMaxBytes
+	}
+
+	if c.Batch.MaxLatency == 0 {
+		c.Batch.MaxLatency = defaultBatchMaxLatency
+	}
+}
+
+func (c *newRelicInfraConfig) Validate() error {
+	var errs []error
+
+	if c.ClusterName == "" {
+		errs = append(errs, errors.New("clusterName is required"))
+	}
+
+	if c.AgentEndpoint == "" {
+		errs = append(errs, errors.New("agentEndpoint is required"))
+	} else if u, err := url.Parse(c.AgentEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("agentEndpoint %q is not a valid http(s) URL", c.AgentEndpoint))
+	}
 
-	args := struct {
-		sdkArgs.DefaultArgumentList
-		ClusterName string `help:"Identifier of your cluster. You could use it later to filter data in your New Relic account"`
-	}{
-		ClusterName: clusterName,
+	if c.AgentHTTPTimeout < 0 {
+		errs = append(errs, fmt.Errorf("agentHTTPTimeout must be positive, got %s", c.AgentHTTPTimeout))
 	}
 
-	i, err := sdkIntegration.New(newRelicSDKName, integrationVersion, sdkIntegration.Args(&args))
+	if c.Batch.MaxEvents < 0 {
+		errs = append(errs, fmt.Errorf("batch.maxEvents must be positive, got %d", c.Batch.MaxEvents))
+	}
+
+	if c.Batch.MaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("batch.maxBytes must be positive, got %d", c.Batch.MaxBytes))
+	}
+
+	if c.Batch.MaxLatency < 0 {
+		errs = append(errs, fmt.Errorf("batch.maxLatency must be positive, got %s", c.Batch.MaxLatency))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateNewRelicInfraConfig(config SinkConfig) error {
+	return config.Decode(&newRelicInfraConfig{})
+}
+
+func createNewRelicInfraSink(config SinkConfig, integrationVersion string, reg prometheus.Registerer) (Sink, error) {
+	var c newRelicInfraConfig
+	if err := config.Decode(&c); err != nil {
+		return nil, err
+	}
+
+	metricVecs, err := createNewRelicInfraSinkMetrics(reg)
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := newSDKIntegration(c.ClusterName, integrationVersion)
 	if err != nil {
-		return nil, fmt.Errorf("error while initializing New Relic SDK integration: %w", err)
+		return nil, err
 	}
 
-	logrus.Debugf("NewRelic sink configuration: agentTimeout=%s, clusterName=%s, agentEndpoint=%s",
-		agentHTTPTimeout,
-		clusterName,
-		agentEndpoint,
+	log := logrus.WithField("sink", config.SinkID())
+	log.Debugf("NewRelic sink configuration: agentTimeout=%s, clusterName=%s, agentEndpoint=%s, batch=%+v",
+		c.AgentHTTPTimeout,
+		c.ClusterName,
+		c.AgentEndpoint,
+		c.Batch,
 	)
 
 	p := pester.New()
 	p.Backoff = pester.ExponentialBackoff
 	p.LogHook = func(e pester.ErrEntry) {
-		logrus.Debugf("Pester HTTP error: %#v", e)
+		log.Debugf("Pester HTTP error: %#v", e)
 	}
 	// 32 is semi-randomly chosen. It should be high enough not to block events coming from the k8s API,
 	// but not too high, because the number is directly related to the amount of goroutines that are running.
@@ -76,65 +166,176 @@ func createNewRelicInfraSink(config SinkConfig, integrationVersion string) (Sink
 	p.MaxRetries = 3
 
 	return &newRelicInfraSink{
-		pesterClient:   p,
-		clusterName:    clusterName,
-		sdkIntegration: i,
-		agentEndpoint:  agentEndpoint,
-		metrics:        createNewRelicInfraSinkMetrics(),
+		pesterClient:       p,
+		clusterName:        c.ClusterName,
+		sdkIntegration:     i,
+		agentEndpoint:      c.AgentEndpoint,
+		integrationName:    i.Name,
+		integrationVersion: i.IntegrationVersion,
+		batch:              c.Batch,
+		metrics:            metricVecs.forSink(config.SinkID()),
+		log:                log,
+		sendErrTTL:         defaultSendErrTTL,
 	}, nil
 }
 
-func createNewRelicInfraSinkMetrics() newRelicInfraSinkMetrics {
+var (
+	sdkIntegrationOnce sync.Once
+	sdkIntegrationBase *sdkIntegration.Integration
+	sdkIntegrationErr  error
+)
+
+// newSDKIntegration returns an empty SDK integration.
+// The SDK registers its arguments as global flags when an integration is created, which panics
+// if done twice. The integration is therefore created once, and copies of it are returned so
+// sinks can be created again, e.g. when the configuration is reloaded.
+func newSDKIntegration(clusterName, integrationVersion string) (*sdkIntegration.Integration, error) {
+	sdkIntegrationOnce.Do(func() {
+		args := struct {
+			sdkArgs.DefaultArgumentList
+			ClusterName string `help:"Identifier of your cluster. You could use it later to filter data in your New Relic account"`
+		}{
+			ClusterName: clusterName,
+		}
+
+		sdkIntegrationBase, sdkIntegrationErr = sdkIntegration.New(newRelicSDKName, integrationVersion, sdkIntegration.Args(&args))
+	})
+
+	if sdkIntegrationErr != nil {
+		return nil, fmt.Errorf("error while initializing New Relic SDK integration: %w", sdkIntegrationErr)
+	}
+
+	i := *sdkIntegrationBase
+	i.IntegrationVersion = integrationVersion
+	i.Entities = []*sdkIntegration.Entity{}
+
+	return &i, nil
+}
+
+// createNewRelicInfraSinkMetrics registers the metrics of the newRelicInfra sinks. They are shared
+// by all the sinks created with the same registerer, each of them using the ones labeled with its ID.
+func createNewRelicInfraSinkMetrics(reg prometheus.Registerer) (newRelicInfraSinkMetricVecs, error) {
+	httpTotalFailures, httpTotalFailuresErr := metrics.Register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "http_sink",
+		Name:      "infra_sink_http_failures_total",
+		Help:      "Total amount of http failures connecting to the Agent",
+	}, []string{"sink"}))
+	httpResponses, httpResponsesErr := metrics.Register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "http_sink",
+		Name:      "infra_sink_http_responses_total",
+		Help:      "Total amount of http responses, per code, from the New Relic Infra Agent",
+	}, []string{"sink", "code"}))
+	descSizes, descSizesErr := metrics.Register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "k8s_descriptions",
+		Name:      "size",
+		Help:      "Sizes of the object describe output",
+		Buckets:   prometheus.ExponentialBuckets(bucketStart, bucketFactor, bucketCount),
+	}, []string{"sink", "obj_kind"}))
+	descErr, descErrErr := metrics.Register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "k8s_descriptions",
+		Name:      "err",
+		Help:      "Total errors encountered when trying to describe an object",
+	}, []string{"sink", "obj_kind"}))
+	batchEvents, batchEventsErr := metrics.Register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "http_sink",
+		Name:      "infra_sink_batch_events",
+		Help:      "Amount of events sent in each payload to the Agent",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	}, []string{"sink"}))
+	batchFlushes, batchFlushesErr := metrics.Register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "http_sink",
+		Name:      "infra_sink_batch_flushes_total",
+		Help:      "Total amount of payloads sent to the Agent, per reason the batch was sent for",
+	}, []string{"sink", "reason"}))
+
+	if err := errors.Join(httpTotalFailuresErr, httpResponsesErr, descSizesErr, descErrErr, batchEventsErr, batchFlushesErr); err != nil {
+		return newRelicInfraSinkMetricVecs{}, fmt.Errorf("could not register newRelicInfra sink metrics: %w", err)
+	}
+
+	return newRelicInfraSinkMetricVecs{
+		httpTotalFailures: httpTotalFailures,
+		httpResponses:     httpResponses,
+		descSizes:         descSizes,
+		descErr:           descErr,
+		batchEvents:       batchEvents,
+		batchFlushes:      batchFlushes,
+	}, nil
+}
+
+// newRelicInfraSinkMetricVecs holds the metrics of all the newRelicInfra sinks, labeled by sink ID.
+type newRelicInfraSinkMetricVecs struct {
+	httpTotalFailures *prometheus.CounterVec
+	httpResponses     *prometheus.CounterVec
+	descSizes         *prometheus.HistogramVec
+	descErr           *prometheus.CounterVec
+	batchEvents       *prometheus.HistogramVec
+	batchFlushes      *prometheus.CounterVec
+}
+
+// forSink returns the metrics of the sink with the given ID.
+func (m newRelicInfraSinkMetricVecs) forSink(id string) newRelicInfraSinkMetrics {
+	labels := prometheus.Labels{"sink": id}
+
 	return newRelicInfraSinkMetrics{
-		httpTotalFailures: promauto.NewCounter(prometheus.CounterOpts{
-			Namespace: "nr",
-			Subsystem: "http_sink",
-			Name:      "infra_sink_http_failures_total",
-			Help:      "Total amount of http failures connecting to the Agent",
-		}),
-		httpResponses: promauto.NewCounterVec(prometheus.CounterOpts{
-			Namespace: "nr",
-			Subsystem: "http_sink",
-			Name:      "infra_sink_http_responses_total",
-			Help:      "Total amount of http responses, per code, from the New Relic Infra Agent",
-		}, []string{"code"}),
-		descSizes: promauto.NewHistogramVec(prometheus.HistogramOpts{
-			Namespace: "nr",
-			Subsystem: "k8s_descriptions",
-			Name:      "size",
-			Help:      "Sizes of the object describe output",
-			Buckets:   prometheus.ExponentialBuckets(bucketStart, bucketFactor, bucketCount),
-		}, []string{"obj_kind"}),
-		descErr: promauto.NewCounterVec(prometheus.CounterOpts{
-			Namespace: "nr",
-			Subsystem: "k8s_descriptions",
-			Name:      "err",
-			Help:      "Total errors encountered when trying to describe an object",
-		}, []string{"obj_kind"}),
+		httpTotalFailures: m.httpTotalFailures.With(labels),
+		httpResponses:     m.httpResponses.MustCurryWith(labels),
+		descSizes:         m.descSizes.MustCurryWith(labels),
+		descErr:           m.descErr.MustCurryWith(labels),
+		batchEvents:       m.batchEvents.With(labels),
+		batchFlushes:      m.batchFlushes.MustCurryWith(labels),
 	}
 }
 
 type newRelicInfraSinkMetrics struct {
 	httpTotalFailures prometheus.Counter
 	httpResponses     *prometheus.CounterVec
-	descSizes         *prometheus.HistogramVec
+	descSizes         prometheus.ObserverVec
 	descErr           *prometheus.CounterVec
+	batchEvents       prometheus.Observer
+	batchFlushes      *prometheus.CounterVec
 }
 
 // The newRelicInfraSink implements the Sink interface.
 // It will forward all events to the locally running Relic Infrastructure Agent
+//
+// The sink is safe for concurrent use: the events and descriptions routers call it from
+// different goroutines, and batches are sent by a timer.
 type newRelicInfraSink struct {
-	pesterClient   *pester.Client
+	pesterClient       *pester.Client
+	clusterName        string
+	agentEndpoint      string
+	integrationName    string
+	integrationVersion string
+	metrics            newRelicInfraSinkMetrics
+	log                *logrus.Entry
+
+	batch batchConfig
+	// mtx guards the pending batch. sdkIntegration holds its entities, and is replaced
+	// by an empty one when the batch is taken out to be sent.
+	mtx            sync.Mutex
 	sdkIntegration *sdkIntegration.Integration
-	clusterName    string
-	agentEndpoint  string
-	metrics        newRelicInfraSinkMetrics
+	pending        int
+	pendingBytes   int
+	// generation identifies the pending batch, so a timer firing late does not send the next one.
+	generation   int
+	latencyTimer *time.Timer
+
+	// sendErr is the error of the last payload sent to the agent, reported by Health until
+	// sendErrTTL has passed since sendErrAt.
+	sendErrMtx sync.Mutex
+	sendErr    error
+	sendErrAt  time.Time
+	sendErrTTL time.Duration
 }
 
 // HandleObject sends the descriptions for the object to the New Relic Agent
 func (ns *newRelicInfraSink) HandleObject(kubeObj common.KubeObject) error {
-	defer ns.sdkIntegration.Clear()
-
 	gvk := common.K8SObjGetGVK(kubeObj.Obj)
 	objKind := gvk.Kind
 
@@ -155,16 +356,6 @@ func (ns *newRelicInfraSink) HandleObject(kubeObj common.KubeObject) error {
 		return fmt.Errorf("failed to get object namespace/name: %w", err)
 	}
 
-	e, err := ns.sdkIntegration.Entity(objName, fmt.Sprintf("k8s:%s:%s:%s", ns.clusterName, objNS, strings.ToLower(objKind)))
-	if err != nil {
-		return fmt.Errorf("failed to create entity: %w", err)
-	}
-
-	e.AddAttributes(
-		sdkAttr.Attr("clusterName", ns.clusterName),
-		sdkAttr.Attr("displayName", e.Metadata.Name),
-	)
-
 	extraAttrs := make(map[string]interface{})
 	extraAttrs["clusterName"] = ns.clusterName
 	extraAttrs["type"] = fmt.Sprintf("%s.Description", objKind)
@@ -179,32 +370,66 @@ func (ns *newRelicInfraSink) HandleObject(kubeObj common.KubeObject) error {
 		extraAttrs[key] = val
 	}
 
+	if kubeObj.OldObj != nil {
+		addChangeAttrs(kubeObj, extraAttrs)
+	}
+
+	if kubeObj.Namespace != nil {
+		addNamespaceAttrs(kubeObj.Namespace, extraAttrs)
+	}
+
 	ns.decorateAttrs(extraAttrs)
+	if kubeObj.Transform != nil {
+		kubeObj.Transform.Apply(extraAttrs)
+	}
+
+	event := sdkEvent.NewWithAttributes(summary, newRelicCategory, extraAttrs)
+
+	return ns.addEvent(event, func(batch *sdkIntegration.Integration) (*sdkIntegration.Entity, error) {
+		e, err := batch.Entity(objName, fmt.Sprintf("k8s:%s:%s:%s", ns.clusterName, objNS, strings.ToLower(objKind)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create entity: %w", err)
+		}
+
+		e.AddAttributes(
+			sdkAttr.Attr("clusterName", ns.clusterName),
+			sdkAttr.Attr("displayName", e.Metadata.Name),
+		)
 
-	err = e.AddEvent(sdkEvent.NewWithAttributes(summary, newRelicCategory, extraAttrs))
+		return e, nil
+	})
+}
+
+// addChangeAttrs attaches the fields that changed between the previous and the current
+// version of the object as `change.*` attributes.
+func addChangeAttrs(kubeObj common.KubeObject, attrs map[string]interface{}) {
+	changes, err := diff.Compute(kubeObj.OldObj, kubeObj.Obj)
 	if err != nil {
-		return fmt.Errorf("couldn't add event: %w", err)
+		logrus.Debugf("could not compute object changes: %v", err)
+		return
 	}
 
-	err = ns.sendIntegrationPayloadToAgent()
-	if err != nil {
-		return fmt.Errorf("error sending data to agent: %w", err)
+	for k, v := range changes.Attributes() {
+		attrs[k] = v
 	}
+}
 
-	return nil
+// addNamespaceAttrs attaches the namespace metadata as `namespace.labels.*` and
+// `namespace.annotations.*` attributes, matching the flattened events.
+func addNamespaceAttrs(md *common.NamespaceMetadata, attrs map[string]interface{}) {
+	for k, v := range md.Labels {
+		attrs["namespace.labels."+k] = v
+	}
+
+	for k, v := range md.Annotations {
+		attrs["namespace.annotations."+k] = v
+	}
 }
 
 // HandleEvent sends the event to the New Relic Agent
 func (ns *newRelicInfraSink) HandleEvent(kubeEvent common.KubeEvent) error {
-	defer ns.sdkIntegration.Clear()
-
 	entityType, entityName := formatEntityID(ns.clusterName, kubeEvent)
 
-	e, err := ns.sdkIntegration.Entity(entityName, entityType)
-	if err != nil {
-		return fmt.Errorf("unable to create entity: %w", err)
-	}
-
 	flattenedEvent, err := common.FlattenStruct(kubeEvent)
 
 	if err != nil {
@@ -212,6 +437,9 @@ func (ns *newRelicInfraSink) HandleEvent(kubeEvent common.KubeEvent) error {
 	}
 
 	ns.decorateAttrs(flattenedEvent)
+	if kubeEvent.Transform != nil {
+		kubeEvent.Transform.Apply(flattenedEvent)
+	}
 
 	message := strings.TrimSpace(kubeEvent.Event.Message)
 	if len(kubeEvent.Event.InvolvedObject.FieldPath) > 0 {
@@ -223,19 +451,178 @@ func (ns *newRelicInfraSink) HandleEvent(kubeEvent common.KubeEvent) error {
 		newRelicCategory,
 		flattenedEvent,
 	)
-	err = e.AddEvent(event)
+
+	return ns.addEvent(event, func(batch *sdkIntegration.Integration) (*sdkIntegration.Entity, error) {
+		e, err := batch.Entity(entityName, entityType)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create entity: %w", err)
+		}
+
+		return e, nil
+	})
+}
+
+// addEvent adds the event to the pending batch, in the entity returned by the given function,
+// and sends the batch once it is full. Errors sending the batch are returned, but the
+// event is discarded together with the rest of the batch.
+//
+// The batch is only modified with the mutex held, and sent once it has been taken out of the sink,
+// so events and descriptions can be handled concurrently while a batch is being sent.
+func (ns *newRelicInfraSink) addEvent(event *sdkEvent.Event, entity func(*sdkIntegration.Integration) (*sdkIntegration.Entity, error)) error {
+	size := eventSize(event)
+
+	ns.mtx.Lock()
+	var full []*sdkIntegration.Integration
+	if ns.pending > 0 && ns.pendingBytes+size > ns.batch.MaxBytes {
+		full = append(full, ns.takeBatch(flushReasonMaxBytes))
+	}
+
+	entities := len(ns.sdkIntegration.Entities)
+	e, err := entity(ns.sdkIntegration)
+	if err == nil {
+		err = e.AddEvent(event)
+		if err != nil {
+			err = fmt.Errorf("couldn't add event: %w", err)
+		}
+	}
+
 	if err != nil {
-		return fmt.Errorf("couldn't add event: %w", err)
+		// Don't send an entity created just for this event.
+		ns.sdkIntegration.Entities = ns.sdkIntegration.Entities[:entities]
+	} else {
+		ns.pending++
+		ns.pendingBytes += size
+
+		if ns.pending >= ns.batch.MaxEvents {
+			full = append(full, ns.takeBatch(flushReasonMaxEvents))
+		} else if ns.pending == 1 {
+			generation := ns.generation
+			ns.latencyTimer = time.AfterFunc(ns.batch.MaxLatency, func() {
+				ns.sendLateBatch(generation)
+			})
+		}
 	}
+	ns.mtx.Unlock()
 
-	err = ns.sendIntegrationPayloadToAgent()
+	errs := []error{err}
+	for _, batch := range full {
+		errs = append(errs, ns.sendBatch(context.Background(), batch))
+	}
+
+	return errors.Join(errs...)
+}
+
+// sendLateBatch sends the batch with the given generation, if it was not sent already.
+func (ns *newRelicInfraSink) sendLateBatch(generation int) {
+	ns.mtx.Lock()
+	if ns.generation != generation {
+		ns.mtx.Unlock()
+		return
+	}
+	batch := ns.takeBatch(flushReasonMaxLatency)
+	ns.mtx.Unlock()
+
+	if err := ns.sendBatch(context.Background(), batch); err != nil {
+		ns.log.Warnf("could not send batch: %v", err)
+	}
+}
+
+// takeBatch returns the pending batch, or nil if it is empty, and starts a new one.
+// It must be called with the mutex held.
+func (ns *newRelicInfraSink) takeBatch(reason string) *sdkIntegration.Integration {
+	if ns.pending == 0 {
+		return nil
+	}
+
+	ns.metrics.batchEvents.Observe(float64(ns.pending))
+	ns.metrics.batchFlushes.WithLabelValues(reason).Inc()
+
+	batch := ns.sdkIntegration
+	ns.resetBatch()
+
+	return batch
+}
+
+// resetBatch replaces the pending batch with an empty one.
+// It must be called with the mutex held.
+func (ns *newRelicInfraSink) resetBatch() {
+	next := *ns.sdkIntegration
+	next.Entities = []*sdkIntegration.Entity{}
+	ns.sdkIntegration = &next
+
+	ns.pending = 0
+	ns.pendingBytes = 0
+	ns.generation++
+	if ns.latencyTimer != nil {
+		ns.latencyTimer.Stop()
+		ns.latencyTimer = nil
+	}
+}
+
+// sendBatch sends a batch taken from the sink to the agent. Nil batches are ignored.
+func (ns *newRelicInfraSink) sendBatch(ctx context.Context, batch *sdkIntegration.Integration) error {
+	if batch == nil {
+		return nil
+	}
+
+	err := ns.sendIntegrationPayloadToAgent(ctx, batch)
 	if err != nil {
-		return fmt.Errorf("error sending data to agent: %w", err)
+		err = fmt.Errorf("error sending data to agent: %w", err)
+	}
+
+	ns.sendErrMtx.Lock()
+	ns.sendErr = err
+	ns.sendErrAt = time.Now()
+	ns.sendErrMtx.Unlock()
+
+	return err
+}
+
+// Health returns the error of the last payload sent to the agent, if it failed less than
+// sendErrTTL ago.
+func (ns *newRelicInfraSink) Health() error {
+	ns.sendErrMtx.Lock()
+	defer ns.sendErrMtx.Unlock()
+
+	if ns.sendErr == nil || time.Since(ns.sendErrAt) >= ns.sendErrTTL {
+		return nil
 	}
 
+	return ns.sendErr
+}
+
+// Flush sends the pending batch to the agent.
+func (ns *newRelicInfraSink) Flush(ctx context.Context) error {
+	ns.mtx.Lock()
+	batch := ns.takeBatch(flushReasonFlush)
+	ns.mtx.Unlock()
+
+	return ns.sendBatch(ctx, batch)
+}
+
+// Close discards the pending batch, if it was not flushed.
+func (ns *newRelicInfraSink) Close() error {
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+
+	if ns.pending > 0 {
+		ns.log.Warnf("discarding %d events not sent to the agent", ns.pending)
+	}
+	ns.resetBatch()
+
 	return nil
 }
 
+// eventSize returns the approximate size the event takes in the payload.
+func eventSize(event *sdkEvent.Event) int {
+	serialized, err := json.Marshal(event)
+	if err != nil {
+		return 0
+	}
+
+	return len(serialized)
+}
+
 // formatEntity returns an entity id information as tuple of (entityType, entityName).
 //
 // Returned values should be structured as follows:
@@ -260,14 +647,12 @@ func formatEntityID(clusterName string, kubeEvent common.KubeEvent) (string, str
 	return strings.Join(parts, ":"), kubeEvent.Event.InvolvedObject.Name
 }
 
-func (ns *newRelicInfraSink) sendIntegrationPayloadToAgent() error {
-	jsonBytes, err := json.Marshal(ns.sdkIntegration)
+func (ns *newRelicInfraSink) sendIntegrationPayloadToAgent(ctx context.Context, payload *sdkIntegration.Integration) error {
+	jsonBytes, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("unable to marshal data: %w", err)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
 	request, err := http.NewRequestWithContext(ctx, "POST", ns.agentEndpoint, bytes.NewBuffer(jsonBytes))
 	if err != nil {
 		return fmt.Errorf("unable to prepare request: %w", err)
@@ -308,8 +693,8 @@ func disposeBody(response *http.Response) {
 }
 
 func (ns *newRelicInfraSink) decorateAttrs(attrs map[string]interface{}) {
-	attrs["eventRouterVersion"] = ns.sdkIntegration.IntegrationVersion
-	attrs["integrationVersion"] = ns.sdkIntegration.IntegrationVersion
-	attrs["integrationName"] = ns.sdkIntegration.Name
+	attrs["eventRouterVersion"] = ns.integrationVersion
+	attrs["integrationVersion"] = ns.integrationVersion
+	attrs["integrationName"] = ns.integrationName
 	attrs["clusterName"] = ns.clusterName
 }
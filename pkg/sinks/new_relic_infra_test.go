@@ -3,19 +3,35 @@
 package sinks
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
 
 	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/descriptions"
+	"github.com/newrelic/nri-kube-events/pkg/events"
+	"github.com/newrelic/nri-kube-events/pkg/metrics"
+	"github.com/newrelic/nri-kube-events/pkg/ratelimit"
 )
 
 func TestFormatEntityID(t *testing.T) {
@@ -109,7 +125,7 @@ func TestNewRelicSinkIntegration_HandleEvent_Success(t *testing.T) {
 			"agentEndpoint": testServer.URL,
 		},
 	}
-	sink, _ := createNewRelicInfraSink(config, "0.0.0")
+	sink, _ := createNewRelicInfraSink(config, "0.0.0", prometheus.NewRegistry())
 	err = sink.HandleEvent(common.KubeEvent{
 		Verb: "ADDED",
 		Event: &v1.Event{
@@ -134,16 +150,35 @@ func TestNewRelicSinkIntegration_HandleEvent_Success(t *testing.T) {
 	}
 }
 
+func TestNewRelicInfraSink_CreateTwice(t *testing.T) {
+	config := SinkConfig{
+		Config: map[string]string{
+			"clusterName":   "test-cluster",
+			"agentEndpoint": "http://localhost",
+		},
+	}
+
+	// Sinks created again, like on reloads, share the metrics registered by the previous ones.
+	reg := prometheus.NewRegistry()
+	first, err := createNewRelicInfraSink(config, "0.0.0", reg)
+	assert.NoError(t, err)
+	second, err := createNewRelicInfraSink(config, "0.0.0", reg)
+	assert.NoError(t, err)
+
+	assert.NotSame(t, first.(*newRelicInfraSink).sdkIntegration, second.(*newRelicInfraSink).sdkIntegration)
+}
+
 func TestNewRelicInfraSink_HandleEvent_AddEventError(t *testing.T) {
-	t.Skip("Speak to OHAI about global flags automatically registered when we call integration.New")
 	config := SinkConfig{
 		Config: map[string]string{
 			"clusterName":   "test-cluster",
-			"agentEndpoint": "",
+			"agentEndpoint": "http://localhost:8001/v1/data",
 		},
 	}
-	sink, _ := createNewRelicInfraSink(config, "0.0.0")
-	err := sink.HandleEvent(common.KubeEvent{
+	sink, err := createNewRelicInfraSink(config, "0.0.0", prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	err = sink.HandleEvent(common.KubeEvent{
 		Verb: "ADDED",
 		Event: &v1.Event{
 			Message: "",
@@ -171,3 +206,323 @@ func TestNewRelicInfraSink_HandleEvent_AddEventError(t *testing.T) {
 		t.Errorf("wanted error with message '%s' got: '%v'", wantedError, err)
 	}
 }
+
+func TestNewRelicInfraSink_Metrics(t *testing.T) {
+	agent := httptest.NewServer(&batchingAgent{})
+	defer agent.Close()
+
+	reg := prometheus.NewRegistry()
+	created, err := Create([]SinkConfig{
+		decodeSinkConfig(t, "type: newRelicInfra\nid: first\nconfig:\n  clusterName: test\n  agentEndpoint: "+agent.URL),
+		decodeSinkConfig(t, "type: newRelicInfra\nid: second\nconfig:\n  clusterName: test\n  agentEndpoint: "+agent.URL),
+	}, "0.0.0", WithRegisterer(metrics.WithClusterName(reg, "test")))
+	require.NoError(t, err)
+
+	require.NoError(t, created["first"].HandleEvent(testKubeEvent("pod")))
+	require.NoError(t, created["second"].HandleEvent(testKubeEvent("pod")))
+	require.NoError(t, created["second"].HandleEvent(testKubeEvent("pod")))
+
+	expected := `
+# HELP nr_http_sink_infra_sink_http_responses_total Total amount of http responses, per code, from the New Relic Infra Agent
+# TYPE nr_http_sink_infra_sink_http_responses_total counter
+nr_http_sink_infra_sink_http_responses_total{cluster="test",code="204",sink="first"} 1
+nr_http_sink_infra_sink_http_responses_total{cluster="test",code="204",sink="second"} 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "nr_http_sink_infra_sink_http_responses_total"))
+}
+
+func TestCreateNewRelicInfraSink_InvalidConfig(t *testing.T) {
+	_, err := createNewRelicInfraSink(SinkConfig{Config: map[string]string{"clusterName": "test-cluster"}}, "0.0.0", prometheus.NewRegistry())
+	assert.EqualError(t, err, "agentEndpoint is required")
+}
+
+// batchingAgent records the amount of events in each payload it receives.
+type batchingAgent struct {
+	mtx      sync.Mutex
+	payloads []int
+}
+
+func (a *batchingAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Data []struct {
+			Events []interface{} `json:"events"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	events := 0
+	for _, entity := range payload.Data {
+		events += len(entity.Events)
+	}
+
+	a.mtx.Lock()
+	a.payloads = append(a.payloads, events)
+	a.mtx.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *batchingAgent) received() []int {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return append([]int(nil), a.payloads...)
+}
+
+func createBatchingSink(t *testing.T, agentURL, batch string) *newRelicInfraSink {
+	t.Helper()
+
+	config := decodeSinkConfig(t, `
+name: newRelicInfra
+config:
+  clusterName: test-cluster
+  agentEndpoint: `+agentURL+`
+  batch:
+`+batch)
+
+	sink, err := createNewRelicInfraSink(config, "0.0.0", prometheus.NewRegistry())
+	require.NoError(t, err)
+	return sink.(*newRelicInfraSink)
+}
+
+func testKubeEvent(podName string) common.KubeEvent {
+	return common.KubeEvent{
+		Verb: "ADDED",
+		Event: &v1.Event{
+			Message: "The event message",
+			InvolvedObject: v1.ObjectReference{
+				Kind:      "Pod",
+				Namespace: "test_namespace",
+				Name:      podName,
+			},
+		},
+	}
+}
+
+func TestNewRelicInfraSink_Batching(t *testing.T) {
+	t.Run("sends batches of maxEvents", func(t *testing.T) {
+		agent := &batchingAgent{}
+		server := httptest.NewServer(agent)
+		defer server.Close()
+
+		sink := createBatchingSink(t, server.URL, "    maxEvents: 3\n    maxLatency: 1h\n")
+		require.NoError(t, sink.HandleEvent(testKubeEvent("first")))
+		require.NoError(t, sink.HandleEvent(testKubeEvent("second")))
+		assert.Empty(t, agent.received())
+
+		require.NoError(t, sink.HandleEvent(testKubeEvent("first")))
+		assert.Equal(t, []int{3}, agent.received())
+	})
+
+	t.Run("sends batches before exceeding maxBytes", func(t *testing.T) {
+		agent := &batchingAgent{}
+		server := httptest.NewServer(agent)
+		defer server.Close()
+
+		sink := createBatchingSink(t, server.URL, "    maxEvents: 10\n    maxBytes: 1\n    maxLatency: 1h\n")
+		for _, pod := range []string{"first", "second", "third"} {
+			require.NoError(t, sink.HandleEvent(testKubeEvent(pod)))
+		}
+		assert.Equal(t, []int{1, 1}, agent.received())
+
+		require.NoError(t, sink.Flush(context.Background()))
+		assert.Equal(t, []int{1, 1, 1}, agent.received())
+	})
+
+	t.Run("sends batches after maxLatency", func(t *testing.T) {
+		agent := &batchingAgent{}
+		server := httptest.NewServer(agent)
+		defer server.Close()
+
+		sink := createBatchingSink(t, server.URL, "    maxEvents: 10\n    maxLatency: 10ms\n")
+		require.NoError(t, sink.HandleEvent(testKubeEvent("first")))
+		require.NoError(t, sink.HandleEvent(testKubeEvent("second")))
+
+		assert.Eventually(t, func() bool {
+			return len(agent.received()) == 1
+		}, time.Second, 5*time.Millisecond)
+		assert.Equal(t, []int{2}, agent.received())
+	})
+
+	t.Run("close discards the pending batch", func(t *testing.T) {
+		agent := &batchingAgent{}
+		server := httptest.NewServer(agent)
+		defer server.Close()
+
+		sink := createBatchingSink(t, server.URL, "    maxEvents: 10\n    maxLatency: 10ms\n")
+		require.NoError(t, sink.HandleEvent(testKubeEvent("first")))
+		require.NoError(t, sink.Close())
+
+		time.Sleep(50 * time.Millisecond)
+		assert.Empty(t, agent.received())
+		assert.NoError(t, sink.Flush(context.Background()), "nothing is left to flush")
+	})
+}
+
+func TestNewRelicInfraSink_BatchingInvalidEvent(t *testing.T) {
+	agent := &batchingAgent{}
+	server := httptest.NewServer(agent)
+	defer server.Close()
+
+	sink := createBatchingSink(t, server.URL, "    maxEvents: 10\n    maxLatency: 1h\n")
+	invalid := testKubeEvent("invalid")
+	invalid.Event.Message = ""
+
+	assert.ErrorContains(t, sink.HandleEvent(invalid), "couldn't add event")
+	assert.Empty(t, sink.sdkIntegration.Entities, "entities without events are not sent")
+}
+
+// TestNewRelicInfraSink_ConcurrentRouters runs the events and descriptions routers against the same
+// sink, like main does. Run it with -race to check the sink is safe for concurrent use.
+func TestNewRelicInfraSink_ConcurrentRouters(t *testing.T) {
+	const objects = 50
+
+	agent := &batchingAgent{}
+	server := httptest.NewServer(agent)
+	defer server.Close()
+
+	sink := createBatchingSink(t, server.URL, "    maxEvents: 7\n    maxLatency: 10ms\n")
+
+	var initial []runtime.Object
+	for i := 0; i < objects; i++ {
+		name := fmt.Sprintf("pod-%d", i)
+		initial = append(initial,
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}},
+			&v1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Name: name + ".started", Namespace: "default"},
+				Message:        "Started container",
+				InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: name},
+			},
+		)
+	}
+
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(initial...), 0)
+	eventRouter, err := events.NewRouter(factory.Core().V1().Events().Informer(), map[string]events.EventHandler{"newRelicInfra": sink})
+	require.NoError(t, err)
+	descRouter, err := descriptions.NewRouter([]cache.SharedIndexInformer{factory.Core().V1().Pods().Informer()},
+		map[string]descriptions.ObjectHandler{"newRelicInfra": sink})
+	require.NoError(t, err)
+
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	factory.Start(stopChan)
+	go eventRouter.Run(stopChan)
+	go descRouter.Run(stopChan)
+
+	assert.Eventually(t, func() bool {
+		sent := 0
+		for _, events := range agent.received() {
+			sent += events
+		}
+		return sent == 2*objects
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNewRelicInfraSink_Health(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	sink := createBatchingSink(t, server.URL, "    maxEvents: 1\n")
+	sink.pesterClient.MaxRetries = 1
+	assert.NoError(t, sink.Health(), "sinks are healthy until they send data")
+
+	assert.Error(t, sink.HandleEvent(testKubeEvent("first")))
+	assert.ErrorContains(t, sink.Health(), "unexpected statuscode:500")
+
+	status.Store(http.StatusNoContent)
+	assert.NoError(t, sink.HandleEvent(testKubeEvent("first")))
+	assert.NoError(t, sink.Health())
+
+	status.Store(http.StatusInternalServerError)
+	sink.sendErrTTL = 50 * time.Millisecond
+	assert.Error(t, sink.HandleEvent(testKubeEvent("second")))
+	assert.Error(t, sink.Health())
+	assert.Eventually(t, func() bool { return sink.Health() == nil }, time.Second, 10*time.Millisecond,
+		"send errors are not reported once they expire, even if nothing else is sent")
+}
+
+// transformFunc implements common.AttributeTransform.
+type transformFunc func(attrs map[string]interface{})
+
+func (f transformFunc) Apply(attrs map[string]interface{}) { f(attrs) }
+
+func TestNewRelicInfraSink_Transform(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Data []struct {
+				Events []struct {
+					Attributes map[string]interface{} `json:"attributes"`
+				} `json:"events"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err == nil && len(payload.Data) > 0 && len(payload.Data[0].Events) > 0 {
+			received <- payload.Data[0].Events[0].Attributes
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer agent.Close()
+
+	sink := createBatchingSink(t, agent.URL, "    maxEvents: 1\n")
+
+	kubeEvent := testKubeEvent("TestPod")
+	kubeEvent.Transform = transformFunc(func(attrs map[string]interface{}) {
+		attrs["cluster"] = attrs["clusterName"]
+		delete(attrs, "clusterName")
+		delete(attrs, "event.message")
+	})
+	require.NoError(t, sink.HandleEvent(kubeEvent))
+
+	select {
+	case event := <-received:
+		assert.Equal(t, "test-cluster", event["cluster"], "decorated attributes are transformed")
+		assert.NotContains(t, event, "clusterName")
+		assert.NotContains(t, event, "event.message")
+		assert.Equal(t, "Pod", event["event.involvedObject.kind"])
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no event received")
+	}
+}
+
+func TestNewRelicInfraSink_GlobalRateLimitSummary(t *testing.T) {
+	type entity struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	received := make(chan entity, 1)
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Data []struct {
+				Entity entity `json:"entity"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err == nil && len(payload.Data) > 0 {
+			received <- payload.Data[0].Entity
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer agent.Close()
+
+	limiter, err := ratelimit.New(ratelimit.Config{Global: &ratelimit.Limit{Rate: 1, Burst: 1}}, "test-cluster", prometheus.NewRegistry())
+	require.NoError(t, err)
+	limiter.Allow(testKubeEvent("first"))
+	limiter.Allow(testKubeEvent("second"))
+
+	summaries := limiter.Summaries()
+	require.Len(t, summaries, 1)
+
+	sink := createBatchingSink(t, agent.URL, "    maxEvents: 1\n")
+	require.NoError(t, sink.HandleEvent(summaries[0]))
+
+	select {
+	case e := <-received:
+		assert.Equal(t, entity{Name: "test-cluster", Type: "k8s:test-cluster:cluster"}, e)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no event received")
+	}
+}
@@ -4,10 +4,16 @@
 package sinks
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 
 	"github.com/newrelic/nri-kube-events/pkg/common"
 )
@@ -19,74 +25,195 @@ type Sink interface {
 	HandleObject(kubeObject common.KubeObject) error
 }
 
-// SinkConfig defines the name and config map of an `Sink`
+// SinkConfig defines the type, instance ID and config of an `Sink`.
+// Sinks decode their configuration into typed structs with Decode. Config holds the scalar
+// values of the configuration, and is used as a flat configuration when created by code.
 type SinkConfig struct {
+	// Type is the kind of sink to create, e.g. newRelicInfra.
+	Type string
+	// ID identifies the sink instance in metrics and logs. It defaults to the type,
+	// and must be unique when several sinks of the same type are configured.
+	ID string
+	// Name is the legacy way of setting the type.
 	Name   string
 	Config map[string]string
+
+	// raw is the configuration as found in the YAML file, if any.
+	raw *yaml.Node
 }
 
-// MustGetString returns the string variable by the given name.
-// If it's not present, an error will given and the application will stop.
-func (s SinkConfig) MustGetString(name string) string {
+// idPattern restricts the IDs of sinks to values that can be used as metric labels and in logs.
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// SinkType returns the type of the sink, taken from the legacy Name if Type is not set.
+func (s SinkConfig) SinkType() string {
+	if s.Type != "" {
+		return s.Type
+	}
+	return s.Name
+}
+
+// SinkID returns the instance ID of the sink, which defaults to its type.
+func (s SinkConfig) SinkID() string {
+	if s.ID != "" {
+		return s.ID
+	}
+	return s.SinkType()
+}
+
+// GetString returns the string variable by the given name.
+// An error is returned if it's not present.
+func (s SinkConfig) GetString(name string) (string, error) {
 	val, ok := s.Config[name]
 	if !ok {
-		logrus.Fatalf("Required string variable %s not set for %s Sink", name, s.Name)
+		return "", fmt.Errorf("required string variable %s not set for %s sink", name, s.SinkID())
 	}
-	return val
+	return val, nil
 }
 
 // GetDurationOr returns the duration variable by the given name.
 // It will return the fallback in case the duration is not found.
 // Invalid durations in configuration are not accepted.
-func (s SinkConfig) GetDurationOr(name string, fallback time.Duration) time.Duration {
+func (s SinkConfig) GetDurationOr(name string, fallback time.Duration) (time.Duration, error) {
 	val, ok := s.Config[name]
 	if !ok {
-		return fallback
+		return fallback, nil
 	}
 
 	dur, err := time.ParseDuration(val)
 	if err != nil {
-		logrus.Fatalf("Duration config field '%s' has invalid value of '%s' for %s Sink: %v", name, val, s.Name, err)
+		return 0, fmt.Errorf("duration config field %s has invalid value of %q for %s sink: %w", name, val, s.SinkID(), err)
 	}
 
-	return dur
+	return dur, nil
+}
+
+// sinkFactory creates a sink, registering its metrics with the given registerer.
+type sinkFactory func(config SinkConfig, integrationVersion string, reg prometheus.Registerer) (Sink, error)
+
+// CreateOption sets how sinks are created.
+type CreateOption func(*createConfig)
+
+type createConfig struct {
+	registerer prometheus.Registerer
+}
+
+// WithRegisterer sets the registerer the metrics of the sinks are registered with,
+// the Prometheus default registerer if not set or nil.
+func WithRegisterer(reg prometheus.Registerer) CreateOption {
+	return func(c *createConfig) {
+		if reg != nil {
+			c.registerer = reg
+		}
+	}
 }
 
-type sinkFactory func(config SinkConfig, integrationVersion string) (Sink, error)
+// sinkValidator checks the configuration of a sink without creating it, returning all the errors found.
+type sinkValidator func(config SinkConfig) error
 
 // registeredFactories holds all the registered sinks by this package
 var registeredFactories = map[string]sinkFactory{}
 
-func register(name string, factory sinkFactory) {
+// registeredValidators holds the config validators of the registered sinks
+var registeredValidators = map[string]sinkValidator{}
+
+// registerErr holds the errors found registering sinks. Sinks are registered from init functions,
+// which can't return errors, so they are returned by Validate and Create instead.
+var registerErr error
+
+func register(name string, factory sinkFactory, validator sinkValidator) {
 	if _, ok := registeredFactories[name]; ok {
-		logrus.Fatal("registered a double sink factory")
+		registerErr = errors.Join(registerErr, fmt.Errorf("sink %s registered twice", name))
+		return
 	}
 
 	registeredFactories[name] = factory
+	registeredValidators[name] = validator
+}
+
+// Validate checks that the sink exists and that its configuration is valid.
+// All the problems found are returned, joined in a single error.
+func Validate(config SinkConfig) error {
+	if registerErr != nil {
+		return registerErr
+	}
+
+	if config.SinkType() == "" {
+		return errors.New("sink type not set")
+	}
+
+	if config.Type != "" && config.Name != "" && config.Type != config.Name {
+		return fmt.Errorf("sink type %s and legacy name %s differ, set only the type", config.Type, config.Name)
+	}
+
+	if !idPattern.MatchString(config.SinkID()) {
+		return fmt.Errorf("invalid sink id %q, only letters, digits, '_', '.' and '-' are allowed", config.SinkID())
+	}
+
+	validator, ok := registeredValidators[config.SinkType()]
+	if !ok {
+		return fmt.Errorf("sink not found: %s, expected any of %s", config.SinkType(), strings.Join(Names(), ", "))
+	}
+
+	return validator(config)
+}
+
+// Names returns the sorted names of the registered sinks.
+func Names() []string {
+	names := make([]string, 0, len(registeredFactories))
+	for name := range registeredFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
 }
 
 // Create takes a slice of SinkConfigs and attempts
-// to initialize the sink handlers.
-func Create(configs []SinkConfig, integrationVersion string) (map[string]Sink, error) {
+// to initialize the sink handlers. The sinks are keyed by their instance ID.
+// Sinks must be started with Start before receiving items. If any of them can't be created,
+// the ones already created are closed.
+func Create(configs []SinkConfig, integrationVersion string, opts ...CreateOption) (map[string]Sink, error) {
 	sinks := make(map[string]Sink)
+	if registerErr != nil {
+		return sinks, registerErr
+	}
 
-	for _, sinkConf := range configs {
-		var ok bool
-		var factory sinkFactory
-
-		if factory, ok = registeredFactories[sinkConf.Name]; !ok {
-			return sinks, fmt.Errorf("sink not found: %s", sinkConf.Name)
-		}
+	c := createConfig{registerer: prometheus.DefaultRegisterer}
+	for _, opt := range opts {
+		opt(&c)
+	}
 
-		sink, err := factory(sinkConf, integrationVersion)
+	for _, sinkConf := range configs {
+		sink, err := create(sinkConf, sinks, integrationVersion, c.registerer)
 		if err != nil {
-			return sinks, fmt.Errorf("could not initialize sink %s: %w", sinkConf.Name, err)
+			closeSinks(sinks)
+			return sinks, err
 		}
 
-		logrus.Infof("Created sink: %s", sinkConf.Name)
+		logrus.Infof("Created sink: %s (%s)", sinkConf.SinkID(), sinkConf.SinkType())
 
-		sinks[sinkConf.Name] = sink
+		sinks[sinkConf.SinkID()] = sink
 	}
 
 	return sinks, nil
 }
+
+func create(sinkConf SinkConfig, created map[string]Sink, integrationVersion string, reg prometheus.Registerer) (Sink, error) {
+	id := sinkConf.SinkID()
+	if _, ok := created[id]; ok {
+		return nil, fmt.Errorf("duplicated sink id: %s", id)
+	}
+
+	factory, ok := registeredFactories[sinkConf.SinkType()]
+	if !ok {
+		return nil, fmt.Errorf("sink not found: %s", sinkConf.SinkType())
+	}
+
+	sink, err := factory(sinkConf, integrationVersion, reg)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize sink %s: %w", id, err)
+	}
+
+	return sink, nil
+}
@@ -0,0 +1,344 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package sinks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+type testConfig struct {
+	Endpoint string            `yaml:"endpoint"`
+	Timeout  time.Duration     `yaml:"timeout"`
+	Enabled  bool              `yaml:"enabled"`
+	Headers  map[string]string `yaml:"headers"`
+	Rules    []testRule        `yaml:"rules"`
+}
+
+type testRule struct {
+	Reason string `yaml:"reason"`
+	Drop   bool   `yaml:"drop"`
+}
+
+func (c *testConfig) Default() {
+	if c.Timeout == 0 {
+		c.Timeout = time.Minute
+	}
+}
+
+func (c *testConfig) Validate() error {
+	if c.Endpoint == "" {
+		return errors.New("endpoint is required")
+	}
+	return nil
+}
+
+func decodeSinkConfig(t *testing.T, serialized string) SinkConfig {
+	t.Helper()
+
+	var config SinkConfig
+	require.NoError(t, yaml.Unmarshal([]byte(serialized), &config))
+	return config
+}
+
+func TestSinkConfig_Decode(t *testing.T) {
+	t.Run("nested", func(t *testing.T) {
+		config := decodeSinkConfig(t, `
+name: test
+config:
+  endpoint: http://localhost
+  enabled: true
+  headers:
+    X-Team: platform
+  rules:
+  - reason: BackOff
+    drop: true
+`)
+
+		var got testConfig
+		require.NoError(t, config.Decode(&got))
+		assert.Equal(t, testConfig{
+			Endpoint: "http://localhost",
+			Timeout:  time.Minute,
+			Enabled:  true,
+			Headers:  map[string]string{"X-Team": "platform"},
+			Rules:    []testRule{{Reason: "BackOff", Drop: true}},
+		}, got)
+		assert.Equal(t, map[string]string{"endpoint": "http://localhost", "enabled": "true"}, config.Config)
+	})
+
+	t.Run("flat", func(t *testing.T) {
+		config := SinkConfig{Name: "test", Config: map[string]string{
+			"endpoint": "http://localhost",
+			"timeout":  "30s",
+			"enabled":  "true",
+		}}
+
+		var got testConfig
+		require.NoError(t, config.Decode(&got))
+		assert.Equal(t, testConfig{Endpoint: "http://localhost", Timeout: 30 * time.Second, Enabled: true}, got)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		err := SinkConfig{Name: "test"}.Decode(&testConfig{})
+		assert.EqualError(t, err, "endpoint is required")
+	})
+
+	t.Run("errors point to the configuration file", func(t *testing.T) {
+		config := decodeSinkConfig(t, `
+name: test
+config:
+  endpoint: http://localhost
+  timeout: 10
+  header:
+    X-Team: platform
+`)
+
+		err := config.Decode(&testConfig{})
+		assert.ErrorContains(t, err, "line 5: cannot unmarshal !!int `10` into time.Duration")
+		assert.ErrorContains(t, err, "line 6: field header not found in type sinks.testConfig")
+	})
+
+	t.Run("unknown sink fields", func(t *testing.T) {
+		var config SinkConfig
+		err := yaml.Unmarshal([]byte("name: test\nconfigs: {}\n"), &config)
+		assert.ErrorContains(t, err, "line 2: field configs not found in type sinks.SinkConfig")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config SinkConfig
+		errors []string
+	}{
+		{
+			name:   "stdout",
+			config: SinkConfig{Name: "stdout"},
+		},
+		{
+			name: "newRelicInfra",
+			config: SinkConfig{Name: "newRelicInfra", Config: map[string]string{
+				"clusterName":   "test",
+				"agentEndpoint": "http://localhost:8001/v1/data",
+			}},
+		},
+		{
+			name:   "missing name",
+			config: SinkConfig{},
+			errors: []string{"sink type not set"},
+		},
+		{
+			name:   "type and id",
+			config: SinkConfig{Type: "stdout", ID: "debug-output"},
+		},
+		{
+			name:   "type and name differ",
+			config: SinkConfig{Type: "stdout", Name: "newRelicInfra"},
+			errors: []string{"sink type stdout and legacy name newRelicInfra differ, set only the type"},
+		},
+		{
+			name:   "invalid id",
+			config: SinkConfig{Type: "stdout", ID: "debug output"},
+			errors: []string{`invalid sink id "debug output"`},
+		},
+		{
+			name:   "unknown sink",
+			config: SinkConfig{Name: "kafka"},
+			errors: []string{"sink not found: kafka, expected any of exec, newRelicInfra, stdout"},
+		},
+		{
+			name:   "stdout with legacy config",
+			config: SinkConfig{Name: "stdout", Config: map[string]string{"verbose": "true"}},
+		},
+		{
+			name:   "stdout with config",
+			config: SinkConfig{Name: "stdout", Config: map[string]string{"format": "json"}},
+			errors: []string{"field format not found in type sinks.stdoutConfig"},
+		},
+		{
+			name: "invalid newRelicInfra",
+			config: SinkConfig{Name: "newRelicInfra", Config: map[string]string{
+				"agentEndpoint":    "/v1/data",
+				"agentHTTPTimeout": "-1s",
+			}},
+			errors: []string{
+				"clusterName is required",
+				`agentEndpoint "/v1/data" is not a valid http(s) URL`,
+				"agentHTTPTimeout must be positive, got -1s",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validate(test.config)
+			if len(test.errors) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			var joined interface{ Unwrap() []error }
+			if errors.As(err, &joined) {
+				assert.Len(t, joined.Unwrap(), len(test.errors))
+			}
+			for _, expected := range test.errors {
+				assert.ErrorContains(t, err, expected)
+			}
+		})
+	}
+}
+
+func TestCreate_MultipleInstances(t *testing.T) {
+	infraConfig := func(id, account string) SinkConfig {
+		return SinkConfig{Type: "newRelicInfra", ID: id, Config: map[string]string{
+			"clusterName":   account,
+			"agentEndpoint": "http://localhost",
+		}}
+	}
+
+	created, err := Create([]SinkConfig{
+		infraConfig("account-a", "cluster-a"),
+		infraConfig("account-b", "cluster-b"),
+		{Name: "stdout"},
+	}, "0.0.0")
+	require.NoError(t, err)
+
+	require.Len(t, created, 3)
+	assert.Equal(t, "cluster-a", created["account-a"].(*newRelicInfraSink).clusterName)
+	assert.Equal(t, "cluster-b", created["account-b"].(*newRelicInfraSink).clusterName)
+	assert.Contains(t, created, "stdout")
+
+	_, err = Create([]SinkConfig{infraConfig("account-a", "cluster-a"), infraConfig("account-a", "cluster-b")}, "0.0.0")
+	assert.EqualError(t, err, "duplicated sink id: account-a")
+}
+
+func TestSinkConfig_UnmarshalTypeAndID(t *testing.T) {
+	config := decodeSinkConfig(t, "type: newRelicInfra\nid: account-a\n")
+	assert.Equal(t, "newRelicInfra", config.SinkType())
+	assert.Equal(t, "account-a", config.SinkID())
+
+	legacy := decodeSinkConfig(t, "name: newRelicInfra\n")
+	assert.Equal(t, "newRelicInfra", legacy.SinkType())
+	assert.Equal(t, "newRelicInfra", legacy.SinkID())
+}
+
+type lifecycleSink struct {
+	stdoutSink
+	flushed, closed bool
+	flushErr        error
+}
+
+func (s *lifecycleSink) Flush(_ context.Context) error {
+	s.flushed = true
+	return s.flushErr
+}
+
+func (s *lifecycleSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	healthy := &lifecycleSink{}
+	failing := &lifecycleSink{flushErr: errors.New("agent unreachable")}
+
+	err := Shutdown(context.Background(), map[string]Sink{
+		"healthy": healthy,
+		"failing": failing,
+		"stdout":  &stdoutSink{},
+	})
+
+	assert.EqualError(t, err, "could not flush sink failing: agent unreachable")
+	assert.True(t, healthy.flushed)
+	assert.True(t, healthy.closed)
+	assert.True(t, failing.flushed)
+	assert.True(t, failing.closed, "sinks are closed even if they could not be flushed")
+}
+
+type startableSink struct {
+	lifecycleSink
+	started  bool
+	startErr error
+	health   error
+}
+
+func (s *startableSink) Start(_ context.Context) error {
+	s.started = true
+	return s.startErr
+}
+
+func (s *startableSink) Health() error {
+	return s.health
+}
+
+func TestStart(t *testing.T) {
+	t.Run("starts sinks", func(t *testing.T) {
+		sink := &startableSink{}
+		require.NoError(t, Start(context.Background(), map[string]Sink{"sink": sink, "stdout": &stdoutSink{}}))
+		assert.True(t, sink.started)
+		assert.False(t, sink.closed)
+	})
+
+	t.Run("closes all sinks on failure", func(t *testing.T) {
+		healthy := &startableSink{}
+		failing := &startableSink{startErr: errors.New("connection refused")}
+
+		err := Start(context.Background(), map[string]Sink{"healthy": healthy, "failing": failing})
+		assert.EqualError(t, err, "could not start sink failing: connection refused")
+		assert.True(t, healthy.closed)
+		assert.True(t, failing.closed)
+	})
+}
+
+func TestHealth(t *testing.T) {
+	unhealthy := Health(map[string]Sink{
+		"healthy":   &startableSink{},
+		"unhealthy": &startableSink{health: errors.New("agent unreachable")},
+		"stdout":    &stdoutSink{},
+	})
+
+	assert.Equal(t, map[string]error{"unhealthy": errors.New("agent unreachable")}, unhealthy)
+}
+
+func TestSinkConfig_Getters(t *testing.T) {
+	config := SinkConfig{Type: "stdout", ID: "debug", Config: map[string]string{
+		"endpoint": "http://localhost",
+		"timeout":  "30s",
+		"invalid":  "thirty seconds",
+	}}
+
+	endpoint, err := config.GetString("endpoint")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost", endpoint)
+
+	_, err = config.GetString("missing")
+	assert.EqualError(t, err, "required string variable missing not set for debug sink")
+
+	timeout, err := config.GetDurationOr("timeout", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, timeout)
+
+	timeout, err = config.GetDurationOr("missing", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, timeout)
+
+	_, err = config.GetDurationOr("invalid", time.Second)
+	assert.ErrorContains(t, err, `duration config field invalid has invalid value of "thirty seconds" for debug sink`)
+}
+
+func TestRegister_Twice(t *testing.T) {
+	defer func() { registerErr = nil }()
+
+	register("stdout", createStdoutSink, validateStdoutConfig)
+
+	assert.EqualError(t, Validate(SinkConfig{Type: "stdout"}), "sink stdout registered twice")
+	_, err := Create([]SinkConfig{{Type: "stdout"}}, "0.0.0")
+	assert.EqualError(t, err, "sink stdout registered twice")
+}
@@ -7,16 +7,37 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
 	"github.com/newrelic/nri-kube-events/pkg/common"
 )
 
 func init() {
-	register("stdout", createStdoutSink)
+	register("stdout", createStdoutSink, validateStdoutConfig)
 }
 
-func createStdoutSink(_ SinkConfig, _ string) (Sink, error) {
+// stdoutConfig is the configuration of the stdout sink, which has no settings.
+type stdoutConfig struct {
+	// Verbose is a legacy setting without effect, accepted so configurations written before unknown
+	// keys were rejected keep working.
+	Verbose *bool `yaml:"verbose"`
+}
+
+func validateStdoutConfig(config SinkConfig) error {
+	return config.Decode(&stdoutConfig{})
+}
+
+func createStdoutSink(config SinkConfig, _ string, _ prometheus.Registerer) (Sink, error) {
+	var c stdoutConfig
+	if err := config.Decode(&c); err != nil {
+		return nil, err
+	}
+
+	if c.Verbose != nil {
+		logrus.Warnf("stdout sink: config variable verbose is deprecated and has no effect, remove it")
+	}
+
 	return &stdoutSink{}, nil
 }
 
@@ -0,0 +1,328 @@
+// Package transform drops, renames, adds, copies and truncates the attributes sinks build out of events and descriptions.
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+// wildcard at the end of a key matches any key with the preceding prefix.
+const wildcard = "*"
+
+// Rule is a single step of the transformation. Exactly one of its actions must be set.
+//
+// Keys can end with a `*` to match every attribute starting with the preceding prefix. Renaming or copying
+// with a prefix requires the target to end with `*` too, which is replaced by the rest of the matched key.
+type Rule struct {
+	// Drop removes the attributes with the given keys.
+	Drop []string `yaml:"drop,omitempty"`
+	// Rename moves attributes to a new key, overwriting the attribute at the new key if present.
+	Rename map[string]string `yaml:"rename,omitempty"`
+	// Set adds static attributes, overwriting existing ones.
+	Set map[string]string `yaml:"set,omitempty"`
+	// Copy copies attributes to another key, like a label value to a top level key, keeping the original.
+	Copy map[string]string `yaml:"copy,omitempty"`
+	// Truncate shortens string attributes longer than the given length.
+	Truncate *Truncate `yaml:"truncate,omitempty"`
+}
+
+// Truncate shortens the string attributes with the given keys to MaxLength bytes,
+// without splitting multi-byte characters.
+type Truncate struct {
+	Keys      []string `yaml:"keys"`
+	MaxLength int      `yaml:"maxLength"`
+}
+
+// Validate checks the rule defines exactly one valid action.
+func (r Rule) Validate() error {
+	actions := 0
+	for _, set := range []bool{len(r.Drop) > 0, len(r.Rename) > 0, len(r.Set) > 0, len(r.Copy) > 0, r.Truncate != nil} {
+		if set {
+			actions++
+		}
+	}
+
+	if actions != 1 {
+		return errors.New("exactly one of drop, rename, set, copy or truncate must be set")
+	}
+
+	var errs []error
+	switch {
+	case len(r.Drop) > 0:
+		errs = append(errs, validateKeys("drop", r.Drop)...)
+	case len(r.Rename) > 0:
+		errs = append(errs, validateMapping("rename", r.Rename)...)
+	case len(r.Set) > 0:
+		for key := range r.Set {
+			if key == "" || strings.Contains(key, wildcard) {
+				errs = append(errs, fmt.Errorf("set: invalid key %q, keys can't be empty or contain %s", key, wildcard))
+			}
+		}
+	case len(r.Copy) > 0:
+		errs = append(errs, validateMapping("copy", r.Copy)...)
+	default:
+		if len(r.Truncate.Keys) == 0 {
+			errs = append(errs, errors.New("truncate: keys are required"))
+		}
+		errs = append(errs, validateKeys("truncate", r.Truncate.Keys)...)
+
+		if r.Truncate.MaxLength <= 0 {
+			errs = append(errs, fmt.Errorf("truncate: maxLength must be positive, got %d", r.Truncate.MaxLength))
+		}
+	}
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
+func validateKeys(action string, keys []string) []error {
+	var errs []error
+	for _, key := range keys {
+		if !validPattern(key) {
+			errs = append(errs, fmt.Errorf("%s: invalid key %q, keys can't be empty and can only end with %s", action, key, wildcard))
+		}
+	}
+
+	return errs
+}
+
+func validateMapping(action string, mapping map[string]string) []error {
+	var errs []error
+	for from, to := range mapping {
+		if !validPattern(from) || !validPattern(to) {
+			errs = append(errs, fmt.Errorf("%s: invalid mapping %q to %q, keys can't be empty and can only end with %s", action, from, to, wildcard))
+			continue
+		}
+
+		if strings.HasSuffix(from, wildcard) != strings.HasSuffix(to, wildcard) {
+			errs = append(errs, fmt.Errorf("%s: invalid mapping %q to %q, both keys must end with %s to map a prefix", action, from, to, wildcard))
+		}
+	}
+
+	return errs
+}
+
+func validPattern(key string) bool {
+	return key != "" && key != wildcard && !strings.Contains(strings.TrimSuffix(key, wildcard), wildcard)
+}
+
+// pattern matches an exact key or, if prefix is set, every key starting with it.
+type pattern struct {
+	key    string
+	prefix bool
+}
+
+func newPattern(key string) pattern {
+	if strings.HasSuffix(key, wildcard) {
+		return pattern{key: strings.TrimSuffix(key, wildcard), prefix: true}
+	}
+
+	return pattern{key: key}
+}
+
+// match returns whether the key matches, and the rest of the key after the prefix.
+func (p pattern) match(key string) (string, bool) {
+	if p.prefix {
+		if strings.HasPrefix(key, p.key) {
+			return key[len(p.key):], true
+		}
+		return "", false
+	}
+
+	return "", key == p.key
+}
+
+// mapping maps the keys matching a pattern to a target key.
+type mapping struct {
+	from pattern
+	to   pattern
+}
+
+// target returns the key the given key is mapped to, if it matches.
+func (m mapping) target(key string) (string, bool) {
+	rest, ok := m.from.match(key)
+	if !ok {
+		return "", false
+	}
+
+	return m.to.key + rest, true
+}
+
+// newMappings returns the mappings sorted from the most to the least specific, so the
+// longest pattern a key matches is used.
+func newMappings(rules map[string]string) []mapping {
+	mappings := make([]mapping, 0, len(rules))
+	for from, to := range rules {
+		mappings = append(mappings, mapping{from: newPattern(from), to: newPattern(to)})
+	}
+
+	sort.Slice(mappings, func(i, j int) bool {
+		if len(mappings[i].from.key) != len(mappings[j].from.key) {
+			return len(mappings[i].from.key) > len(mappings[j].from.key)
+		}
+		// Exact keys are more specific than prefixes of the same length.
+		return !mappings[i].from.prefix && mappings[j].from.prefix
+	})
+
+	return mappings
+}
+
+// step applies a single rule to the attributes.
+type step func(attrs map[string]interface{})
+
+// Transformer applies the rules it was created with, in order, to the attributes of events and
+// descriptions. It implements `common.AttributeTransform`, and both the `router.EventProcessor`
+// and the `router.ObjectProcessor` interfaces, attaching itself to the items so sinks apply it
+// to the attributes they build. It is safe for concurrent use.
+type Transformer struct {
+	steps []step
+}
+
+// New returns a Transformer applying the given rules, or an error if any of them is not valid.
+func New(rules []Rule) (*Transformer, error) {
+	var errs []error
+	steps := make([]step, 0, len(rules))
+
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("transforms[%d]: %w", i, err))
+			continue
+		}
+
+		steps = append(steps, newStep(rule))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return &Transformer{steps: steps}, nil
+}
+
+func newStep(rule Rule) step {
+	switch {
+	case len(rule.Drop) > 0:
+		return dropStep(rule.Drop)
+	case len(rule.Rename) > 0:
+		return mapStep(newMappings(rule.Rename), true)
+	case len(rule.Set) > 0:
+		return setStep(rule.Set)
+	case len(rule.Copy) > 0:
+		return mapStep(newMappings(rule.Copy), false)
+	default:
+		return truncateStep(rule.Truncate.Keys, rule.Truncate.MaxLength)
+	}
+}
+
+func newPatterns(keys []string) []pattern {
+	patterns := make([]pattern, 0, len(keys))
+	for _, key := range keys {
+		patterns = append(patterns, newPattern(key))
+	}
+
+	return patterns
+}
+
+func matchAny(patterns []pattern, key string) bool {
+	for _, p := range patterns {
+		if _, ok := p.match(key); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func dropStep(keys []string) step {
+	patterns := newPatterns(keys)
+
+	return func(attrs map[string]interface{}) {
+		for key := range attrs {
+			if matchAny(patterns, key) {
+				delete(attrs, key)
+			}
+		}
+	}
+}
+
+// mapStep copies the matching attributes to their target keys, removing the originals if move is set.
+// All the attributes are mapped at once, so a key can be the source and the target of the same rule.
+func mapStep(mappings []mapping, move bool) step {
+	return func(attrs map[string]interface{}) {
+		mapped := make(map[string]interface{})
+		for key, value := range attrs {
+			for _, m := range mappings {
+				if target, ok := m.target(key); ok {
+					mapped[target] = value
+					if move {
+						delete(attrs, key)
+					}
+					break
+				}
+			}
+		}
+
+		for key, value := range mapped {
+			attrs[key] = value
+		}
+	}
+}
+
+func setStep(values map[string]string) step {
+	return func(attrs map[string]interface{}) {
+		for key, value := range values {
+			attrs[key] = value
+		}
+	}
+}
+
+func truncateStep(keys []string, maxLength int) step {
+	patterns := newPatterns(keys)
+
+	return func(attrs map[string]interface{}) {
+		for key, value := range attrs {
+			s, ok := value.(string)
+			if !ok || len(s) <= maxLength || !matchAny(patterns, key) {
+				continue
+			}
+
+			attrs[key] = truncate(s, maxLength)
+		}
+	}
+}
+
+// truncate cuts the string to at most maxLength bytes, without splitting a multi-byte character.
+func truncate(s string, maxLength int) string {
+	end := maxLength
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+
+	return s[:end]
+}
+
+// Apply transforms the given attributes in place.
+func (t *Transformer) Apply(attrs map[string]interface{}) {
+	for _, s := range t.steps {
+		s(attrs)
+	}
+}
+
+// ProcessEvent attaches the transformer to the event. It never drops events.
+func (t *Transformer) ProcessEvent(kubeEvent *common.KubeEvent) bool {
+	kubeEvent.Transform = t
+	return true
+}
+
+// ProcessObject attaches the transformer to the object. It never drops objects.
+func (t *Transformer) ProcessObject(kubeObject *common.KubeObject) bool {
+	kubeObject.Transform = t
+	return true
+}
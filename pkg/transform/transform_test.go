@@ -0,0 +1,189 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package transform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+func TestTransformer_Apply(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []Rule
+		attrs    map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:     "drop keys and prefixes",
+			rules:    []Rule{{Drop: []string{"event.source.host", "event.metadata.*"}}},
+			attrs:    map[string]interface{}{"event.source.host": "node", "event.metadata.name": "e", "event.metadata.uid": "1", "event.reason": "Started"},
+			expected: map[string]interface{}{"event.reason": "Started"},
+		},
+		{
+			name:     "rename keys",
+			rules:    []Rule{{Rename: map[string]string{"event.reason": "reason", "clusterName": "cluster"}}},
+			attrs:    map[string]interface{}{"event.reason": "Started", "clusterName": "test", "verb": "ADDED"},
+			expected: map[string]interface{}{"reason": "Started", "cluster": "test", "verb": "ADDED"},
+		},
+		{
+			name:     "rename prefixes",
+			rules:    []Rule{{Rename: map[string]string{"event.involvedObject.*": "object.*"}}},
+			attrs:    map[string]interface{}{"event.involvedObject.kind": "Pod", "event.involvedObject.name": "pod", "event.reason": "Started"},
+			expected: map[string]interface{}{"object.kind": "Pod", "object.name": "pod", "event.reason": "Started"},
+		},
+		{
+			name:     "most specific rename wins",
+			rules:    []Rule{{Rename: map[string]string{"event.*": "e.*", "event.reason": "reason"}}},
+			attrs:    map[string]interface{}{"event.reason": "Started", "event.type": "Normal"},
+			expected: map[string]interface{}{"reason": "Started", "e.type": "Normal"},
+		},
+		{
+			name:     "swap keys",
+			rules:    []Rule{{Rename: map[string]string{"a": "b", "b": "a"}}},
+			attrs:    map[string]interface{}{"a": 1, "b": 2},
+			expected: map[string]interface{}{"a": 2, "b": 1},
+		},
+		{
+			name:     "set static attributes",
+			rules:    []Rule{{Set: map[string]string{"team": "platform", "verb": "overwritten"}}},
+			attrs:    map[string]interface{}{"verb": "ADDED"},
+			expected: map[string]interface{}{"team": "platform", "verb": "overwritten"},
+		},
+		{
+			name:  "copy label values",
+			rules: []Rule{{Copy: map[string]string{"involvedObject.labels.app": "app", "missing": "ignored"}}},
+			attrs: map[string]interface{}{"involvedObject.labels.app": "web"},
+			expected: map[string]interface{}{
+				"involvedObject.labels.app": "web",
+				"app":                       "web",
+			},
+		},
+		{
+			name:  "copy prefixes",
+			rules: []Rule{{Copy: map[string]string{"namespace.labels.*": "ns.*"}}},
+			attrs: map[string]interface{}{"namespace.labels.team": "a", "namespace.labels.env": "prod"},
+			expected: map[string]interface{}{
+				"namespace.labels.team": "a", "namespace.labels.env": "prod",
+				"ns.team": "a", "ns.env": "prod",
+			},
+		},
+		{
+			name:     "truncate strings",
+			rules:    []Rule{{Truncate: &Truncate{Keys: []string{"event.message", "summary.*"}, MaxLength: 5}}},
+			attrs:    map[string]interface{}{"event.message": "too long", "summary.part[0]": "short", "event.count": 123456, "event.reason": "untouched"},
+			expected: map[string]interface{}{"event.message": "too l", "summary.part[0]": "short", "event.count": 123456, "event.reason": "untouched"},
+		},
+		{
+			name:     "truncate without splitting characters",
+			rules:    []Rule{{Truncate: &Truncate{Keys: []string{"message"}, MaxLength: 4}}},
+			attrs:    map[string]interface{}{"message": "añño"},
+			expected: map[string]interface{}{"message": "añ"},
+		},
+		{
+			name: "rules are applied in order",
+			rules: []Rule{
+				{Copy: map[string]string{"involvedObject.labels.app": "app"}},
+				{Drop: []string{"involvedObject.*"}},
+				{Rename: map[string]string{"app": "application"}},
+			},
+			attrs:    map[string]interface{}{"involvedObject.labels.app": "web", "involvedObject.nodeName": "node"},
+			expected: map[string]interface{}{"application": "web"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transformer, err := New(test.rules)
+			require.NoError(t, err)
+
+			transformer.Apply(test.attrs)
+			assert.Equal(t, test.expected, test.attrs)
+		})
+	}
+}
+
+func TestNew_InvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		err  string
+	}{
+		{
+			name: "no action",
+			rule: Rule{},
+			err:  "transforms[0]: exactly one of drop, rename, set, copy or truncate must be set",
+		},
+		{
+			name: "several actions",
+			rule: Rule{Drop: []string{"a"}, Set: map[string]string{"b": "c"}},
+			err:  "transforms[0]: exactly one of drop, rename, set, copy or truncate must be set",
+		},
+		{
+			name: "wildcard in the middle",
+			rule: Rule{Drop: []string{"event.*.name"}},
+			err:  `transforms[0]: drop: invalid key "event.*.name", keys can't be empty and can only end with *`,
+		},
+		{
+			name: "wildcard only",
+			rule: Rule{Drop: []string{"*"}},
+			err:  `transforms[0]: drop: invalid key "*", keys can't be empty and can only end with *`,
+		},
+		{
+			name: "prefix mapped to a key",
+			rule: Rule{Copy: map[string]string{"labels.*": "app"}},
+			err:  `transforms[0]: copy: invalid mapping "labels.*" to "app", both keys must end with * to map a prefix`,
+		},
+		{
+			name: "empty target",
+			rule: Rule{Rename: map[string]string{"reason": ""}},
+			err:  `transforms[0]: rename: invalid mapping "reason" to "", keys can't be empty and can only end with *`,
+		},
+		{
+			name: "set with wildcard",
+			rule: Rule{Set: map[string]string{"team.*": "a"}},
+			err:  `transforms[0]: set: invalid key "team.*", keys can't be empty or contain *`,
+		},
+		{
+			name: "truncate without keys",
+			rule: Rule{Truncate: &Truncate{MaxLength: -1}},
+			err:  "transforms[0]: truncate: keys are required\ntruncate: maxLength must be positive, got -1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New([]Rule{test.rule})
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestTransformer_Processors(t *testing.T) {
+	transformer, err := New([]Rule{{Rename: map[string]string{"event.reason": "reason"}}})
+	require.NoError(t, err)
+
+	kubeEvent := common.KubeEvent{
+		Verb:  "ADDED",
+		Event: &v1.Event{ObjectMeta: metav1.ObjectMeta{Name: "event"}, Reason: "Started"},
+	}
+	assert.True(t, transformer.ProcessEvent(&kubeEvent))
+	assert.Same(t, transformer, kubeEvent.Transform)
+
+	attrs, err := common.FlattenStruct(kubeEvent)
+	require.NoError(t, err)
+	kubeEvent.Transform.Apply(attrs)
+	assert.Equal(t, "Started", attrs["reason"])
+	assert.NotContains(t, attrs, "event.reason")
+	assert.NotContains(t, attrs, "Transform", "the transform itself is not flattened")
+
+	kubeObject := common.KubeObject{Verb: "ADDED", Obj: &v1.Pod{}}
+	assert.True(t, transformer.ProcessObject(&kubeObject))
+	assert.Same(t, transformer, kubeObject.Transform)
+}
@@ -0,0 +1,527 @@
+// Package transitions synthesizes events from object condition and phase transitions.
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package transitions
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+)
+
+const (
+	// ActionConditionTransition is the action of events synthesized from condition changes.
+	ActionConditionTransition = "ConditionTransition"
+	// ActionPhaseTransition is the action of events synthesized from phase changes.
+	ActionPhaseTransition = "PhaseTransition"
+	// ActionConditionStuck is the action of events synthesized from conditions exceeding a threshold.
+	ActionConditionStuck = "ConditionStuck"
+	// ActionPhaseStuck is the action of events synthesized from phases exceeding a threshold.
+	ActionPhaseStuck = "PhaseStuck"
+)
+
+// abnormalWhenTrue holds the condition types for which a `True` status reports a problem.
+// Any other condition type is considered healthy when `True`.
+var abnormalWhenTrue = map[string]bool{
+	"MemoryPressure":     true,
+	"DiskPressure":       true,
+	"PIDPressure":        true,
+	"NetworkUnavailable": true,
+	"ReplicaFailure":     true,
+	"Failed":             true,
+	"FailureTarget":      true,
+	"DisruptionTarget":   true,
+}
+
+// abnormalPhases holds the phases that report a problem.
+var abnormalPhases = map[string]bool{
+	"Failed":  true,
+	"Lost":    true,
+	"Unknown": true,
+}
+
+// Publisher receives the synthesized events. It's implemented by `events.Router`.
+type Publisher interface {
+	Publish(kubeEvent common.KubeEvent)
+}
+
+// Lister lists the objects checked against the thresholds. It's implemented by `cache.Store`.
+type Lister interface {
+	List() []interface{}
+}
+
+// Threshold reports the objects of a kind which stay in a phase, or with a condition in a status,
+// for longer than After. Exactly one of Phase and Condition must be set.
+type Threshold struct {
+	Kind      string `yaml:"kind"`
+	Phase     string `yaml:"phase,omitempty"`
+	Condition string `yaml:"condition,omitempty"`
+	// Status of the condition, any unhealthy one if empty: `False` or `Unknown`, or `True` and
+	// `Unknown` for conditions reporting a problem like `MemoryPressure`.
+	Status string        `yaml:"status,omitempty"`
+	After  time.Duration `yaml:"after"`
+}
+
+// Validate checks the threshold selects a single phase or condition for a positive duration.
+func (t Threshold) Validate() error {
+	var errs []error
+	if t.Kind == "" {
+		errs = append(errs, errors.New("kind is required"))
+	}
+
+	switch {
+	case t.Phase == "" && t.Condition == "":
+		errs = append(errs, errors.New("phase or condition is required"))
+	case t.Phase != "" && t.Condition != "":
+		errs = append(errs, errors.New("only one of phase and condition can be set"))
+	case t.Phase != "" && t.Status != "":
+		errs = append(errs, errors.New("status requires a condition"))
+	}
+
+	switch t.Status {
+	case "", string(v1.ConditionTrue), string(v1.ConditionFalse), string(v1.ConditionUnknown):
+	default:
+		errs = append(errs, fmt.Errorf("unsupported status %q, expected %q, %q or %q",
+			t.Status, v1.ConditionTrue, v1.ConditionFalse, v1.ConditionUnknown))
+	}
+
+	if t.After <= 0 {
+		errs = append(errs, fmt.Errorf("after must be positive, got %s", t.After))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Detector implements the `descriptions.ObjectHandler` interface.
+// It compares the previous and the current version of updated objects and publishes
+// a synthesized event for every condition and phase transition found. When run, it also
+// publishes one for every threshold exceeded by an object.
+type Detector struct {
+	publisher  Publisher
+	thresholds []Threshold
+	now        func() time.Time
+
+	// kinds holds the kinds with thresholds, and whether any of them is a phase threshold.
+	kinds map[string]bool
+
+	// mtx guards the phase of the objects of kinds with phase thresholds, and when it was entered.
+	mtx    sync.Mutex
+	phases map[types.UID]phaseEntry
+}
+
+// phaseEntry is the phase of an object, and when it was first seen in it.
+type phaseEntry struct {
+	uid   types.UID
+	phase string
+	since time.Time
+}
+
+// NewDetector returns a Detector which publishes the synthesized events to the given Publisher.
+// The thresholds are expected to be valid.
+func NewDetector(publisher Publisher, thresholds ...Threshold) *Detector {
+	kinds := make(map[string]bool)
+	for _, t := range thresholds {
+		kinds[t.Kind] = kinds[t.Kind] || t.Phase != ""
+	}
+
+	return &Detector{
+		publisher:  publisher,
+		thresholds: thresholds,
+		now:        time.Now,
+		kinds:      kinds,
+		phases:     make(map[types.UID]phaseEntry),
+	}
+}
+
+// HandleObject publishes an event for every transition between the old and the new object.
+// Newly added objects don't have any transition. It also records when objects enter a phase,
+// so phase thresholds are timed from it.
+func (d *Detector) HandleObject(kubeObj common.KubeObject) error {
+	if kubeObj.Obj == nil {
+		return nil
+	}
+
+	hasPhases := len(d.kinds) > 0 && d.kinds[common.K8SObjGetGVK(kubeObj.Obj).Kind]
+	if kubeObj.OldObj == nil && !hasPhases {
+		return nil
+	}
+
+	newStatus, err := statusOf(kubeObj.Obj)
+	if err != nil {
+		return fmt.Errorf("reading new object status: %w", err)
+	}
+
+	if hasPhases {
+		if _, err := d.recordPhase(kubeObj.Obj, newStatus.Phase, d.now()); err != nil {
+			return err
+		}
+	}
+
+	if kubeObj.OldObj == nil {
+		return nil
+	}
+
+	oldStatus, err := statusOf(kubeObj.OldObj)
+	if err != nil {
+		return fmt.Errorf("reading old object status: %w", err)
+	}
+
+	transitions := newStatus.transitionsFrom(oldStatus)
+	if len(transitions) == 0 {
+		return nil
+	}
+
+	return d.publish(kubeObj.Obj, transitions)
+}
+
+func (d *Detector) publish(obj runtime.Object, transitions []transition) error {
+	involvedObject, err := referenceTo(obj)
+	if err != nil {
+		return fmt.Errorf("building object reference: %w", err)
+	}
+
+	for _, t := range transitions {
+		d.publisher.Publish(common.KubeEvent{
+			Verb:        "ADDED",
+			Event:       d.newEvent(involvedObject, t),
+			Synthesized: true,
+		})
+	}
+
+	return nil
+}
+
+// Run checks the objects listed by the given listers against the thresholds every interval, until the
+// stop channel is closed. An event is published for every threshold exceeded since the previous check,
+// so objects are reported once every time they exceed one.
+func (d *Detector) Run(stopChan <-chan struct{}, interval time.Duration, listers ...Lister) {
+	if len(d.thresholds) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastCheck time.Time
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			lastCheck = d.check(lastCheck, listers)
+		}
+	}
+}
+
+// check publishes an event for every threshold exceeded after the last check, returning the time of this one.
+// Phases of the objects no longer listed are forgotten.
+func (d *Detector) check(lastCheck time.Time, listers []Lister) time.Time {
+	now := d.now()
+	listed := make(map[types.UID]bool)
+
+	for _, lister := range listers {
+		for _, item := range lister.List() {
+			obj, ok := item.(runtime.Object)
+			if !ok {
+				continue
+			}
+
+			kind := common.K8SObjGetGVK(obj).Kind
+			hasPhases, ok := d.kinds[kind]
+			if !ok {
+				continue
+			}
+
+			if err := d.checkObject(obj, kind, hasPhases, lastCheck, now, listed); err != nil {
+				logrus.Debugf("could not check %s against the stuck thresholds: %v", kind, err)
+			}
+		}
+	}
+
+	d.mtx.Lock()
+	for uid := range d.phases {
+		if !listed[uid] {
+			delete(d.phases, uid)
+		}
+	}
+	d.mtx.Unlock()
+
+	return now
+}
+
+func (d *Detector) checkObject(obj runtime.Object, kind string, hasPhases bool, lastCheck, now time.Time, listed map[types.UID]bool) error {
+	st, err := statusOf(obj)
+	if err != nil {
+		return fmt.Errorf("reading object status: %w", err)
+	}
+
+	var phase phaseEntry
+	if hasPhases {
+		if phase, err = d.recordPhase(obj, st.Phase, now); err != nil {
+			return err
+		}
+		listed[phase.uid] = true
+	}
+
+	if stuck := d.exceeded(kind, st, phase.since, lastCheck, now); len(stuck) > 0 {
+		return d.publish(obj, stuck)
+	}
+
+	return nil
+}
+
+// recordPhase returns the given phase of the object and when it entered it, recording the given time
+// if the object is seen in it for the first time. Objects don't record when they entered their phase,
+// so the phase of the objects found when starting is timed from then.
+func (d *Detector) recordPhase(obj runtime.Object, phase string, now time.Time) (phaseEntry, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return phaseEntry{}, fmt.Errorf("reading object metadata: %w", err)
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	entry, ok := d.phases[accessor.GetUID()]
+	if !ok || entry.phase != phase {
+		entry = phaseEntry{uid: accessor.GetUID(), phase: phase, since: now}
+		d.phases[accessor.GetUID()] = entry
+	}
+
+	return entry, nil
+}
+
+func (d *Detector) newEvent(involvedObject v1.ObjectReference, t transition) *v1.Event {
+	now := metav1.NewTime(d.now())
+
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", involvedObject.Name, now.UnixNano()),
+			Namespace: involvedObject.Namespace,
+		},
+		InvolvedObject:      involvedObject,
+		Action:              t.action,
+		Reason:              t.reason,
+		Message:             t.message,
+		Type:                t.eventType,
+		Count:               1,
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Source:              v1.EventSource{Component: common.Component},
+		ReportingController: common.ReportingController,
+	}
+}
+
+type condition struct {
+	Type               string
+	Status             string
+	Reason             string
+	Message            string
+	LastTransitionTime time.Time
+}
+
+type status struct {
+	Phase      string
+	Reason     string
+	Conditions map[string]condition
+}
+
+type transition struct {
+	action    string
+	reason    string
+	message   string
+	eventType string
+}
+
+// transitionsFrom returns the transitions from the old status to this one.
+// Conditions which are not present in the old status are not considered a transition,
+// since they are usually added once by the controller owning the object.
+func (s status) transitionsFrom(old status) []transition {
+	var transitions []transition
+
+	if old.Phase != "" && s.Phase != "" && old.Phase != s.Phase {
+		reason := s.Reason
+		if reason == "" {
+			reason = "PhaseChanged"
+		}
+
+		transitions = append(transitions, transition{
+			action:    ActionPhaseTransition,
+			reason:    reason,
+			message:   fmt.Sprintf("Phase changed from %s to %s", old.Phase, s.Phase),
+			eventType: eventType(!abnormalPhases[s.Phase]),
+		})
+	}
+
+	for _, condType := range sortedKeys(s.Conditions) {
+		cond := s.Conditions[condType]
+		oldCond, ok := old.Conditions[condType]
+		if !ok || oldCond.Status == cond.Status {
+			continue
+		}
+
+		reason := cond.Reason
+		if reason == "" {
+			reason = cond.Type + "Changed"
+		}
+
+		message := fmt.Sprintf("Condition %s changed from %s to %s", cond.Type, oldCond.Status, cond.Status)
+		if cond.Message != "" {
+			message = fmt.Sprintf("%s: %s", message, cond.Message)
+		}
+
+		transitions = append(transitions, transition{
+			action:    ActionConditionTransition,
+			reason:    reason,
+			message:   message,
+			eventType: eventType(isHealthy(cond)),
+		})
+	}
+
+	return transitions
+}
+
+// exceeded returns a transition for every threshold of the kind exceeded by the object after the last check.
+// Conditions are timed from their last transition, and phases from the given time.
+func (d *Detector) exceeded(kind string, st status, phaseSince, lastCheck, now time.Time) []transition {
+	var exceeded []transition
+
+	for _, t := range d.thresholds {
+		if t.Kind != kind {
+			continue
+		}
+
+		if t.Phase != "" {
+			if st.Phase == t.Phase && crossed(phaseSince.Add(t.After), lastCheck, now) {
+				exceeded = append(exceeded, transition{
+					action:    ActionPhaseStuck,
+					reason:    ActionPhaseStuck,
+					message:   fmt.Sprintf("Phase %s for %s, longer than %s", st.Phase, now.Sub(phaseSince).Round(time.Second), t.After),
+					eventType: v1.EventTypeWarning,
+				})
+			}
+			continue
+		}
+
+		cond, ok := st.Conditions[t.Condition]
+		if !ok || (t.Status == "" && isHealthy(cond)) || (t.Status != "" && cond.Status != t.Status) {
+			continue
+		}
+
+		if !cond.LastTransitionTime.IsZero() && crossed(cond.LastTransitionTime.Add(t.After), lastCheck, now) {
+			message := fmt.Sprintf("Condition %s %s for %s, longer than %s",
+				cond.Type, cond.Status, now.Sub(cond.LastTransitionTime).Round(time.Second), t.After)
+			if cond.Message != "" {
+				message = fmt.Sprintf("%s: %s", message, cond.Message)
+			}
+
+			exceeded = append(exceeded, transition{
+				action:    ActionConditionStuck,
+				reason:    ActionConditionStuck,
+				message:   message,
+				eventType: v1.EventTypeWarning,
+			})
+		}
+	}
+
+	return exceeded
+}
+
+// crossed returns whether the deadline is after the last check, and not after now.
+func crossed(deadline, lastCheck, now time.Time) bool {
+	return deadline.After(lastCheck) && !deadline.After(now)
+}
+
+func isHealthy(cond condition) bool {
+	if abnormalWhenTrue[cond.Type] {
+		return cond.Status == string(v1.ConditionFalse)
+	}
+
+	return cond.Status == string(v1.ConditionTrue)
+}
+
+func eventType(healthy bool) string {
+	if healthy {
+		return v1.EventTypeNormal
+	}
+
+	return v1.EventTypeWarning
+}
+
+// statusOf reads the phase and conditions of any object following the usual API conventions.
+func statusOf(obj runtime.Object) (status, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return status{}, err
+	}
+
+	st := status{Conditions: map[string]condition{}}
+	raw, ok := u["status"].(map[string]interface{})
+	if !ok {
+		return st, nil
+	}
+
+	st.Phase, _ = raw["phase"].(string)
+	st.Reason, _ = raw["reason"].(string)
+
+	conditions, _ := raw["conditions"].([]interface{})
+	for _, c := range conditions {
+		m, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		cond := condition{}
+		cond.Type, _ = m["type"].(string)
+		cond.Status, _ = m["status"].(string)
+		cond.Reason, _ = m["reason"].(string)
+		cond.Message, _ = m["message"].(string)
+		if lastTransition, ok := m["lastTransitionTime"].(string); ok {
+			cond.LastTransitionTime, _ = time.Parse(time.RFC3339, lastTransition)
+		}
+		if cond.Type != "" {
+			st.Conditions[cond.Type] = cond
+		}
+	}
+
+	return st, nil
+}
+
+func referenceTo(obj runtime.Object) (v1.ObjectReference, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return v1.ObjectReference{}, err
+	}
+
+	gvk := common.K8SObjGetGVK(obj)
+
+	return v1.ObjectReference{
+		Kind:            gvk.Kind,
+		APIVersion:      gvk.GroupVersion().String(),
+		Namespace:       accessor.GetNamespace(),
+		Name:            accessor.GetName(),
+		UID:             accessor.GetUID(),
+		ResourceVersion: accessor.GetResourceVersion(),
+	}, nil
+}
+
+func sortedKeys(conditions map[string]condition) []string {
+	keys := make([]string, 0, len(conditions))
+	for k := range conditions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
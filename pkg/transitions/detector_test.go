@@ -0,0 +1,299 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+package transitions_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/newrelic/nri-kube-events/pkg/common"
+	"github.com/newrelic/nri-kube-events/pkg/transitions"
+)
+
+type recordingPublisher struct {
+	events []common.KubeEvent
+}
+
+func (r *recordingPublisher) Publish(kubeEvent common.KubeEvent) {
+	r.events = append(r.events, kubeEvent)
+}
+
+func node(conditions ...v1.NodeCondition) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-1", UID: "node-uid"},
+		Status:     v1.NodeStatus{Conditions: conditions},
+	}
+}
+
+func pvc(phase v1.PersistentVolumeClaimPhase) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "db"},
+		Status:     v1.PersistentVolumeClaimStatus{Phase: phase},
+	}
+}
+
+func deployment(status v1.ConditionStatus, reason string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Status: appsv1.DeploymentStatus{
+			Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentProgressing, Status: status, Reason: reason, Message: "some message"},
+			},
+		},
+	}
+}
+
+func TestDetector_HandleObject(t *testing.T) {
+	type expected struct {
+		kind, namespace, name string
+		action, reason        string
+		message, eventType    string
+	}
+
+	tests := []struct {
+		name     string
+		old, new runtime.Object
+		expected []expected
+	}{
+		{
+			name: "node becomes not ready",
+			old:  node(v1.NodeCondition{Type: v1.NodeReady, Status: v1.ConditionTrue, Reason: "KubeletReady"}),
+			new:  node(v1.NodeCondition{Type: v1.NodeReady, Status: v1.ConditionFalse, Reason: "KubeletNotReady"}),
+			expected: []expected{{
+				kind:      "Node",
+				name:      "worker-1",
+				action:    transitions.ActionConditionTransition,
+				reason:    "KubeletNotReady",
+				message:   "Condition Ready changed from True to False",
+				eventType: v1.EventTypeWarning,
+			}},
+		},
+		{
+			name: "node pressure recovers",
+			old:  node(v1.NodeCondition{Type: v1.NodeMemoryPressure, Status: v1.ConditionTrue}),
+			new:  node(v1.NodeCondition{Type: v1.NodeMemoryPressure, Status: v1.ConditionFalse}),
+			expected: []expected{{
+				kind:      "Node",
+				name:      "worker-1",
+				action:    transitions.ActionConditionTransition,
+				reason:    "MemoryPressureChanged",
+				message:   "Condition MemoryPressure changed from True to False",
+				eventType: v1.EventTypeNormal,
+			}},
+		},
+		{
+			name: "deployment stops progressing",
+			old:  deployment(v1.ConditionTrue, "NewReplicaSetAvailable"),
+			new:  deployment(v1.ConditionFalse, "ProgressDeadlineExceeded"),
+			expected: []expected{{
+				kind:      "Deployment",
+				namespace: "default",
+				name:      "web",
+				action:    transitions.ActionConditionTransition,
+				reason:    "ProgressDeadlineExceeded",
+				message:   "Condition Progressing changed from True to False: some message",
+				eventType: v1.EventTypeWarning,
+			}},
+		},
+		{
+			name: "pvc is lost",
+			old:  pvc(v1.ClaimBound),
+			new:  pvc(v1.ClaimLost),
+			expected: []expected{{
+				kind:      "PersistentVolumeClaim",
+				namespace: "db",
+				name:      "data",
+				action:    transitions.ActionPhaseTransition,
+				reason:    "PhaseChanged",
+				message:   "Phase changed from Bound to Lost",
+				eventType: v1.EventTypeWarning,
+			}},
+		},
+		{
+			name: "unchanged status",
+			old:  pvc(v1.ClaimPending),
+			new:  pvc(v1.ClaimPending),
+		},
+		{
+			name: "new condition is not a transition",
+			old:  node(),
+			new:  node(v1.NodeCondition{Type: v1.NodeReady, Status: v1.ConditionTrue}),
+		},
+		{
+			name: "added object",
+			new:  pvc(v1.ClaimPending),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher := &recordingPublisher{}
+			err := transitions.NewDetector(publisher).HandleObject(common.KubeObject{
+				Verb:   "UPDATE",
+				Obj:    tt.new,
+				OldObj: tt.old,
+			})
+			require.NoError(t, err)
+			require.Len(t, publisher.events, len(tt.expected))
+
+			for i, exp := range tt.expected {
+				ke := publisher.events[i]
+				assert.True(t, ke.Synthesized)
+				assert.Equal(t, "ADDED", ke.Verb)
+				assert.Equal(t, exp.kind, ke.Event.InvolvedObject.Kind)
+				assert.Equal(t, exp.namespace, ke.Event.InvolvedObject.Namespace)
+				assert.Equal(t, exp.name, ke.Event.InvolvedObject.Name)
+				assert.Equal(t, exp.namespace, ke.Event.Namespace)
+				assert.Equal(t, exp.action, ke.Event.Action)
+				assert.Equal(t, exp.reason, ke.Event.Reason)
+				assert.Equal(t, exp.message, ke.Event.Message)
+				assert.Equal(t, exp.eventType, ke.Event.Type)
+				assert.Equal(t, common.Component, ke.Event.Source.Component)
+				assert.Equal(t, common.ReportingController, ke.Event.ReportingController)
+				assert.EqualValues(t, 1, ke.Event.Count)
+			}
+		})
+	}
+}
+
+// channelPublisher sends the published events to a channel, for detectors running in their own goroutine.
+type channelPublisher chan common.KubeEvent
+
+func (c channelPublisher) Publish(kubeEvent common.KubeEvent) {
+	c <- kubeEvent
+}
+
+// sliceLister implements transitions.Lister.
+type sliceLister []interface{}
+
+func (s sliceLister) List() []interface{} {
+	return s
+}
+
+// receive returns the next event published, failing if there is none within the timeout.
+func receive(t *testing.T, published channelPublisher, timeout time.Duration) common.KubeEvent {
+	t.Helper()
+
+	select {
+	case ke := <-published:
+		return ke
+	case <-time.After(timeout):
+		require.FailNow(t, "no event published")
+		return common.KubeEvent{}
+	}
+}
+
+func TestDetector_ConditionThresholds(t *testing.T) {
+	hourAgo := metav1.NewTime(time.Now().Add(-time.Hour))
+	minuteAgo := metav1.NewTime(time.Now().Add(-time.Minute))
+
+	nodeWith := func(name string, conditions ...v1.NodeCondition) *v1.Node {
+		n := node(conditions...)
+		n.Name = name
+		n.UID = types.UID(name)
+		return n
+	}
+
+	nodes := sliceLister{
+		nodeWith("not-ready", v1.NodeCondition{Type: v1.NodeReady, Status: v1.ConditionFalse, LastTransitionTime: hourAgo, Message: "kubelet stopped posting status"}),
+		nodeWith("memory-pressure", v1.NodeCondition{Type: v1.NodeMemoryPressure, Status: v1.ConditionTrue, LastTransitionTime: hourAgo}),
+		nodeWith("ready", v1.NodeCondition{Type: v1.NodeReady, Status: v1.ConditionTrue, LastTransitionTime: hourAgo}),
+		nodeWith("not-ready-recently", v1.NodeCondition{Type: v1.NodeReady, Status: v1.ConditionFalse, LastTransitionTime: minuteAgo}),
+	}
+
+	published := make(channelPublisher, 10)
+	detector := transitions.NewDetector(published,
+		transitions.Threshold{Kind: "Node", Condition: "Ready", After: 30 * time.Minute},
+		transitions.Threshold{Kind: "Node", Condition: "MemoryPressure", After: 30 * time.Minute},
+	)
+
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	go detector.Run(stopChan, 10*time.Millisecond, nodes)
+
+	messages := map[string]string{}
+	for range 2 {
+		ke := receive(t, published, 5*time.Second)
+		assert.True(t, ke.Synthesized)
+		assert.Equal(t, transitions.ActionConditionStuck, ke.Event.Action)
+		assert.Equal(t, transitions.ActionConditionStuck, ke.Event.Reason)
+		assert.Equal(t, v1.EventTypeWarning, ke.Event.Type)
+		messages[ke.Event.InvolvedObject.Name] = ke.Event.Message
+	}
+
+	assert.Regexp(t, `^Condition Ready False for 1h0m[0-9]+s, longer than 30m0s: kubelet stopped posting status$`, messages["not-ready"])
+	assert.Regexp(t, `^Condition MemoryPressure True for 1h0m[0-9]+s, longer than 30m0s$`, messages["memory-pressure"])
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, published, "objects are reported once while they stay in the same state")
+}
+
+func TestDetector_PhaseThresholds(t *testing.T) {
+	hourAgo := metav1.NewTime(time.Now().Add(-time.Hour))
+
+	claim := func(name string, phase v1.PersistentVolumeClaimPhase) *v1.PersistentVolumeClaim {
+		c := pvc(phase)
+		c.Name = name
+		c.UID = types.UID(name)
+		c.CreationTimestamp = hourAgo
+		return c
+	}
+
+	published := make(channelPublisher, 10)
+	detector := transitions.NewDetector(published,
+		transitions.Threshold{Kind: "PersistentVolumeClaim", Phase: "Pending", After: 200 * time.Millisecond},
+	)
+
+	// An old claim going back to Pending is timed from the transition, not from its creation.
+	require.NoError(t, detector.HandleObject(common.KubeObject{
+		Verb:   "UPDATE",
+		OldObj: claim("rebound", v1.ClaimBound),
+		Obj:    claim("rebound", v1.ClaimPending),
+	}))
+	require.Len(t, published, 1, "the phase transition is published")
+	<-published
+
+	claims := sliceLister{claim("rebound", v1.ClaimPending), claim("bound", v1.ClaimBound)}
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	go detector.Run(stopChan, 10*time.Millisecond, claims)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, published, "phases are timed from when they are entered")
+
+	ke := receive(t, published, 5*time.Second)
+	assert.Equal(t, "rebound", ke.Event.InvolvedObject.Name)
+	assert.Equal(t, transitions.ActionPhaseStuck, ke.Event.Action)
+	assert.Regexp(t, `^Phase Pending for [0-9]+s, longer than 200ms$`, ke.Event.Message)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, published, "objects are reported once while they stay in the same phase")
+}
+
+func TestThreshold_Validate(t *testing.T) {
+	assert.NoError(t, transitions.Threshold{Kind: "Node", Condition: "Ready", Status: "Unknown", After: time.Minute}.Validate())
+	assert.NoError(t, transitions.Threshold{Kind: "PersistentVolumeClaim", Phase: "Pending", After: time.Minute}.Validate())
+
+	err := transitions.Threshold{Phase: "Pending", Condition: "Ready", Status: "Maybe"}.Validate()
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		"kind is required",
+		"only one of phase and condition can be set",
+		`unsupported status "Maybe", expected "True", "False" or "Unknown"`,
+		"after must be positive, got 0s",
+	}, strings.Split(err.Error(), "\n"))
+
+	assert.EqualError(t, transitions.Threshold{Kind: "Pod", Phase: "Pending", Status: "False", After: time.Minute}.Validate(),
+		"status requires a condition")
+	assert.EqualError(t, transitions.Threshold{Kind: "Pod", After: time.Minute}.Validate(),
+		"phase or condition is required")
+}
@@ -372,11 +372,17 @@ func initialize(t *testing.T) (*kubernetes.Clientset, *integration.TestAgentSink
 	}
 
 	if testSinkInstance == nil {
-		testSinkInstance = integration.NewTestAgentSink()
+		testSinkInstance, err = integration.NewTestAgentSink()
+		if err != nil {
+			t.Fatalf("could not create test agent sink: %v", err)
+		}
 	}
 	testSinkInstance.ForgetEvents()
 
-	router := events.NewRouter(eventsInformer, map[string]events.EventHandler{"mock": testSinkInstance})
+	router, err := events.NewRouter(eventsInformer, map[string]events.EventHandler{"mock": testSinkInstance})
+	if err != nil {
+		t.Fatalf("could not create router: %v", err)
+	}
 	go router.Run(nil)
 
 	return client, testSinkInstance
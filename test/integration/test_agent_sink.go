@@ -35,7 +35,7 @@ type TestAgentSink struct {
 }
 
 // NewTestAgentSink returns an instrumented infra-agent sink for testing.
-func NewTestAgentSink() *TestAgentSink {
+func NewTestAgentSink() (*TestAgentSink, error) {
 	mockedAgentSink := &TestAgentSink{
 		mtx:               &sync.RWMutex{},
 		eventReceivedChan: make(chan struct{}, sinkChanBuffer),
@@ -52,17 +52,17 @@ func NewTestAgentSink() *TestAgentSink {
 
 	createdSinks, err := sinks.Create([]sinks.SinkConfig{agentSinkConfig}, "0.0.0")
 	if err != nil {
-		log.Fatalf("error creating infra sink: %v", err)
+		return nil, fmt.Errorf("error creating infra sink: %w", err)
 	}
 
 	agentSink, ok := createdSinks[newRelicInfraSinkID]
 	if !ok {
-		log.Fatal("could not retrieve agent infra sink from map")
+		return nil, errors.New("could not retrieve agent infra sink from map")
 	}
 
 	mockedAgentSink.agentSink = agentSink
 
-	return mockedAgentSink
+	return mockedAgentSink, nil
 }
 
 // HandleEvent sends a notification to the event received channel and then forwards it to the underlying sink.